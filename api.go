@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/newhook/go-symbols/symbols"
+)
+
+// runAPI implements the api command, which writes the exported API of the
+// packages of a tree, sorted and one feature per line, for checking that
+// later versions remain compatible.
+func runAPI(args []string) error {
+	// As for the go command, dir/... names the packages of dir.
+	if len(args) > 0 && strings.HasSuffix(args[0], "...") {
+		args[0] = filepath.Clean(strings.TrimSuffix(args[0], "..."))
+	}
+	dir, _ := parseArgs(args)
+	lines, errs := symbols.API(context.Background(), typeCheckOptions(dir))
+	for _, e := range errs {
+		logger.Warn("could not read package", "dir", e.Dir, "err", e.Message)
+		partial = true
+	}
+	out, commit, err := createOutput()
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(out)
+	for _, line := range lines {
+		fmt.Fprintln(w, line)
+	}
+	return commit(w.Flush())
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/newhook/go-symbols/symbols"
+)
+
+// runAPIDiff implements the apidiff command, which compares two APIs, each
+// that of a tree or one written to a file by the api command, and writes
+// the features removed, changed and added. It exits with exitPartial if any
+// change is incompatible: a removal or a change.
+func runAPIDiff(args []string) error {
+	if len(args) != 2 {
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+	if !flagSet("format") {
+		*formatFlag = "" // nor are errors written as json
+	} else if *formatFlag != "json" {
+		return usagef("apidiff supports only -format json")
+	}
+	oldAPI, err := readAPI(args[0])
+	if err != nil {
+		return err
+	}
+	newAPI, err := readAPI(args[1])
+	if err != nil {
+		return err
+	}
+	d := diffAPI(oldAPI, newAPI)
+	if len(d.Removed) > 0 || len(d.Changed) > 0 {
+		partial = true
+	}
+	if *formatFlag == "json" {
+		b, err := json.MarshalIndent(d, "", " ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(os.Stdout, "%s\n", b)
+		return err
+	}
+	return d.write(os.Stdout)
+}
+
+// readAPI returns the API of the tree dir, or if it is a file, the one it
+// holds as written by the api command.
+func readAPI(dir string) ([]string, error) {
+	if fi, err := os.Stat(dir); err != nil {
+		return nil, err
+	} else if !fi.IsDir() {
+		data, err := ioutil.ReadFile(dir)
+		if err != nil {
+			return nil, err
+		}
+		var lines []string
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				lines = append(lines, line)
+			}
+		}
+		return lines, nil
+	}
+	lines, errs := symbols.API(context.Background(), typeCheckOptions(dir))
+	for _, e := range errs {
+		// Their API would seem removed.
+		logger.Warn("could not read package", "dir", e.Dir, "err", e.Message)
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("the API of %s is incomplete", dir)
+	}
+	return lines, nil
+}
+
+// An apiDiff lists the differences between two APIs.
+type apiDiff struct {
+	Removed []string    `json:"removed"`
+	Changed []apiChange `json:"changed"`
+	Added   []string    `json:"added"`
+}
+
+// An apiChange is a feature whose type changed.
+type apiChange struct {
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+// diffAPI compares the features of two APIs. A feature present in both
+// under the same name but with a different type is changed.
+func diffAPI(oldAPI, newAPI []string) *apiDiff {
+	d := &apiDiff{Removed: []string{}, Changed: []apiChange{}, Added: []string{}}
+	inOld := make(map[string]bool, len(oldAPI))
+	for _, f := range oldAPI {
+		inOld[f] = true
+	}
+	inNew := make(map[string]bool, len(newAPI))
+	added := make(map[string][]string) // by name
+	for _, f := range newAPI {
+		inNew[f] = true
+		if !inOld[f] {
+			added[featureName(f)] = append(added[featureName(f)], f)
+		}
+	}
+	for _, f := range oldAPI {
+		if inNew[f] {
+			continue
+		}
+		name := featureName(f)
+		if list := added[name]; len(list) == 1 {
+			d.Changed = append(d.Changed, apiChange{f, list[0]})
+			delete(added, name)
+			continue
+		}
+		d.Removed = append(d.Removed, f)
+	}
+	for _, list := range added {
+		d.Added = append(d.Added, list...)
+	}
+	sort.Strings(d.Removed)
+	sort.Slice(d.Changed, func(i, j int) bool { return d.Changed[i].Old < d.Changed[j].Old })
+	sort.Strings(d.Added)
+	return d
+}
+
+// featureName returns the part of an api line naming the feature, without
+// its type: the line up to the name of the func, method, variable,
+// constant, type, field or interface method it describes.
+func featureName(line string) string {
+	pkg, rest := "", line
+	if i := strings.Index(line, ", "); i >= 0 && strings.HasPrefix(line, "pkg ") {
+		pkg, rest = line[:i+2], line[i+2:]
+	}
+	kind, rest := cutWord(rest)
+	switch kind {
+	case "func":
+		return pkg + kind + " " + identPrefix(rest)
+	case "method":
+		recv := rest
+		if i := strings.Index(rest, ") "); i >= 0 {
+			recv, rest = rest[:i+2], rest[i+2:]
+		}
+		return pkg + kind + " " + recv + identPrefix(rest)
+	case "var", "const":
+		name, rest := cutWord(rest)
+		if kind == "const" && strings.HasPrefix(rest, "= ") {
+			name += " ="
+		}
+		return pkg + kind + " " + name
+	case "type":
+		name := identPrefix(rest)
+		rest = skipTypeParams(rest[len(name):])
+		key := pkg + kind + " " + name
+		switch {
+		case strings.HasPrefix(rest, " struct, embedded "), strings.HasPrefix(rest, " interface, embedded "):
+			return key + rest
+		case strings.HasPrefix(rest, " struct, "):
+			field, _ := cutWord(strings.TrimPrefix(rest, " struct, "))
+			return key + " struct, " + field
+		case strings.HasPrefix(rest, " interface, "):
+			return key + " interface, " + identPrefix(strings.TrimPrefix(rest, " interface, "))
+		case strings.HasPrefix(rest, " interface {"):
+			return key + " interface"
+		case rest == " struct":
+			return key + " struct"
+		}
+		return key
+	}
+	return line
+}
+
+// cutWord splits s at its first space.
+func cutWord(s string) (word, rest string) {
+	if i := strings.IndexByte(s, ' '); i >= 0 {
+		return s[:i], s[i+1:]
+	}
+	return s, ""
+}
+
+// identPrefix returns the identifier s starts with.
+func identPrefix(s string) string {
+	for i, r := range s {
+		if r == '(' || r == '[' || r == ' ' {
+			return s[:i]
+		}
+	}
+	return s
+}
+
+// skipTypeParams returns s without the list of type parameters it starts
+// with, if any.
+func skipTypeParams(s string) string {
+	if !strings.HasPrefix(s, "[") {
+		return s
+	}
+	depth := 0
+	for i, r := range s {
+		switch r {
+		case '[':
+			depth++
+		case ']':
+			if depth--; depth == 0 {
+				return s[i+1:]
+			}
+		}
+	}
+	return ""
+}
+
+// write writes d as the incompatible and then the compatible changes.
+func (d *apiDiff) write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	if len(d.Removed) > 0 || len(d.Changed) > 0 {
+		fmt.Fprintln(bw, "Incompatible changes:")
+		for _, f := range d.Removed {
+			fmt.Fprintf(bw, "- %s\n", f)
+		}
+		for _, c := range d.Changed {
+			fmt.Fprintf(bw, "~ %s\n  %s\n", c.Old, c.New)
+		}
+	}
+	if len(d.Added) > 0 {
+		fmt.Fprintln(bw, "Compatible changes:")
+		for _, f := range d.Added {
+			fmt.Fprintf(bw, "+ %s\n", f)
+		}
+	}
+	return bw.Flush()
+}
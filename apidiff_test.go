@@ -0,0 +1,68 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeAPITree writes a module holding src as its only package.
+func writeAPITree(t *testing.T, src string) string {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/a\n\ngo 1.22\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.go"), []byte(src), 0666); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestAPIDiffTypes(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		old, new string
+		want     *apiDiff
+	}{{
+		name: "alias retargeted",
+		old:  "package a\n\ntype File struct{}\n\ntype MyErr struct{}\n\ntype Alias = File\n",
+		new:  "package a\n\ntype File struct{}\n\ntype MyErr struct{}\n\ntype Alias = MyErr\n",
+		want: &apiDiff{Removed: []string{}, Changed: []apiChange{{
+			Old: "pkg example.com/a, type Alias = File",
+			New: "pkg example.com/a, type Alias = MyErr",
+		}}, Added: []string{}},
+	}, {
+		name: "constraint narrowed",
+		old:  "package a\n\ntype Number interface {\n\t~int | ~float64\n}\n",
+		new:  "package a\n\ntype Number interface {\n\t~int\n}\n",
+		want: &apiDiff{
+			Removed: []string{"pkg example.com/a, type Number interface, embedded ~int | ~float64"},
+			Changed: []apiChange{},
+			Added:   []string{"pkg example.com/a, type Number interface, embedded ~int"},
+		},
+	}, {
+		name: "interface embedded",
+		old:  "package a\n\nimport \"io\"\n\ntype RC interface {\n\tio.Reader\n\tClose() error\n}\n",
+		new:  "package a\n\nimport \"io\"\n\ntype RC interface {\n\tio.ReadCloser\n}\n",
+		want: &apiDiff{
+			Removed: []string{"pkg example.com/a, type RC interface, embedded io.Reader"},
+			Changed: []apiChange{},
+			Added:   []string{"pkg example.com/a, type RC interface, embedded io.ReadCloser"},
+		},
+	}} {
+		t.Run(test.name, func(t *testing.T) {
+			oldAPI, err := readAPI(writeAPITree(t, test.old))
+			if err != nil {
+				t.Fatal(err)
+			}
+			newAPI, err := readAPI(writeAPITree(t, test.new))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := diffAPI(oldAPI, newAPI); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("diffAPI = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/newhook/go-symbols/symbols"
+)
+
+var (
+	tokenFileFlag = flag.String("token-file", "", "over HTTP and gRPC, require a bearer token listed in `file`, each optionally followed by the workspace roots it may access")
+	tlsCertFlag   = flag.String("tls-cert", "", "over HTTP and gRPC, serve TLS with the certificate in `file`")
+	tlsKeyFlag    = flag.String("tls-key", "", "the private key `file` of -tls-cert")
+	clientCAFlag  = flag.String("client-ca", "", "with -tls-cert, require client certificates signed by a CA in `file`")
+)
+
+// authFlags are accepted by the commands serving over a network.
+var authFlags = []string{"token-file", "tls-cert", "tls-key", "client-ca"}
+
+// An authorizer checks the bearer tokens of requests against those of
+// -token-file. A nil *authorizer lets every request through.
+type authorizer struct {
+	tokens []authToken
+}
+
+type authToken struct {
+	token string
+	roots []string // canonical directories it may access, or nil for all
+}
+
+// A grant is what a request may access: every workspace if roots is nil,
+// or otherwise those within roots.
+type grant struct {
+	roots         []string
+	authenticated bool // by a token of -token-file
+}
+
+// loadAuthorizer reads -token-file, if set. Each line holds a token
+// followed by the roots it may access, if not all; blank lines and lines
+// starting with # are ignored.
+func loadAuthorizer() (*authorizer, error) {
+	if *tokenFileFlag == "" {
+		return nil, nil
+	}
+	f, err := os.Open(*tokenFileFlag)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	a := new(authorizer)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		t := authToken{token: fields[0]}
+		for _, root := range fields[1:] {
+			abs, err := filepath.Abs(root)
+			if err != nil {
+				return nil, err
+			}
+			t.roots = append(t.roots, symbols.CanonicalDir(abs))
+		}
+		a.tokens = append(a.tokens, t)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if len(a.tokens) == 0 {
+		return nil, fmt.Errorf("%s lists no tokens", *tokenFileFlag)
+	}
+	return a, nil
+}
+
+// authorize returns what r may access, or nil if its token is missing or
+// unknown.
+func (a *authorizer) authorize(r *http.Request) *grant {
+	if a == nil {
+		return new(grant)
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" && isWebSocket(r) {
+		// Browsers cannot set headers on WebSocket requests.
+		token = r.URL.Query().Get("access_token")
+	}
+	if token == "" {
+		return nil
+	}
+	var found *grant
+	for _, t := range a.tokens {
+		// Every token is compared, so that the time taken does not
+		// tell which matched.
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t.token)) == 1 && found == nil {
+			found = &grant{roots: t.roots, authenticated: true}
+		}
+	}
+	return found
+}
+
+// allows reports whether g allows access to the workspace of dir, which
+// may be a list of directories.
+func (g *grant) allows(dir string) bool {
+	if g.roots == nil {
+		return true
+	}
+	for _, d := range filepath.SplitList(absDirs(dir)) {
+		d = symbols.CanonicalDir(d)
+		ok := false
+		for _, root := range g.roots {
+			if d == root || strings.HasPrefix(d, root+string(filepath.Separator)) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// mayManageWorkspaces reports whether r may register and unregister
+// workspaces, which has the server read any directory it names. Only
+// requests authenticated by a token or a client certificate may, or, when
+// neither is required, those from the loopback interface.
+func mayManageWorkspaces(r *http.Request) bool {
+	if requestGrant(r.Context()).authenticated || r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return true
+	}
+	return isLoopback(r.RemoteAddr)
+}
+
+// isLoopback reports whether addr, a host and port, is on the loopback
+// interface.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+type grantKey struct{}
+
+// requestGrant returns what the request with context ctx may access, as
+// recorded by requireAuth.
+func requestGrant(ctx context.Context) *grant {
+	if g, ok := ctx.Value(grantKey{}).(*grant); ok {
+		return g
+	}
+	return new(grant)
+}
+
+// publicPaths are answered without authentication, so that health probes
+// need no token. They reveal nothing of the workspaces.
+var publicPaths = map[string]bool{"/healthz": true, "/readyz": true}
+
+// requireAuth returns a handler passing the requests a authorizes on to h,
+// with their grant, and calling deny for the others.
+func (a *authorizer) requireAuth(h http.Handler, deny http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if publicPaths[r.URL.Path] {
+			h.ServeHTTP(w, r)
+			return
+		}
+		g := a.authorize(r)
+		if g == nil {
+			deny(w, r)
+			return
+		}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), grantKey{}, g)))
+	})
+}
+
+// tlsConfig returns the TLS configuration given by the flags, or nil to
+// serve without TLS.
+func tlsConfig() (*tls.Config, error) {
+	if *tlsCertFlag == "" {
+		if *tlsKeyFlag != "" || *clientCAFlag != "" {
+			return nil, usagef("-tls-key and -client-ca require -tls-cert")
+		}
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(*tlsCertFlag, *tlsKeyFlag)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if *clientCAFlag != "" {
+		pem, err := ioutil.ReadFile(*clientCAFlag)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s holds no certificates", *clientCAFlag)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+// serveSecurely serves hs on l with the authentication and TLS given by
+// the flags, calling deny for requests without a valid token.
+func serveSecurely(hs *http.Server, l net.Listener, deny http.HandlerFunc) error {
+	a, err := loadAuthorizer()
+	if err != nil {
+		return err
+	}
+	cfg, err := tlsConfig()
+	if err != nil {
+		return err
+	}
+	if a != nil {
+		hs.Handler = a.requireAuth(hs.Handler, deny)
+		if cfg == nil {
+			logger.Warn("tokens are sent in the clear without -tls-cert")
+		}
+	}
+	if cfg == nil {
+		return hs.Serve(l)
+	}
+	hs.TLSConfig = cfg
+	return hs.ServeTLS(l, "", "")
+}
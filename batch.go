@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	queriesFile  = flag.String("queries-file", "", "read queries, one per line, from `file` (- for standard input) and report the symbols of each")
+	batchQueries listFlag
+)
+
+func init() {
+	flag.Var(&batchQueries, "queries", "comma-separated `queries` answered by a single scan, reporting the symbols of each")
+}
+
+// readQueries returns the queries given with -queries and -queries-file.
+func readQueries() ([]string, error) {
+	queries := append([]string(nil), batchQueries...)
+	if *queriesFile == "" {
+		return queries, nil
+	}
+	var r io.Reader = os.Stdin
+	if *queriesFile != "-" {
+		f, err := os.Open(*queriesFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		if q := strings.TrimSpace(s.Text()); q != "" {
+			queries = append(queries, q)
+		}
+	}
+	return queries, s.Err()
+}
+
+// writeBatch writes the symbols from source matching each of queries, found
+// in a single pass, as a json object keyed by query.
+func writeBatch(ctx context.Context, w io.Writer, source symbolSource, dir string, queries []string, less func(a, b symbol) bool) error {
+	start := time.Now()
+	lower := make([]string, len(queries))
+	groups := make(map[string][]symbol)
+	for i, q := range queries {
+		lower[i] = strings.ToLower(q)
+		groups[q] = []symbol{}
+	}
+	dedup := newDeduper()
+	var count int
+	sum := source(ctx, dir, "", func(found []symbol) {
+		for _, s := range dedup.filter(found) {
+			name := strings.ToLower(s.Name)
+			for i, q := range lower {
+				if strings.Contains(name, q) {
+					groups[queries[i]] = append(groups[queries[i]], s)
+					count++
+				}
+			}
+		}
+	})
+	for _, syms := range groups {
+		if less != nil {
+			sort.Slice(syms, func(i, j int) bool { return less(syms[i], syms[j]) })
+		}
+		rewritePaths(syms)
+	}
+	sum.stats.Symbols = count
+	notePartial(ctx, sum)
+
+	outputStart := time.Now()
+	var err error
+	if *envelopeFlag {
+		var stats *scanStats
+		if *statsFlag {
+			finishStats(&sum.stats, start, time.Time{})
+			stats = &sum.stats
+		}
+		err = writeEnvelope(w, envelope{
+			Scope:      scope{Dir: dir, Query: strings.Join(queries, ",")},
+			Errors:     sum.errors,
+			Skipped:    sum.skipped,
+			Stats:      stats,
+			Incomplete: ctx.Err() != nil,
+			Symbols:    groups,
+		})
+	} else {
+		var b []byte
+		if b, err = marshalJSON(jsonSymbols(groups)); err == nil {
+			_, err = fmt.Fprintln(w, string(b))
+		}
+	}
+	finishStats(&sum.stats, start, outputStart)
+	printStats(&sum.stats)
+	return err
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"hash/fnv"
+	"strings"
+)
+
+// bloomGram is the length of the longest n-grams added to Bloom filters;
+// longer queries are looked up by their trigrams.
+const bloomGram = 3
+
+// bloomBits is the number of bits per n-gram in a Bloom filter, for a false
+// positive rate of about 2% with bloomHashes hash functions.
+const (
+	bloomBits   = 8
+	bloomHashes = 4
+)
+
+// A bloomFilter is a Bloom filter of the lower-cased n-grams, up to
+// bloomGram bytes long, of the names of a set of symbols. It tells which
+// queries cannot match any of them.
+type bloomFilter []byte
+
+func newBloomFilter(names []string) bloomFilter {
+	grams := make(map[string]bool)
+	for _, name := range names {
+		s := strings.ToLower(name)
+		for n := 1; n <= bloomGram; n++ {
+			for j := 0; j+n <= len(s); j++ {
+				grams[s[j:j+n]] = true
+			}
+		}
+	}
+	b := make(bloomFilter, (len(grams)*bloomBits+7)/8)
+	for g := range grams {
+		b.add(g)
+	}
+	return b
+}
+
+// positions calls f with the bits for gram g.
+func (b bloomFilter) positions(g string, f func(bit uint32)) {
+	h := fnv.New64a()
+	h.Write([]byte(g))
+	sum := h.Sum64()
+	h1, h2 := uint32(sum), uint32(sum>>32)
+	m := uint32(len(b)) * 8
+	for i := uint32(0); i < bloomHashes; i++ {
+		f((h1 + i*h2) % m)
+	}
+}
+
+func (b bloomFilter) add(g string) {
+	b.positions(g, func(bit uint32) { b[bit/8] |= 1 << (bit % 8) })
+}
+
+func (b bloomFilter) has(g string) bool {
+	if len(b) == 0 {
+		return false
+	}
+	ok := true
+	b.positions(g, func(bit uint32) { ok = ok && b[bit/8]&(1<<(bit%8)) != 0 })
+	return ok
+}
+
+// mayContain reports whether a name containing the lower-cased query may be
+// among those of the filter.
+func (b bloomFilter) mayContain(query string) bool {
+	if query == "" {
+		return true
+	}
+	if len(query) <= bloomGram {
+		return b.has(query)
+	}
+	for j := 0; j+bloomGram <= len(query); j++ {
+		if !b.has(query[j : j+bloomGram]) {
+			return false
+		}
+	}
+	return true
+}
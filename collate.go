@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"strings"
+
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+var collateFlag = flag.String("collate", "bytewise", "compare names and paths when sorting `by` bytewise, or by unicode: the Unicode collation algorithm, the same on every machine whatever its locale")
+
+// collated returns the order of the -sort key, less, comparing names and
+// paths as -collate selects. The order of unicode collation is not safe
+// for concurrent use, as the collator holds buffers.
+func collated(key string, less func(a, b symbol) bool) (func(a, b symbol) bool, error) {
+	switch *collateFlag {
+	case "", "bytewise":
+		return less, nil
+	case "unicode":
+	default:
+		return nil, usagef("unknown -collate %q (want bytewise or unicode)", *collateFlag)
+	}
+	// The root collation, which no locale tailors.
+	c := collate.New(language.Und)
+	cmp := func(a, b string) int {
+		if n := c.CompareString(a, b); n != 0 {
+			return n
+		}
+		// Distinct strings collating equally, such as those differing in
+		// ignorable characters, still have an order.
+		return strings.Compare(a, b)
+	}
+	switch key {
+	case "path":
+		return pathOrder(cmp), nil
+	case "name":
+		return nameOrder(cmp), nil
+	}
+	return less, nil
+}
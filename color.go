@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// ANSI escape sequences used to colorize output.
+const (
+	ansiReset = "\x1b[0m"
+	ansiBold  = "\x1b[1m"
+	ansiFaint = "\x1b[2m"
+	ansiBlue  = "\x1b[34m"
+	ansiGreen = "\x1b[32m"
+	ansiMatch = "\x1b[1;31m" // bold red, as grep highlights matches
+)
+
+// useColor reports whether output should be colorized according to
+// -color: always, never, or auto, which colors only when the output is
+// standard output, a terminal, and NO_COLOR is unset or empty.
+func useColor() (bool, error) {
+	switch *colorFlag {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto", "":
+		return *outputFlag == "" && os.Getenv("NO_COLOR") == "" && isTerminal(os.Stdout), nil
+	}
+	return false, usagef("unknown -color mode %q", *colorFlag)
+}
+
+// kindColor returns the color used for symbols of the given kind.
+func kindColor(kind string) string {
+	if kind == "type" {
+		return ansiGreen
+	}
+	return ansiBlue
+}
+
+// colorize wraps s in the escape sequence color if colors are enabled.
+func colorize(s, color string) string {
+	if !colors {
+		return s
+	}
+	return color + s + ansiReset
+}
+
+// highlightMatches returns name with the occurrences of highlight in it,
+// ignoring case, colorized if colors are enabled.
+func highlightMatches(name string) string {
+	lower := strings.ToLower(name)
+	if !colors || highlight == "" || len(lower) != len(name) {
+		return name
+	}
+	var b strings.Builder
+	for {
+		i := strings.Index(lower, highlight)
+		if i < 0 {
+			break
+		}
+		end := i + len(highlight)
+		b.WriteString(name[:i])
+		b.WriteString(colorize(name[i:end], ansiMatch))
+		name, lower = name[end:], lower[end:]
+	}
+	b.WriteString(name)
+	return b.String()
+}
+
+// colors is set by search from -color.
+var colors bool
+
+// highlight is the query of a search, in lower case, whose matches are
+// highlighted in the names of plain and table output.
+var highlight string
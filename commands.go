@@ -0,0 +1,231 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+)
+
+// A command is a subcommand of gosymbols, with the flags it accepts.
+type command struct {
+	run   func(args []string) error
+	args  string   // synopsis of the arguments
+	doc   string   // one line description
+	flags []string // names of the flags accepted besides commonFlags
+}
+
+// commonFlags apply to all commands: they control profiling, logging and
+// which packages are scanned, and how.
+var commonFlags = []string{"cpuprofile", "memprofile", "trace", "j", "tags", "only-prefix", "fast", "ignore-line-directives", "deps", "packages", "v", "q", "log-file", "log-format", "progress", "package-timeout"}
+
+// outputFlags select how symbols are written.
+var outputFlags = []string{"format", "kind-format", "position-base", "kythe-corpus", "format-template", "compact", "color", "relative-to", "symlinks", "uri", "with-source"}
+
+// searchFlags apply to searches.
+var searchFlags = append([]string{
+	"o", "output-dir", "compress", "sort", "collate", "group-by", "envelope", "stats", "slow-packages",
+	"limit", "max-symbols", "timeout", "max-memory", "queries", "queries-file", "pkg-name", "stdlib", "goroot", "typed", "refs", "promoted", "with-implements", "fields", "package-docs", "cache-dir",
+}, outputFlags...)
+
+// scanCommand is run when the command line names no command. Its flags
+// may only precede the directory.
+var scanCommand = &command{
+	run:   search,
+	args:  "<dir> [query]",
+	doc:   "scan dir and write the symbols matching query",
+	flags: append([]string{"parse-cache", "modified", "prefilter"}, searchFlags...),
+}
+
+// commands maps subcommand names to their implementations. Anything else
+// on the command line is a search.
+var commands = map[string]*command{
+	"search":         {run: runSearch, args: "<dir> [query]", doc: "answer a query from the index of dir, or scan dir if it has none", flags: append([]string{"remote"}, searchFlags...)},
+	"index":          {run: runIndex, args: "<dir>", doc: "scan dir and store its symbols in a persistent index", flags: []string{"cache-dir", "stats", "slow-packages", "with-implements", "fields", "package-docs", "verify"}},
+	"warm":           {run: runWarm, args: "<dir>", doc: "refresh the index of dir in the background", flags: []string{"cache-dir", "stats", "slow-packages", "wait"}},
+	"serve":          {args: "[dir]", doc: "answer queries over a socket or HTTP from symbols held in memory", flags: append([]string{"listen", "http", "allow-origin", "stats", "relative-to", "symlinks", "uri", "with-implements"}, authFlags...)},
+	"watch":          {run: runWatch, args: "<dir> [query]", doc: "write the symbols matching query, and again whenever they change", flags: outputFlags},
+	"file":           {run: runFile, args: "<file>", doc: "write the symbols declared in a file, parsing only it", flags: append([]string{"stdin", "hierarchical", "fields"}, outputFlags...)},
+	"outline":        {run: runOutline, args: "<file>", doc: "write the symbols declared in a file", flags: append([]string{"modified"}, outputFlags...)},
+	"lsp":            {run: runLSP, args: "[dir]", doc: "serve the Language Server Protocol on standard input and output", flags: []string{"limit"}},
+	"rpc":            {run: runRPC, args: "<dir>", doc: "serve JSON-RPC 2.0 on standard input and output", flags: []string{"relative-to", "symlinks", "uri"}},
+	"vim":            {run: runVim, args: "<dir>", doc: "serve Vim's channel JSON protocol on standard input and output", flags: []string{"relative-to", "symlinks", "uri"}},
+	"grpc":           {run: runGRPC, args: "<dir>", doc: "serve the gRPC service of proto/service.proto", flags: append([]string{"listen", "relative-to", "symlinks", "uri"}, authFlags...)},
+	"def":            {run: runDef, args: "[dir] <import/path>.<Name>", doc: "write the position of the declaration of a qualified name", flags: append([]string{"stdlib", "goroot", "cache-dir"}, outputFlags...)},
+	"hierarchy":      {run: runHierarchy, args: "[dir] <import/path>.<Type>", doc: "write the types a type embeds and those embedding it, transitively", flags: []string{"format", "stdlib", "goroot", "typed", "cache-dir", "relative-to", "symlinks", "uri"}},
+	"lsif":           {run: runLSIF, args: "<dir> [query]", doc: "write an LSIF dump"},
+	"duplicates":     {run: runDuplicates, args: "<dir> [query]", doc: "write the exported funcs and types whose names several packages declare", flags: append([]string{"cache-dir"}, outputFlags...)},
+	"graph":          {run: runGraph, args: "<dir> [query]", doc: "write a Graphviz graph of the matching types", flags: []string{"format", "typed"}},
+	"html":           {run: runHTML, args: "<dir> [query]", doc: "write a static site listing the symbols to the directory given by -o", flags: []string{"o", "relative-to", "symlinks", "uri"}},
+	"apidiff":        {run: runAPIDiff, args: "<old> <new>", doc: "compare the APIs of two trees or api files, reporting incompatible changes", flags: []string{"format"}},
+	"api":            {run: runAPI, args: "<dir>", doc: "write the exported API of the packages of dir, one feature per line", flags: []string{"o"}},
+	"unused-exports": {run: runUnusedExports, args: "<dir>", doc: "write the exported funcs and types not referred to outside their package", flags: append([]string{"ignore-tests"}, outputFlags...)},
+	"errors":         {run: runErrors, args: "<dir>", doc: "write the types implementing error and the sentinel errors", flags: outputFlags},
+	"doctor":         {run: runDoctor, args: "[dir]", doc: "check the environment go-symbols runs in for dir and suggest fixes", flags: []string{"goroot", "cache-dir"}},
+	"version":        {doc: "print the version of gosymbols", flags: []string{"json"}},
+}
+
+func init() {
+	// Set here, as runVersion lists the commands and runServe reloads
+	// the configuration of the flags serve accepts.
+	commands["version"].run = runVersion
+	commands["serve"].run = runServe
+}
+
+// setFlags records the flags given on the command line, before or after
+// the command name.
+var setFlags = make(map[string]bool)
+
+// parseCommand parses the command line, returning the command it names and
+// its arguments. Flags may precede the command name, but only those the
+// command accepts.
+func parseCommand() (*command, []string, error) {
+	flag.Usage = printUsage
+	flag.Parse()
+	cmd, name, args := scanCommand, "", flag.Args()
+	if len(args) > 0 {
+		if c, ok := commands[args[0]]; ok {
+			cmd, name, args = c, args[0], args[1:]
+		}
+	}
+	var err error
+	flag.Visit(func(f *flag.Flag) {
+		if !cmd.accepts(f.Name) && err == nil {
+			if name == "" {
+				err = usagef("flag -%s only applies to commands", f.Name)
+			} else {
+				err = usagef("flag -%s does not apply to the %s command", f.Name, name)
+			}
+		}
+		setFlags[f.Name] = true
+	})
+	if err != nil || name == "" {
+		return cmd, args, err
+	}
+	fs := cmd.flagSet(name)
+	fs.Parse(args)
+	fs.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+	return cmd, fs.Args(), nil
+}
+
+func (c *command) accepts(name string) bool {
+	for _, names := range [][]string{commonFlags, c.flags} {
+		for _, n := range names {
+			if n == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// flagSet returns the flags of the command with the given name, which share
+// their values with those of flag.CommandLine.
+func (c *command) flagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet("gosymbols "+name, flag.ExitOnError)
+	flag.VisitAll(func(f *flag.Flag) {
+		if c.accepts(f.Name) {
+			fs.Var(f.Value, f.Name, f.Usage)
+		}
+	})
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: gosymbols %s [flags] %s\n\n%s.\n\nFlags:\n", name, c.args, upperFirst(c.doc))
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// printUsage prints the commands and the flags of a scan.
+func printUsage() {
+	w := flag.CommandLine.Output()
+	fmt.Fprintf(w, "Usage: gosymbols [flags] %s\n       gosymbols <command> [flags] <arguments>\n\nCommands:\n", scanCommand.args)
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c := commands[name]
+		fmt.Fprintf(w, "  %s\n    \t%s\n", strings.TrimSpace(name+" "+c.args), c.doc)
+	}
+	fmt.Fprintf(w, "\nRun gosymbols <command> -h for the flags of a command.\n\nFlags:\n")
+	scanCommand.flagSet("").PrintDefaults()
+}
+
+func upperFirst(s string) string {
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+// flagSet reports whether the flag with the given name was given.
+func flagSet(name string) bool {
+	return setFlags[name]
+}
+
+var versionJSON = flag.Bool("json", false, "describe the version and capabilities of gosymbols as json")
+
+// capabilities describes what gosymbols supports, so that editors can
+// detect features before using them.
+type capabilities struct {
+	Version       string              `json:"version"`
+	GoVersion     string              `json:"goVersion"`
+	SchemaVersion int                 `json:"schemaVersion"` // of -envelope
+	IndexVersion  int                 `json:"indexVersion"`
+	Formats       []string            `json:"formats"`
+	KindFormats   []string            `json:"kindFormats"` // of -kind-format
+	SortOrders    []string            `json:"sortOrders"`
+	Collations    []string            `json:"collations"` // of -collate
+	Matchers      []string            `json:"matchers"`   // how queries match names
+	Flags         []string            `json:"flags"`      // of a scan
+	Commands      map[string][]string `json:"commands"`   // and their flags
+}
+
+// runVersion implements the version command.
+func runVersion(args []string) error {
+	version := "(devel)"
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		version = info.Main.Version
+	}
+	if !*versionJSON {
+		fmt.Fprintf(os.Stdout, "gosymbols %s (%s, index version %d)\n", version, runtime.Version(), indexVersion)
+		return nil
+	}
+
+	c := capabilities{
+		Version:       version,
+		GoVersion:     runtime.Version(),
+		SchemaVersion: schemaVersion,
+		IndexVersion:  indexVersion,
+		Matchers:      []string{"substring"},
+		KindFormats:   []string{"lsp"},
+		Collations:    []string{"bytewise", "unicode"},
+		Flags:         scanCommand.flagNames(),
+		Commands:      make(map[string][]string),
+	}
+	for name := range formats {
+		c.Formats = append(c.Formats, name)
+	}
+	sort.Strings(c.Formats)
+	for name := range symbolOrders {
+		c.SortOrders = append(c.SortOrders, name)
+	}
+	sort.Strings(c.SortOrders)
+	for name, cmd := range commands {
+		c.Commands[name] = cmd.flagNames()
+	}
+	b, err := marshalJSON(c)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(os.Stdout, string(b))
+	return err
+}
+
+// flagNames returns the names of the flags c accepts, sorted.
+func (c *command) flagNames() []string {
+	names := append(append([]string(nil), commonFlags...), c.flags...)
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// configNames are the names of configuration files, in the order they are
+// looked for in a directory.
+var configNames = []string{"gosymbols.json", ".gosymbols.json", "gosymbols.yaml", ".gosymbols.yaml"}
+
+// loadConfig sets the flags of cmd that were not given on the command line
+// from GOSYMBOLS_ environment variables or, failing that, from the
+// configuration files of the user, in the user configuration directory, and
+// of the workspace in root, which takes precedence. Their settings are named
+// after the flags.
+func loadConfig(cmd *command, root string) error {
+	settings, err := readConfig(root)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if flagSet(name) || !cmd.accepts(name) {
+			// Flags override the configuration, which may hold settings
+			// of other commands.
+			continue
+		}
+		if err := flag.Set(name, settings[name].value); err != nil {
+			return fmt.Errorf("%s: invalid value for %s: %v", settings[name].source, name, err)
+		}
+		setFlags[name] = true
+		configFlags[name] = settings[name].value
+	}
+	return nil
+}
+
+// configFlags holds the values of the flags set by the configuration rather
+// than the command line, by name.
+var configFlags = make(map[string]string)
+
+// configDirs returns the directories holding the configuration files that
+// apply to the workspace in root, in increasing order of precedence.
+func configDirs(root string) []string {
+	var dirs []string
+	if dir, err := os.UserConfigDir(); err == nil {
+		dirs = append(dirs, dir)
+	}
+	if root != "" {
+		dirs = append(dirs, root)
+	}
+	return dirs
+}
+
+// readConfig returns the settings of the configuration files that apply to
+// the workspace in root and of the GOSYMBOLS_ environment variables, which
+// take precedence, by flag name.
+func readConfig(root string) (map[string]setting, error) {
+	settings := make(map[string]setting)
+	for _, dir := range configDirs(root) {
+		for _, name := range configNames {
+			filename := filepath.Join(dir, name)
+			b, err := ioutil.ReadFile(filename)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if err := parseConfig(filename, b, settings); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+
+	flag.VisitAll(func(f *flag.Flag) {
+		if v, ok := os.LookupEnv(envName(f.Name)); ok {
+			settings[f.Name] = setting{v, envName(f.Name)}
+		}
+	})
+	return settings, nil
+}
+
+// envName returns the name of the environment variable setting the named
+// flag, such as GOSYMBOLS_CACHE_DIR for -cache-dir.
+func envName(flagName string) string {
+	return "GOSYMBOLS_" + strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}
+
+// configRoot returns the workspace whose configuration applies to a command
+// with the given arguments: the directory they start with, or that of the
+// file they start with.
+func configRoot(args []string) string {
+	if len(args) == 0 || args[0] == "" {
+		return ""
+	}
+	root := filepath.SplitList(args[0])[0]
+	if fi, err := os.Stat(root); err == nil && !fi.IsDir() {
+		root = filepath.Dir(root)
+	}
+	return root
+}
+
+// A setting is the value of a flag and where it comes from.
+type setting struct {
+	value  string
+	source string // the file or environment variable
+}
+
+// parseConfig adds the settings of a JSON or YAML configuration file to
+// settings, keyed by flag name.
+func parseConfig(filename string, b []byte, settings map[string]setting) error {
+	values := make(map[string]interface{})
+	var err error
+	if strings.HasSuffix(filename, ".json") {
+		err = json.Unmarshal(b, &values)
+	} else {
+		err = parseYAML(b, values)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+	for name, v := range values {
+		if flag.Lookup(name) == nil {
+			return fmt.Errorf("%s: unknown setting %q", filename, name)
+		}
+		var value string
+		switch v := v.(type) {
+		case string:
+			value = v
+		case bool:
+			value = strconv.FormatBool(v)
+		case float64:
+			value = strconv.FormatFloat(v, 'f', -1, 64)
+		case []interface{}:
+			// Lists are given to flags separated by commas.
+			list := make([]string, len(v))
+			for i, e := range v {
+				list[i] = fmt.Sprint(e)
+			}
+			value = strings.Join(list, ",")
+		default:
+			return fmt.Errorf("%s: invalid value for %s", filename, name)
+		}
+		settings[name] = setting{value, filename}
+	}
+	return nil
+}
+
+// parseYAML parses the subset of YAML used by configuration files: a
+// mapping of names to scalars or to lists, either in brackets or as
+// following lines starting with "-". Scalars are strings unless they are
+// booleans or numbers.
+func parseYAML(b []byte, values map[string]interface{}) error {
+	var list string // the setting whose list items follow
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; s.Scan(); n++ {
+		line := s.Text()
+		if i := strings.Index(line, " #"); i >= 0 {
+			line = line[:i]
+		}
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed[0] == '#' || trimmed == "---" {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "- ") || trimmed == "-" {
+			if list == "" {
+				return fmt.Errorf("line %d: list item outside a list", n)
+			}
+			items, _ := values[list].([]interface{})
+			values[list] = append(items, yamlScalar(strings.TrimSpace(trimmed[1:])))
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			return fmt.Errorf("line %d: unexpected indentation", n)
+		}
+		name, value, ok := strings.Cut(trimmed, ":")
+		if !ok {
+			return fmt.Errorf("line %d: expected name: value", n)
+		}
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		list = ""
+		switch {
+		case value == "":
+			list = name
+			values[name] = []interface{}{}
+		case strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]"):
+			items := []interface{}{}
+			for _, e := range strings.Split(value[1:len(value)-1], ",") {
+				if e = strings.TrimSpace(e); e != "" {
+					items = append(items, yamlScalar(e))
+				}
+			}
+			values[name] = items
+		default:
+			values[name] = yamlScalar(value)
+		}
+	}
+	return s.Err()
+}
+
+func yamlScalar(s string) interface{} {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		if s[0] == '"' {
+			if u, err := strconv.Unquote(s); err == nil {
+				return u
+			}
+		}
+		return s[1 : len(s)-1]
+	}
+	switch s {
+	case "true", "yes", "on":
+		return true
+	case "false", "no", "off":
+		return false
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f
+	}
+	return s
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+)
+
+// cscopeMarks maps symbol kinds to the marks cscope puts before the
+// symbols it defines. Types other than structs are written as typedefs.
+var cscopeMarks = map[string]byte{
+	"func":    '$',
+	"type":    't',
+	"var":     'g',
+	"package": 'g',
+	"field":   'm',
+}
+
+// writeCscope writes syms as an uncompressed cscope.out symbol cross
+// reference of the scanned directory, for cscope -d and tools reading its
+// database. Each file lists the lines declaring symbols, with each name
+// marked as a definition. Files are named relative to the workspace root,
+// and symbols whose name is not found at their position are left out.
+func writeCscope(w io.Writer, syms []symbol) error {
+	byPath := make(map[string][]symbol)
+	var paths []string
+	for _, s := range syms {
+		if _, ok := byPath[s.Path]; !ok {
+			paths = append(paths, s.Path)
+		}
+		byPath[s.Path] = append(byPath[s.Path], s)
+	}
+	sort.Strings(paths)
+
+	var body bytes.Buffer
+	var names []string
+	for _, path := range paths {
+		src, err := ioutil.ReadFile(sourcePath(path))
+		if err != nil {
+			continue
+		}
+		fsyms := byPath[path]
+		sort.SliceStable(fsyms, func(i, j int) bool {
+			if fsyms[i].Line != fsyms[j].Line {
+				return fsyms[i].Line < fsyms[j].Line
+			}
+			return fsyms[i].Character < fsyms[j].Character
+		})
+		name := rootRelative(path)
+		names = append(names, name)
+		fmt.Fprintf(&body, "\t@%s\n\n", name)
+		starts := lineStarts(src)
+		line, pos := -1, 0 // the line being written and the offset written to
+		for _, s := range fsyms {
+			start, ok := nameOffset(src, starts, s)
+			if !ok || s.Line == line && start < pos {
+				continue
+			}
+			if s.Line != line {
+				if line >= 0 {
+					body.Write(cscopeText(src, pos))
+					body.WriteString("\n\n")
+				}
+				line, pos = s.Line, starts[s.Line]
+				fmt.Fprintf(&body, "%d ", line+1)
+			}
+			body.Write(src[pos:start])
+			mark := cscopeMarks[s.Kind]
+			if s.Kind == "type" && s.TypeKind == "struct" {
+				mark = 's'
+			}
+			body.WriteByte('\n')
+			if mark != 0 {
+				body.WriteByte('\t')
+				body.WriteByte(mark)
+			}
+			body.WriteString(s.Name)
+			body.WriteByte('\n')
+			pos = start + len(s.Name)
+		}
+		if line >= 0 {
+			body.Write(cscopeText(src, pos))
+			body.WriteString("\n\n")
+		}
+	}
+	body.WriteString("\t@\n")
+
+	// The trailer lists the view path, source and include directories and
+	// the files, whose offset the header gives.
+	header := fmt.Sprintf("cscope 15 %s -c ", workspaceRoot)
+	trailer := len(header) + len("0000000000\n") + body.Len()
+	fmt.Fprintf(&body, "1\n.\n0\n0\n%d\n", len(names))
+	size := 0
+	for _, name := range names {
+		size += len(name) + 1
+	}
+	fmt.Fprintf(&body, "%d\n", size)
+	for _, name := range names {
+		fmt.Fprintf(&body, "%s\n", name)
+	}
+
+	if _, err := fmt.Fprintf(w, "%s%010d\n", header, trailer); err != nil {
+		return err
+	}
+	_, err := body.WriteTo(w)
+	return err
+}
+
+// cscopeText returns the rest of the line of src from offset pos.
+func cscopeText(src []byte, pos int) []byte {
+	text := src[pos:]
+	if i := bytes.IndexByte(text, '\n'); i >= 0 {
+		text = text[:i]
+	}
+	return bytes.TrimRight(text, "\r")
+}
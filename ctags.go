@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// ctagsKinds maps symbol kinds to the single-letter kinds used by ctags
+// for Go.
+var ctagsKinds = map[string]string{
+	"func": "f",
+	"type": "t",
+}
+
+// writeCtags writes syms as a sorted tags file in the extended ctags
+// format, as read by vim and most other editors.
+func writeCtags(w io.Writer, syms []symbol) error {
+	sorted := make([]symbol, len(syms))
+	copy(sorted, syms)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		return a.Line < b.Line
+	})
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "!_TAG_FILE_FORMAT\t2\t/extended format; --format=1 will not append ;\" to lines/")
+	fmt.Fprintln(bw, "!_TAG_FILE_SORTED\t1\t/0=unsorted, 1=sorted, 2=foldcase/")
+	fmt.Fprintln(bw, "!_TAG_PROGRAM_NAME\tgosymbols\t//")
+	for _, s := range sorted {
+		kind, ok := ctagsKinds[s.Kind]
+		if !ok {
+			kind = s.Kind
+		}
+		// Tags files use 1-based line numbers.
+		fmt.Fprintf(bw, "%s\t%s\t%d;\"\t%s", s.Name, s.Path, s.Line+1, kind)
+		fmt.Fprintf(bw, "\tpackage:%s", s.Package)
+		if s.Receiver != "" {
+			fmt.Fprintf(bw, "\treceiver:%s", ctagsEscape(s.Receiver))
+		}
+		if s.Signature != "" {
+			fmt.Fprintf(bw, "\tsignature:%s", ctagsEscape(s.Signature))
+		}
+		fmt.Fprintln(bw)
+	}
+	return bw.Flush()
+}
+
+// ctagsEscape escapes the characters that may not appear in the value of
+// an extended field.
+func ctagsEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`).Replace(s)
+}
+
+// A ctagsTag is a tag in the JSON output of universal-ctags.
+type ctagsTag struct {
+	Type      string `json:"_type"`
+	Name      string `json:"name"`
+	Path      string `json:"path"`
+	Line      int    `json:"line"`
+	Kind      string `json:"kind"`
+	Scope     string `json:"scope,omitempty"`
+	ScopeKind string `json:"scopeKind,omitempty"`
+	Signature string `json:"signature,omitempty"`
+}
+
+// universalCtagsKind returns the kind universal-ctags gives s, and the
+// name and kind of its scope if any: fields are members of their struct,
+// and methods are scoped to their receiver type.
+func universalCtagsKind(s symbol) (kind, scope, scopeKind string) {
+	switch {
+	case s.Kind == "field":
+		return "member", s.Receiver, "struct"
+	case s.Kind == "type" && s.TypeKind != "":
+		return s.TypeKind, "", ""
+	case s.Receiver != "":
+		return s.Kind, receiverType(s.Receiver), "type"
+	}
+	return s.Kind, "", ""
+}
+
+// writeCtagsJSON writes syms as universal-ctags writes tags with
+// --output-format=json, one tag object per line.
+func writeCtagsJSON(w io.Writer, syms []symbol) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	enc.SetEscapeHTML(false)
+	for _, s := range syms {
+		tag := ctagsTag{
+			Type:      "tag",
+			Name:      s.Name,
+			Path:      s.Path,
+			Line:      s.Line + 1,
+			Signature: s.Signature,
+		}
+		tag.Kind, tag.Scope, tag.ScopeKind = universalCtagsKind(s)
+		if err := enc.Encode(tag); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
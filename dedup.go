@@ -0,0 +1,53 @@
+package main
+
+import (
+	"path/filepath"
+
+	"github.com/newhook/go-symbols/symbols"
+)
+
+// A deduper drops symbols for declarations that have already been
+// reported, as happens when a file is reached through several directories
+// or parsed more than once.
+type deduper struct {
+	seen map[dedupKey]bool
+	dirs map[string]string // directory -> its canonical form
+}
+
+// dedupKey identifies a declaration by its position as reported, since
+// symbols read from a server carry no offsets, and its receiver, which
+// tells promoted methods from the method they stand for.
+type dedupKey struct {
+	file            string
+	line, character int
+	name            string
+	receiver        string
+}
+
+func newDeduper() *deduper {
+	return &deduper{
+		seen: make(map[dedupKey]bool),
+		dirs: make(map[string]string),
+	}
+}
+
+// filter returns the symbols in syms not seen before, reusing its
+// backing array.
+func (d *deduper) filter(syms []symbol) []symbol {
+	out := syms[:0]
+	for _, s := range syms {
+		dir, base := filepath.Split(s.Path)
+		canon, ok := d.dirs[dir]
+		if !ok {
+			canon = symbols.CanonicalDir(dir)
+			d.dirs[dir] = canon
+		}
+		key := dedupKey{filepath.Join(canon, base), s.Line, s.Character, s.Name, s.Receiver}
+		if d.seen[key] {
+			continue
+		}
+		d.seen[key] = true
+		out = append(out, s)
+	}
+	return out
+}
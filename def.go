@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// runDef implements the def command, which writes the position of the
+// declaration of a qualified name, such as net/http.Server or, for a
+// method, net/http.Server.Close. It is found in the index of the tree, or
+// by a scan if it has none; names in the standard library are also looked
+// up in its index.
+func runDef(args []string) error {
+	if len(args) == 1 {
+		args = []string{".", args[0]}
+	}
+	if len(args) != 2 {
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+	dir, name := args[0], args[1]
+	setWorkspace(dir)
+	i := strings.LastIndexByte(name, '.')
+	if i <= strings.LastIndexByte(name, '/') || i == len(name)-1 {
+		return usagef("%q is not a qualified name such as net/http.Server", name)
+	}
+	format := writePositions
+	if flagSet("format") || flagSet("format-template") {
+		var err error
+		if format, err = outputFormat(); err != nil {
+			return err
+		}
+	} else {
+		*formatFlag = "" // nor are errors written as json
+	}
+
+	source := searchIndex
+	if inStdlib(name) || *stdlibFlag {
+		goroot, err := stdlibRoot()
+		if err != nil {
+			return err
+		}
+		source = withStdlib(goroot, source)
+	}
+	var defs []symbol
+	sum := source(context.Background(), dir, strings.ToLower(name[i+1:]), func(found []symbol) {
+		for _, s := range found {
+			if qualifiedName(s) == name {
+				defs = append(defs, s)
+			}
+		}
+	})
+	if len(defs) == 0 {
+		for _, e := range sum.errors {
+			logger.Warn("could not read package", "dir", e.Dir, "err", e.Message)
+		}
+		return fmt.Errorf("%s is not declared in %s", name, dir)
+	}
+	// Declarations constrained to different platforms are all reported.
+	sort.Slice(defs, func(i, j int) bool { return symbolOrders["path"](defs[i], defs[j]) })
+	rewritePaths(defs)
+	return format(os.Stdout, defs)
+}
+
+// qualifiedName returns the name of s qualified by its import path and,
+// for a method, its receiver type.
+func qualifiedName(s symbol) string {
+	if s.Receiver != "" {
+		return s.ImportPath + "." + receiverType(s.Receiver) + "." + s.Name
+	}
+	return s.ImportPath + "." + s.Name
+}
+
+// inStdlib reports whether the qualified name is in the standard library,
+// whose import paths have no dot in their first element.
+func inStdlib(name string) bool {
+	elem := name
+	if i := strings.IndexByte(name, '/'); i >= 0 {
+		elem = name[:i]
+	} else {
+		elem = name[:strings.IndexByte(name, '.')]
+	}
+	return !strings.Contains(elem, ".")
+}
+
+// writePositions writes the position of each symbol as path:line:column,
+// with 1-based line and column numbers.
+func writePositions(w io.Writer, syms []symbol) error {
+	bw := bufio.NewWriter(w)
+	for _, s := range syms {
+		fmt.Fprintf(bw, "%s:%d:%d\n", s.Path, s.Line+1, s.Character+1)
+	}
+	return bw.Flush()
+}
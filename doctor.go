@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A finding is the outcome of one check of the doctor command.
+type finding struct {
+	check   string
+	problem bool   // whether it keeps gosymbols from working as expected
+	msg     string // what was found
+	fix     string // what to do about it, if anything
+}
+
+// runDoctor implements the doctor command, which checks the environment
+// gosymbols runs in for dir, or the current directory: the Go installation
+// and GOPATH, how dir is scanned, the cache directory, the index of dir and
+// the limits on watching its files. It reports a finding per check, and
+// exits as partial if any is a problem.
+func runDoctor(args []string) error {
+	dir := "."
+	if len(args) > 0 {
+		dir, _ = parseArgs(args)
+	}
+	dir = absDirs(dir)
+	root := filepath.SplitList(dir)[0]
+
+	env, err := goEnv(root)
+	var findings []finding
+	if err != nil {
+		findings = append(findings, finding{"go", true, err.Error(),
+			"install Go or add it to PATH; -packages, -typed and -stdlib -goroot need the go command"})
+	}
+	findings = append(findings, checkGoroot(env))
+	findings = append(findings, checkGopath()...)
+	findings = append(findings, checkModules(root, env))
+	findings = append(findings, checkCacheDir())
+	findings = append(findings, checkIndex(dir))
+	findings = append(findings, checkWatcher(dir))
+
+	problems := 0
+	for _, f := range findings {
+		status := "ok"
+		if f.problem {
+			status = "PROBLEM"
+			problems++
+		}
+		fmt.Fprintf(os.Stdout, "%-7s %-8s %s\n", status, f.check, f.msg)
+		if f.fix != "" {
+			fmt.Fprintf(os.Stdout, "%-16s %s\n", "", f.fix)
+		}
+	}
+	if problems > 0 {
+		noun := "problems"
+		if problems == 1 {
+			noun = "problem"
+		}
+		fmt.Fprintf(os.Stdout, "%d %s found\n", problems, noun)
+		partial = true
+	}
+	return nil
+}
+
+// goEnv returns the environment the go command uses in dir, or nil if it
+// cannot be run.
+func goEnv(dir string) (map[string]string, error) {
+	cmd := exec.Command("go", "env", "-json", "GOROOT", "GOPATH", "GOMOD", "GOWORK", "GO111MODULE", "GOFLAGS")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok && len(ee.Stderr) > 0 {
+			return nil, fmt.Errorf("go env: %s", strings.TrimSpace(string(ee.Stderr)))
+		}
+		return nil, fmt.Errorf("go env: %v", err)
+	}
+	env := make(map[string]string)
+	if err := json.Unmarshal(out, &env); err != nil {
+		return nil, fmt.Errorf("go env: %v", err)
+	}
+	return env, nil
+}
+
+// checkGoroot checks that the standard library -stdlib reports exists, and
+// that it is that of the go command.
+func checkGoroot(env map[string]string) finding {
+	goroot, err := stdlibRoot()
+	if err != nil {
+		return finding{"goroot", true, err.Error(), "pass -goroot the directory of a Go installation"}
+	}
+	if goroot == "" {
+		return finding{"goroot", true, "the Go installation could not be found", "set GOROOT or pass -goroot"}
+	}
+	if _, err := os.Stat(filepath.Join(goroot, "src", "runtime")); err != nil {
+		return finding{"goroot", true, fmt.Sprintf("%s holds no standard library", goroot), "set GOROOT or pass -goroot the directory of a Go installation"}
+	}
+	f := finding{check: "goroot", msg: fmt.Sprintf("%s (%s)", goroot, goVersion(goroot))}
+	if gocmd := env["GOROOT"]; gocmd != "" && *gorootFlag == "" && !sameDir(gocmd, goroot) {
+		f.problem = true
+		f.msg += fmt.Sprintf(", but the go command uses %s", gocmd)
+		f.fix = fmt.Sprintf("set GOROOT=%s, or pass -goroot go to report the standard library of the go command", gocmd)
+	}
+	return f
+}
+
+// checkGopath checks that the GOPATH entries, which the go command and
+// -packages read packages from, exist.
+func checkGopath() []finding {
+	var findings []finding
+	for _, dir := range filepath.SplitList(build.Default.GOPATH) {
+		if dir == "" {
+			continue
+		}
+		if fi, err := os.Stat(dir); err != nil {
+			if os.Getenv("GOPATH") == "" && os.IsNotExist(err) {
+				// The default, created by the go command when needed.
+				findings = append(findings, finding{check: "gopath", msg: fmt.Sprintf("%s (the default, not created yet)", dir)})
+				continue
+			}
+			findings = append(findings, finding{"gopath", true, err.Error(), "create it or remove it from GOPATH"})
+		} else if !fi.IsDir() {
+			findings = append(findings, finding{"gopath", true, fmt.Sprintf("%s is not a directory", dir), "remove it from GOPATH"})
+		} else {
+			findings = append(findings, finding{check: "gopath", msg: dir})
+		}
+	}
+	if findings == nil {
+		findings = append(findings, finding{"gopath", true, "GOPATH is empty", "set GOPATH, or unset it to use the default"})
+	}
+	return findings
+}
+
+// checkModules reports how root is scanned: as a GOPATH if it has a src
+// directory, or else as the module and workspace the go command finds.
+func checkModules(root string, env map[string]string) finding {
+	if fi, err := os.Stat(filepath.Join(root, "src")); err == nil && fi.IsDir() {
+		return finding{check: "mode", msg: fmt.Sprintf("%s is scanned as a GOPATH: import paths are relative to its src directory", root)}
+	}
+	gomod := env["GOMOD"]
+	if gomod == "" || gomod == os.DevNull {
+		if _, err := os.Stat(filepath.Join(root, "go.mod")); err == nil && env["GO111MODULE"] == "off" {
+			return finding{"mode", true, fmt.Sprintf("%s has a go.mod file, but GO111MODULE=off keeps -packages and -typed from using it", root), "unset GO111MODULE"}
+		}
+		return finding{check: "mode", msg: fmt.Sprintf("%s is in no module: import paths follow the go.mod files below it, if any, or else are relative to it", root)}
+	}
+	f := finding{check: "mode", msg: fmt.Sprintf("module mode, with %s", gomod)}
+	if gowork := env["GOWORK"]; gowork != "" && gowork != "off" {
+		f.msg += fmt.Sprintf(" in the workspace of %s", gowork)
+	}
+	if strings.Contains(env["GOFLAGS"], "-mod=vendor") {
+		f.msg += ", reading dependencies from vendor"
+	}
+	return f
+}
+
+// checkCacheDir checks that indexes and caches can be written to the
+// cache directory.
+func checkCacheDir() finding {
+	fix := fmt.Sprintf("pass -cache-dir, or set %s, to a writable directory", envName("cache-dir"))
+	dir, err := cacheDir()
+	if err != nil {
+		return finding{"cache", true, err.Error(), fix}
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return finding{"cache", true, err.Error(), fix}
+	}
+	f, err := ioutil.TempFile(dir, "doctor")
+	if err != nil {
+		return finding{"cache", true, fmt.Sprintf("%s is not writable: %v", dir, err), fix}
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return finding{check: "cache", msg: dir}
+}
+
+// checkIndex checks that the index of dir, if any, can be read by this
+// version of gosymbols and is not corrupted.
+func checkIndex(dir string) finding {
+	path, err := indexFile(dir)
+	if err != nil {
+		return finding{"index", true, err.Error(), ""}
+	}
+	data, unmap, err := mapFile(path)
+	if os.IsNotExist(err) {
+		return finding{check: "index", msg: "none", fix: fmt.Sprintf("run go-symbols index %s to answer searches without scanning", dir)}
+	}
+	if err != nil {
+		return finding{"index", true, err.Error(), ""}
+	}
+	defer unmap()
+	idx, version, err := decodeIndex(data)
+	if err != nil {
+		return finding{"index", true, fmt.Sprintf("%s: %v", path, err), fmt.Sprintf("rebuild it with go-symbols index %s", dir)}
+	}
+	if idx == nil {
+		return finding{"index", true, fmt.Sprintf("%s has version %d, but this go-symbols reads version %d", path, version, indexVersion),
+			fmt.Sprintf("rebuild it with go-symbols index %s", dir)}
+	}
+	if bad := idx.verify(); len(bad) > 0 {
+		return finding{"index", true, fmt.Sprintf("%s: %d of %d files corrupted, such as %s", path, len(bad), len(idx.Files), bad[0]),
+			fmt.Sprintf("searches parse them again; or rebuild it with go-symbols index %s", dir)}
+	}
+	return finding{check: "index", msg: fmt.Sprintf("%s, version %d, %d files, built %s ago", path, version, len(idx.Files), time.Since(idx.Created).Round(time.Second))}
+}
+
+// checkWatcher checks that the serve, watch and lsp commands can watch all
+// directories of dir for changes.
+func checkWatcher(dir string) finding {
+	limit, name, ok := watchLimit()
+	if !ok {
+		return finding{check: "watch", msg: "no limit known on this system"}
+	}
+	n := 0
+	for _, root := range filepath.SplitList(dir) {
+		forEachWatched(root, func(string) error {
+			n++
+			return nil
+		})
+	}
+	f := finding{check: "watch", msg: fmt.Sprintf("%d directories to watch, %s is %d", n, name, limit)}
+	if n >= limit {
+		f.problem = true
+		f.fix = fmt.Sprintf("raise %s above %d; changes to the rest go unnoticed", name, n)
+	} else if n >= limit/2 {
+		f.fix = fmt.Sprintf("other programs watching files share %s; raise it if changes go unnoticed", name)
+	}
+	return f
+}
+
+// sameDir reports whether a and b name the same directory.
+func sameDir(a, b string) bool {
+	fa, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	fb, err := os.Stat(b)
+	return err == nil && os.SameFile(fa, fb)
+}
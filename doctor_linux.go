@@ -0,0 +1,18 @@
+package main
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// watchLimit returns the number of directories a user may watch for
+// changes, and the setting that limits it.
+func watchLimit() (int, string, bool) {
+	b, err := ioutil.ReadFile("/proc/sys/fs/inotify/max_user_watches")
+	if err != nil {
+		return 0, "", false
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	return n, "fs.inotify.max_user_watches", err == nil
+}
@@ -0,0 +1,7 @@
+//go:build !linux
+
+package main
+
+// watchLimit returns the number of directories a user may watch for
+// changes, and the setting that limits it.
+func watchLimit() (int, string, bool) { return 0, "", false }
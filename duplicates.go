@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// runDuplicates implements the duplicates command, which writes the
+// exported funcs and types whose names are declared by several packages of
+// the tree, a common source of importing the wrong one.
+func runDuplicates(args []string) error {
+	dir, query := parseArgs(args)
+	format := writeDuplicatesJSON
+	if *formatFlag != "json" || *templateFlag != "" {
+		var err error
+		if format, err = outputFormat(); err != nil {
+			return err
+		}
+	}
+
+	byName := make(map[string][]symbol)
+	sum := searchIndex(context.Background(), dir, query, func(found []symbol) {
+		for _, s := range found {
+			if ast.IsExported(s.Name) && s.Receiver == "" && s.Package != "main" && !strings.HasSuffix(s.Path, "_test.go") {
+				byName[s.Name] = append(byName[s.Name], s)
+			}
+		}
+	})
+	notePartial(context.Background(), sum)
+	for _, e := range sum.errors {
+		logger.Warn("could not read package", "dir", e.Dir, "err", e.Message)
+	}
+
+	dups := make([]symbol, 0)
+	for _, syms := range byName {
+		// Declarations of a package constrained to different platforms
+		// are one.
+		dirs := make(map[string]bool)
+		for _, s := range syms {
+			dirs[filepath.Dir(s.Path)] = true
+		}
+		if len(dirs) > 1 {
+			dups = append(dups, syms...)
+		}
+	}
+	sort.Slice(dups, func(i, j int) bool {
+		if dups[i].Name != dups[j].Name {
+			return dups[i].Name < dups[j].Name
+		}
+		return symbolOrders["path"](dups[i], dups[j])
+	})
+	rewritePaths(dups)
+	return format(os.Stdout, dups)
+}
+
+// writeDuplicatesJSON writes a JSON object mapping each name to its
+// declarations.
+func writeDuplicatesJSON(w io.Writer, syms []symbol) error {
+	byName := make(map[string][]symbol)
+	for _, s := range syms {
+		byName[s.Name] = append(byName[s.Name], s)
+	}
+	b, err := marshalJSON(jsonSymbols(byName))
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"os"
+	"sort"
+
+	"github.com/newhook/go-symbols/symbols"
+)
+
+// runErrors implements the errors command, which writes the types of the
+// tree implementing error and its sentinel errors, the package-level
+// variables named Err... holding errors.
+func runErrors(args []string) error {
+	dir, _ := parseArgs(args)
+	format, err := outputFormat()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	syms, errs := symbols.ErrorDecls(ctx, typeCheckOptions(dir))
+	for _, e := range errs {
+		// Their errors are missing.
+		logger.Warn("could not read package", "dir", e.Dir, "err", e.Message)
+		partial = true
+	}
+	if syms == nil {
+		syms = []symbol{}
+	}
+	sort.Slice(syms, func(i, j int) bool { return symbolOrders["path"](syms[i], syms[j]) })
+	rewritePaths(syms)
+	return format(os.Stdout, syms)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// writeEtags writes syms as an Emacs TAGS file. Each file gets its own
+// section whose tag lines carry the text of the defining line up to the
+// symbol name, the 1-based line number and the byte offset of the line.
+func writeEtags(w io.Writer, syms []symbol) error {
+	byPath := make(map[string][]symbol)
+	var paths []string
+	for _, s := range syms {
+		if _, ok := byPath[s.Path]; !ok {
+			paths = append(paths, s.Path)
+		}
+		byPath[s.Path] = append(byPath[s.Path], s)
+	}
+	sort.Strings(paths)
+
+	bw := bufio.NewWriter(w)
+	for _, path := range paths {
+		fsyms := byPath[path]
+		sort.SliceStable(fsyms, func(i, j int) bool { return fsyms[i].Line < fsyms[j].Line })
+
+		// A missing or unreadable file still gets its tags, just without
+		// the line text Emacs uses to refine the match.
+		src, _ := ioutil.ReadFile(sourcePath(path))
+		starts := lineStarts(src)
+
+		var section bytes.Buffer
+		for _, s := range fsyms {
+			text, offset := s.Name, 0
+			if s.Line >= 0 && s.Line < len(starts) {
+				offset = starts[s.Line]
+				line := src[offset:]
+				if i := bytes.IndexByte(line, '\n'); i >= 0 {
+					line = line[:i]
+				}
+				text = strings.TrimRight(string(line), "\r")
+				if i := strings.Index(text, s.Name); i >= 0 {
+					text = text[:i+len(s.Name)]
+				}
+			}
+			fmt.Fprintf(&section, "%s\x7f%s\x01%d,%d\n", text, s.Name, s.Line+1, offset)
+		}
+		fmt.Fprintf(bw, "\x0c\n%s,%d\n", path, section.Len())
+		bw.Write(section.Bytes())
+	}
+	return bw.Flush()
+}
+
+// nameOffset returns the byte offset of the name of s in src, whose lines
+// start at starts, or false if the name is not at the position of s, as
+// in a file changed since it was scanned.
+func nameOffset(src []byte, starts []int, s symbol) (int, bool) {
+	if s.Line < 0 || s.Line >= len(starts) {
+		return 0, false
+	}
+	start := starts[s.Line] + s.Character
+	end := start + len(s.Name)
+	if end > len(src) || string(src[start:end]) != s.Name {
+		return 0, false
+	}
+	return start, true
+}
+
+// lineStarts returns the byte offset of the start of each line in src.
+func lineStarts(src []byte) []int {
+	starts := []int{0}
+	for i, b := range src {
+		if b == '\n' && i+1 < len(src) {
+			starts = append(starts, i+1)
+		}
+	}
+	return starts
+}
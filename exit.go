@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// The exit codes of gosymbols, which let scripts tell a search without
+// matches from one that failed.
+const (
+	exitOK      = 0 // even if nothing matched
+	exitPartial = 1 // results were written, but some packages could not be read or the scan stopped early; apidiff found incompatible changes; or doctor found problems
+	exitUsage   = 2 // the command line is invalid
+	exitFatal   = 3 // no results could be written
+)
+
+// A usageError is an invalid command line.
+type usageError struct{ msg string }
+
+func (e *usageError) Error() string { return e.msg }
+
+func usagef(format string, args ...interface{}) error {
+	return &usageError{fmt.Sprintf(format, args...)}
+}
+
+// partial is set once results are written that are missing symbols.
+var partial bool
+
+// notePartial records whether the scan summarized by sum, stopped early if
+// ctx is done, missed symbols.
+func notePartial(ctx context.Context, sum *scanSummary) {
+	if len(sum.errors) > 0 || ctx.Err() != nil {
+		partial = true
+	}
+}
+
+// exit exits with the code for err, the error returned by cmd, reporting
+// it on standard error. If cmd writes json, the error is also written to
+// standard output as an object, for the programs reading it.
+func exit(cmd *command, err error) {
+	if err == nil {
+		if partial {
+			os.Exit(exitPartial)
+		}
+		os.Exit(exitOK)
+	}
+	code := exitFatal
+	if _, ok := err.(*usageError); ok {
+		code = exitUsage
+	}
+	fmt.Fprintf(os.Stderr, "go-symbols: %s\n", err)
+	if cmd != nil && cmd.accepts("format") && *formatFlag == "json" && *templateFlag == "" {
+		b, _ := json.Marshal(struct {
+			Error    string `json:"error"`
+			ExitCode int    `json:"exitCode"`
+		}{err.Error(), code})
+		fmt.Fprintf(os.Stdout, "%s\n", b)
+	}
+	os.Exit(code)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+var (
+	stdinFlag        = flag.Bool("stdin", false, "with file, read the contents of the file from standard input, such as an unsaved buffer")
+	hierarchicalFlag = flag.Bool("hierarchical", false, "with file, nest the fields and methods of types under them, in json and lsp output")
+)
+
+// runFile implements the file command, which writes the symbols declared
+// in a single file, parsing nothing else, so that editors can outline a
+// buffer without scanning the workspace. With -stdin, the contents of the
+// file are read from standard input. With -hierarchical, the fields and
+// methods of the types declared in the file are nested under them: as
+// "children" in json output, and as LSP DocumentSymbols in lsp output.
+func runFile(args []string) error {
+	if len(args) != 1 {
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+	filename := args[0]
+	if *hierarchicalFlag && (*templateFlag != "" || *formatFlag != "json" && *formatFlag != "lsp") {
+		return usagef("-hierarchical is only supported with -format json or lsp")
+	}
+	setWorkspace(filepath.Dir(filename))
+	format, err := outputFormat()
+	if err != nil {
+		return err
+	}
+
+	var src []byte
+	if *stdinFlag {
+		if src, err = ioutil.ReadAll(os.Stdin); err != nil {
+			return fmt.Errorf("reading standard input: %v", err)
+		}
+		if src == nil {
+			src = []byte{} // empty, rather than read from the file
+		}
+	}
+	syms, err := parseFile(filename, src)
+	if err != nil {
+		return err
+	}
+	rewritePaths(syms)
+	if !*hierarchicalFlag {
+		return format(os.Stdout, syms)
+	}
+	tree := symbolTree(syms)
+	if *formatFlag == "lsp" {
+		return writeDocumentSymbols(os.Stdout, tree)
+	}
+	b := appendSymbolTreeJSON(nil, tree, "", *compactFlag)
+	_, err = os.Stdout.Write(append(b, '\n'))
+	return err
+}
+
+// A symbolNode is a symbol with those nested under it: the fields and
+// methods of a type.
+type symbolNode struct {
+	sym      symbol
+	children []*symbolNode
+}
+
+// symbolTree nests the fields and methods among syms under the types among
+// them they belong to, keeping their order. The other symbols, including
+// the methods of types declared elsewhere, are at the top.
+func symbolTree(syms []symbol) []*symbolNode {
+	nodes := make([]*symbolNode, len(syms))
+	types := make(map[string]*symbolNode)
+	for i := range syms {
+		nodes[i] = &symbolNode{sym: syms[i]}
+		if syms[i].Kind == "type" {
+			types[syms[i].Name] = nodes[i]
+		}
+	}
+	var roots []*symbolNode
+	for _, n := range nodes {
+		if n.sym.Receiver != "" {
+			if parent := types[receiverType(n.sym.Receiver)]; parent != nil {
+				parent.children = append(parent.children, n)
+				continue
+			}
+		}
+		roots = append(roots, n)
+	}
+	return roots
+}
+
+// appendSymbolTreeJSON appends nodes as a JSON array of symbols, encoded
+// as by the json format, those with nested symbols ending with them as
+// "children".
+func appendSymbolTreeJSON(b []byte, nodes []*symbolNode, prefix string, compact bool) []byte {
+	newline := func(indent string) {
+		if !compact {
+			b = append(b, '\n')
+			b = append(b, indent...)
+		}
+	}
+	b = append(b, '[')
+	for i, n := range nodes {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		newline(prefix + " ")
+		obj := appendSymbolJSON(nil, &n.sym, prefix+" ", compact)
+		if len(n.children) == 0 {
+			b = append(b, obj...)
+			continue
+		}
+		end := "}"
+		if !compact {
+			end = "\n" + prefix + " }"
+		}
+		b = append(b, bytes.TrimSuffix(obj, []byte(end))...)
+		b = append(b, ',')
+		newline(prefix + "  ")
+		b = append(b, `"children":`...)
+		if !compact {
+			b = append(b, ' ')
+		}
+		b = appendSymbolTreeJSON(b, n.children, prefix+"  ", compact)
+		newline(prefix + " ")
+		b = append(b, '}')
+	}
+	if len(nodes) > 0 {
+		newline(prefix)
+	}
+	return append(b, ']')
+}
+
+// writeDocumentSymbols writes nodes as a JSON array of LSP DocumentSymbol,
+// as returned by a textDocument/documentSymbol request.
+func writeDocumentSymbols(w io.Writer, nodes []*symbolNode) error {
+	b, err := marshalJSON(lspDocumentSymbols(nodes, make(map[string][]string)))
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
+
+func lspDocumentSymbols(nodes []*symbolNode, lines map[string][]string) []lspDocumentSymbol {
+	docs := make([]lspDocumentSymbol, len(nodes))
+	for i, n := range nodes {
+		r := utf16Range(n.sym, lines)
+		docs[i] = lspDocumentSymbol{
+			Name:           n.sym.Name,
+			Detail:         n.sym.Signature,
+			Kind:           lspSymbolKind(n.sym),
+			Range:          r,
+			SelectionRange: r,
+			Children:       lspDocumentSymbols(n.children, lines),
+		}
+	}
+	return docs
+}
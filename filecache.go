@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"flag"
+	"go/build"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var parseCacheFlag = flag.Bool("parse-cache", false, "reuse the symbols of files unchanged since earlier runs, kept in the cache directory")
+
+// A fileSymbolCache holds the symbols of previously parsed files so that
+// scans can skip files that have not changed since. Each scan uses its own,
+// which is safe for use by its workers. A nil *fileSymbolCache caches
+// nothing.
+type fileSymbolCache struct {
+	mu   sync.Mutex
+	old  map[string]*cachedFile // from the previous scan
+	seen map[string]*cachedFile // files seen by this scan
+
+	reused, parsed int
+}
+
+// cachedFile records the symbols of a file along with what identifies its
+// contents.
+type cachedFile struct {
+	size    int64
+	modTime time.Time
+	hash    [sha256.Size]byte
+	syms    []symbol
+}
+
+// newFileSymbolCache returns a cache holding the files in old, keyed by
+// path.
+func newFileSymbolCache(old map[string]*cachedFile) *fileSymbolCache {
+	if old == nil {
+		old = make(map[string]*cachedFile)
+	}
+	return &fileSymbolCache{
+		old:  old,
+		seen: make(map[string]*cachedFile),
+	}
+}
+
+// Lookup returns the symbols of the named file if it is unchanged. If src
+// is nil, the file is unchanged if its size and modification time match;
+// otherwise if its contents hash to the same value.
+func (c *fileSymbolCache) Lookup(filename string, fi os.FileInfo, src []byte) ([]symbol, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f, ok := c.old[filename]
+	if !ok || f.size != fi.Size() {
+		return nil, false
+	}
+	if src == nil {
+		if !f.modTime.Equal(fi.ModTime()) {
+			return nil, false
+		}
+	} else if sha256.Sum256(src) != f.hash {
+		return nil, false
+	}
+	c.seen[filename] = &cachedFile{
+		size:    f.size,
+		modTime: fi.ModTime(),
+		hash:    f.hash,
+		syms:    f.syms,
+	}
+	c.reused++
+	return f.syms, true
+}
+
+// Store records the symbols found in a file that was parsed.
+func (c *fileSymbolCache) Store(filename string, fi os.FileInfo, src []byte, syms []symbol) {
+	if c == nil {
+		return
+	}
+	f := &cachedFile{
+		size:    fi.Size(),
+		modTime: fi.ModTime(),
+		hash:    sha256.Sum256(src),
+		syms:    append([]symbol(nil), syms...),
+	}
+	c.mu.Lock()
+	c.seen[filename] = f
+	c.parsed++
+	c.mu.Unlock()
+}
+
+// parseCacheKey returns the options on which the symbols parsed from a file
+// depend, which key the files caching them.
+func parseCacheKey() []string {
+	return []string{strconv.FormatBool(parseDocs), strconv.FormatBool(*withSource), strconv.FormatBool(*fieldsFlag), strconv.FormatBool(*packageDocsFlag)}
+}
+
+// cachedScan is a symbolSource scanning like scan, but reusing the symbols
+// of files unchanged since earlier scans of dir with the same options, and
+// saving them for later scans.
+func cachedScan(ctx context.Context, dir, query string, found func([]symbol)) *scanSummary {
+	path, err := cacheFile("parse", dir, parseCacheKey()...)
+	if err != nil {
+		return scan(ctx, dir, query, found)
+	}
+	var old map[string]*cachedFile
+	if prev, err := readIndex(path); err == nil {
+		old = prev.cachedFiles()
+		prev.close()
+	}
+	cache := newFileSymbolCache(old)
+	sum := scanCached(ctx, dir, query, cache, found)
+
+	// A complete scan has seen all files that still exist. Otherwise the
+	// files it did not reach are kept.
+	files := cache.seen
+	if ctx.Err() != nil || len(onlyPrefix) > 0 {
+		for name, f := range old {
+			if _, ok := files[name]; !ok {
+				files[name] = f
+			}
+		}
+	}
+	idx := &symbolIndex{
+		Version: indexVersion,
+		Root:    dir,
+		Tags:    build.Default.BuildTags,
+		Created: time.Now(),
+	}
+	idx.setFiles(files)
+	if err := saveIndex(path, idx); err != nil {
+		sum.errors = append(sum.errors, scanError{Dir: dir, Phase: "cache", Message: "saving the parse cache: " + err.Error()})
+		sum.stats.Errors++
+	}
+	return sum
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/newhook/go-symbols/symbols"
+)
+
+// runGraph implements the graph command, which writes a Graphviz graph of
+// the types whose names match the query, their methods, and the types they
+// embed. With -typed, embedded types are resolved by type-checking the
+// tree rather than by name, which dot imports and packages sharing a name
+// mislead.
+func runGraph(args []string) error {
+	dir, query := parseArgs(args)
+	if !flagSet("format") {
+		*formatFlag = "dot"
+	}
+	if *formatFlag != "dot" {
+		return usagef("graph supports only -format dot")
+	}
+
+	source := scan
+	if *typedFlag {
+		// Embedded types are resolved by the type checker.
+		source = typeChecked(source, symbols.Typecheck)
+	}
+	var syms []symbol
+	source(context.Background(), dir, "", func(found []symbol) {
+		syms = append(syms, found...)
+	})
+	return writeDot(os.Stdout, query, syms)
+}
+
+type graphType struct {
+	sym     symbol
+	methods []symbol
+}
+
+// writeDot writes the types matching query as a DOT digraph with a cluster
+// per package, a record node per type listing its methods, and an edge to
+// each embedded type.
+func writeDot(w io.Writer, query string, syms []symbol) error {
+	typesByKey := make(map[string]*graphType)
+	byPkgName := make(map[string][]string) // "pkg.Name" -> keys
+	for _, s := range syms {
+		if s.Kind != "type" {
+			continue
+		}
+		key := s.ImportPath + "." + s.Name
+		typesByKey[key] = &graphType{sym: s}
+		byPkgName[s.Package+"."+s.Name] = append(byPkgName[s.Package+"."+s.Name], key)
+	}
+	for _, s := range syms {
+		if s.Receiver == "" {
+			continue
+		}
+		if t, ok := typesByKey[s.ImportPath+"."+receiverType(s.Receiver)]; ok {
+			t.methods = append(t.methods, s)
+		}
+	}
+
+	var keys []string
+	for key, t := range typesByKey {
+		if strings.Contains(strings.ToLower(t.sym.Name), query) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph gosymbols {")
+	fmt.Fprintln(bw, "\trankdir=LR;")
+	fmt.Fprintln(bw, "\tnode [shape=record];")
+
+	byPkg := make(map[string][]string)
+	var pkgs []string
+	for _, key := range keys {
+		pkg := typesByKey[key].sym.ImportPath
+		if _, ok := byPkg[pkg]; !ok {
+			pkgs = append(pkgs, pkg)
+		}
+		byPkg[pkg] = append(byPkg[pkg], key)
+	}
+	for _, pkg := range pkgs {
+		fmt.Fprintf(bw, "\tsubgraph %q {\n", "cluster_"+pkg)
+		fmt.Fprintf(bw, "\t\tlabel=%q;\n", pkg)
+		for _, key := range byPkg[pkg] {
+			t := typesByKey[key]
+			label := dotEscape(t.sym.Name)
+			if len(t.methods) > 0 {
+				var ms []string
+				for _, m := range t.methods {
+					ms = append(ms, dotEscape(m.Name+m.Signature)+`\l`)
+				}
+				label = "{" + label + "|" + strings.Join(ms, "") + "}"
+			}
+			fmt.Fprintf(bw, "\t\t%q [label=\"%s\"];\n", key, label)
+		}
+		fmt.Fprintln(bw, "\t}")
+	}
+
+	var edges []string
+	for _, key := range keys {
+		t := typesByKey[key]
+		for _, e := range t.sym.Embeds {
+			to := resolveEmbed(t.sym, e, byPkgName)
+			if _, ok := typesByKey[to]; !ok {
+				// Declare types outside the graph as plain nodes.
+				edges = append(edges, fmt.Sprintf("\t%q [shape=box, style=dashed];", to))
+			}
+			edges = append(edges, fmt.Sprintf("\t%q -> %q [label=\"embeds\"];", key, to))
+		}
+	}
+	for _, e := range edges {
+		fmt.Fprintln(bw, e)
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+// resolveEmbed returns the key, import path and name, of an embedded type
+// expression such as "Server", "foo.Server" or "List[T]" as seen from the
+// package of t. byPkgName maps the package name and name of each type to
+// its keys; expressions naming no single one, such as the keys Typecheck
+// resolves embedded types to, are returned as they are.
+func resolveEmbed(t symbol, expr string, byPkgName map[string][]string) string {
+	if i := strings.IndexByte(expr, '['); i >= 0 {
+		expr = expr[:i]
+	}
+	if !strings.Contains(expr, ".") {
+		return t.ImportPath + "." + expr
+	}
+	if ks := byPkgName[expr]; len(ks) == 1 {
+		return ks[0]
+	}
+	return expr
+}
+
+// dotEscape escapes the characters with special meaning in record labels.
+func dotEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "{", `\{`, "}", `\}`, "|", `\|`, "<", `\<`, ">", `\>`).Replace(s)
+}
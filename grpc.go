@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// gRPC status codes.
+const (
+	grpcOK               = 0
+	grpcInvalidArgument  = 3
+	grpcPermissionDenied = 7
+	grpcUnimplemented    = 12
+	grpcInternal         = 13
+	grpcUnauthenticated  = 16
+)
+
+// maxGRPCMessage bounds the size of request messages, which are small.
+const maxGRPCMessage = 1 << 20
+
+// A grpcError is a failed call with its gRPC status code.
+type grpcError struct {
+	code int
+	msg  string
+}
+
+func (e *grpcError) Error() string { return e.msg }
+
+// runGRPC implements the grpc command, which serves the Symbols service of
+// proto/service.proto on -listen. It scans dir once and keeps the symbols
+// up to date as files change, like the serve command.
+//
+// gRPC is spoken directly over the unencrypted HTTP/2 of net/http, with the
+// messages encoded as proto.go does, rather than with generated code.
+func runGRPC(args []string) error {
+	dir, _ := parseArgs(args)
+	srv := newSymbolServer(dir)
+	srv.loadAndWatch(context.Background())
+
+	l, err := listen()
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/gosymbols.Symbols/Search", srv.grpcHandler(srv.grpcSearch))
+	mux.HandleFunc("/gosymbols.Symbols/Index", srv.grpcHandler(srv.grpcIndex))
+	mux.HandleFunc("/gosymbols.Symbols/Watch", srv.grpcHandler(srv.grpcWatch))
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/grpc")
+		writeGRPCStatus(w, &grpcError{grpcUnimplemented, "unknown method " + r.URL.Path})
+	})
+	hs := &http.Server{Handler: mux, Protocols: new(http.Protocols)}
+	hs.Protocols.SetHTTP2(true)
+	hs.Protocols.SetUnencryptedHTTP2(true)
+	logger.Info("serving over gRPC", "dir", dir, "addr", l.Addr().String())
+	return serveSecurely(hs, l, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/grpc")
+		writeGRPCStatus(w, &grpcError{grpcUnauthenticated, "a valid bearer token is required"})
+	})
+}
+
+// grpcHandler returns the handler of a method, which is given the request
+// message and calls send with each response message.
+func (s *symbolServer) grpcHandler(method func(ctx context.Context, req []byte, send func([]byte) error) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			http.Error(w, "gRPC requests only", http.StatusUnsupportedMediaType)
+			return
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		if !requestGrant(r.Context()).allows(s.dir) {
+			writeGRPCStatus(w, &grpcError{grpcPermissionDenied, "the token does not grant access to " + s.dir})
+			return
+		}
+		req, err := readGRPCMessage(r.Body)
+		if err == nil {
+			var frame []byte
+			err = method(r.Context(), req, func(msg []byte) error {
+				frame = append(frame[:0], 0) // uncompressed
+				frame = binary.BigEndian.AppendUint32(frame, uint32(len(msg)))
+				if _, err := w.Write(append(frame, msg...)); err != nil {
+					return err
+				}
+				http.NewResponseController(w).Flush()
+				return nil
+			})
+		}
+		writeGRPCStatus(w, err)
+	}
+}
+
+// readGRPCMessage reads the single message of a unary or server-streaming
+// call.
+func readGRPCMessage(r io.Reader) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, &grpcError{grpcInvalidArgument, "reading request: " + err.Error()}
+	}
+	if prefix[0] != 0 {
+		return nil, &grpcError{grpcUnimplemented, "compressed requests are not supported"}
+	}
+	n := binary.BigEndian.Uint32(prefix[1:])
+	if n > maxGRPCMessage {
+		return nil, &grpcError{grpcInvalidArgument, "request too large"}
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, &grpcError{grpcInvalidArgument, "reading request: " + err.Error()}
+	}
+	return msg, nil
+}
+
+// writeGRPCStatus ends a call with the status of err in the trailers.
+func writeGRPCStatus(w http.ResponseWriter, err error) {
+	code, msg := grpcOK, ""
+	if err != nil {
+		code, msg = grpcInternal, err.Error()
+		if gerr, ok := err.(*grpcError); ok {
+			code = gerr.code
+		}
+	}
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+	if msg != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", grpcPercentEncode(msg))
+	}
+}
+
+// grpcPercentEncode encodes a status message as the gRPC protocol requires.
+func grpcPercentEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c >= ' ' && c <= '~' && c != '%' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// Field numbers of the messages in proto/service.proto.
+const (
+	protoSearchQuery             = 1
+	protoSearchKind              = 2
+	protoSearchLimit             = 3
+	protoSearchContinuationToken = 4
+
+	protoResultSymbols           = 1 // of SearchResponse and WatchResponse
+	protoResultErrors            = 2
+	protoResultContinuationToken = 3
+
+	protoErrorImportPath = 1
+	protoErrorDir        = 2
+	protoErrorMessage    = 3
+	protoErrorPhase      = 4
+
+	protoIndexFiles   = 1
+	protoIndexSymbols = 2
+	protoIndexErrors  = 3
+	protoIndexLoadMs  = 4
+)
+
+// parseSearchRequest decodes a SearchRequest or WatchRequest message, which
+// share their fields.
+func parseSearchRequest(b []byte) (serveRequest, error) {
+	var req serveRequest
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return req, &grpcError{grpcInvalidArgument, protowire.ParseError(n).Error()}
+		}
+		b = b[n:]
+		switch {
+		case num == protoSearchQuery && typ == protowire.BytesType:
+			req.Query, n = protowire.ConsumeString(b)
+		case num == protoSearchKind && typ == protowire.BytesType:
+			req.Kind, n = protowire.ConsumeString(b)
+		case num == protoSearchContinuationToken && typ == protowire.BytesType:
+			req.ContinuationToken, n = protowire.ConsumeString(b)
+		case num == protoSearchLimit && typ == protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(b)
+			req.Limit = int(int32(v))
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return req, &grpcError{grpcInvalidArgument, protowire.ParseError(n).Error()}
+		}
+		b = b[n:]
+	}
+	return req, nil
+}
+
+// appendProtoResult appends the fields of a SearchResponse or
+// WatchResponse holding env to b.
+func appendProtoResult(b []byte, env envelope) []byte {
+	var msg []byte
+	for _, s := range env.Symbols.([]symbol) {
+		msg = appendProtoSymbol(msg[:0], s)
+		b = protowire.AppendTag(b, protoResultSymbols, protowire.BytesType)
+		b = protowire.AppendBytes(b, msg)
+	}
+	for _, e := range env.Errors {
+		msg = appendProtoString(msg[:0], protoErrorImportPath, e.ImportPath)
+		msg = appendProtoString(msg, protoErrorDir, e.Dir)
+		msg = appendProtoString(msg, protoErrorMessage, e.Message)
+		msg = appendProtoString(msg, protoErrorPhase, e.Phase)
+		b = protowire.AppendTag(b, protoResultErrors, protowire.BytesType)
+		b = protowire.AppendBytes(b, msg)
+	}
+	return appendProtoString(b, protoResultContinuationToken, env.ContinuationToken)
+}
+
+func (s *symbolServer) grpcSearch(ctx context.Context, b []byte, send func([]byte) error) error {
+	req, err := parseSearchRequest(b)
+	if err != nil {
+		return err
+	}
+	env, err := s.answer(req)
+	if err != nil {
+		return &grpcError{grpcInvalidArgument, err.Error()}
+	}
+	return send(appendProtoResult(nil, env))
+}
+
+func (s *symbolServer) grpcIndex(ctx context.Context, b []byte, send func([]byte) error) error {
+	s.load()
+	st := s.status()
+	msg := appendProtoInt(nil, protoIndexFiles, st.Files)
+	msg = appendProtoInt(msg, protoIndexSymbols, st.Symbols)
+	msg = appendProtoInt(msg, protoIndexErrors, st.Errors)
+	if st.LoadMs != 0 {
+		msg = protowire.AppendTag(msg, protoIndexLoadMs, protowire.Fixed64Type)
+		msg = protowire.AppendFixed64(msg, math.Float64bits(st.LoadMs))
+	}
+	return send(msg)
+}
+
+// grpcWatch sends the symbols matching the request, and again after each
+// load, until the client goes away.
+func (s *symbolServer) grpcWatch(ctx context.Context, b []byte, send func([]byte) error) error {
+	req, err := parseSearchRequest(b)
+	if err != nil {
+		return err
+	}
+	req.Limit, req.ContinuationToken = 0, ""
+	for {
+		updated := s.updates()
+		env, err := s.answer(req)
+		if err != nil {
+			return &grpcError{grpcInvalidArgument, err.Error()}
+		}
+		if err := send(appendProtoResult(nil, env)); err != nil {
+			return err
+		}
+		select {
+		case <-updated:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
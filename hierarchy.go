@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/newhook/go-symbols/symbols"
+)
+
+// runHierarchy implements the hierarchy command, which writes the types a
+// named type embeds, in a struct or interface, and those they embed in
+// turn, and the types embedding it, and those embedding them in turn, with
+// the positions of their declarations. Types are read from the index of the
+// tree, or by a scan if it has none, as by the def command. With -typed,
+// the embedded types of the tree, though not of the standard library, are
+// resolved by type-checking its packages.
+func runHierarchy(args []string) error {
+	if len(args) == 1 {
+		args = []string{".", args[0]}
+	}
+	if len(args) != 2 {
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+	dir, name := args[0], args[1]
+	setWorkspace(dir)
+	i := strings.LastIndexByte(name, '.')
+	if i <= strings.LastIndexByte(name, '/') || i == len(name)-1 {
+		return usagef("%q is not a qualified type name such as net/http.Server", name)
+	}
+	if !flagSet("format") {
+		*formatFlag = "" // nor are errors written as json
+	} else if *formatFlag != "json" {
+		return usagef("hierarchy supports only -format json")
+	}
+
+	source := searchIndex
+	if *typedFlag {
+		source = typeChecked(source, symbols.Typecheck)
+	}
+	if inStdlib(name) || *stdlibFlag {
+		goroot, err := stdlibRoot()
+		if err != nil {
+			return err
+		}
+		source = withStdlib(goroot, source)
+	}
+	var types []symbol
+	sum := source(context.Background(), dir, "", func(found []symbol) {
+		for _, s := range found {
+			if s.Kind == "type" {
+				types = append(types, s)
+			}
+		}
+	})
+	rewritePaths(types)
+	h := newTypeHierarchy(types)
+	if _, ok := h.types[name]; !ok {
+		for _, e := range sum.errors {
+			logger.Warn("could not read package", "dir", e.Dir, "err", e.Message)
+		}
+		return fmt.Errorf("type %s is not declared in %s", name, dir)
+	}
+	root := h.node(name)
+	root.Embeds = h.up(name, map[string]bool{name: true})
+	root.EmbeddedBy = h.down(name, map[string]bool{name: true})
+
+	if *formatFlag == "json" {
+		b, err := json.MarshalIndent(root, "", " ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(os.Stdout, "%s\n", b)
+		return err
+	}
+	return root.write(os.Stdout)
+}
+
+// A hierarchyNode is a type of the hierarchy, with the types it embeds or
+// is embedded by. Types outside the tree have no position.
+type hierarchyNode struct {
+	Name       string           `json:"name"` // qualified by import path, or by package name outside the tree
+	TypeKind   string           `json:"typeKind,omitempty"`
+	Path       string           `json:"path,omitempty"`
+	Line       int              `json:"line,omitempty"`      // 0-based
+	Character  int              `json:"character,omitempty"` // 0-based
+	Embeds     []*hierarchyNode `json:"embeds,omitempty"`
+	EmbeddedBy []*hierarchyNode `json:"embeddedBy,omitempty"`
+}
+
+// A typeHierarchy holds the embedding relations between types, keyed by
+// their import paths and names.
+type typeHierarchy struct {
+	types      map[string]symbol
+	embeds     map[string][]string
+	embeddedBy map[string][]string
+}
+
+func newTypeHierarchy(types []symbol) *typeHierarchy {
+	h := &typeHierarchy{
+		types:      make(map[string]symbol),
+		embeds:     make(map[string][]string),
+		embeddedBy: make(map[string][]string),
+	}
+	byPkgName := make(map[string][]string)
+	for _, s := range types {
+		key := s.ImportPath + "." + s.Name
+		if _, ok := h.types[key]; ok {
+			continue // declared for several platforms
+		}
+		h.types[key] = s
+		byPkgName[s.Package+"."+s.Name] = append(byPkgName[s.Package+"."+s.Name], key)
+	}
+	for key, s := range h.types {
+		for _, e := range s.Embeds {
+			to := resolveEmbed(s, e, byPkgName)
+			h.embeds[key] = append(h.embeds[key], to)
+			h.embeddedBy[to] = append(h.embeddedBy[to], key)
+		}
+	}
+	for _, keys := range h.embeddedBy {
+		sort.Strings(keys)
+	}
+	return h
+}
+
+func (h *typeHierarchy) node(key string) *hierarchyNode {
+	n := &hierarchyNode{Name: key}
+	if s, ok := h.types[key]; ok {
+		n.TypeKind, n.Path, n.Line, n.Character = s.TypeKind, s.Path, s.Line, s.Character
+	}
+	return n
+}
+
+// up returns the nodes of the types key embeds, with those they embed.
+// Types in seen, those on the way from the root, are not followed again.
+func (h *typeHierarchy) up(key string, seen map[string]bool) []*hierarchyNode {
+	return h.walk(key, h.embeds, seen, func(n *hierarchyNode, children []*hierarchyNode) {
+		n.Embeds = children
+	})
+}
+
+// down returns the nodes of the types embedding key, with those embedding
+// them.
+func (h *typeHierarchy) down(key string, seen map[string]bool) []*hierarchyNode {
+	return h.walk(key, h.embeddedBy, seen, func(n *hierarchyNode, children []*hierarchyNode) {
+		n.EmbeddedBy = children
+	})
+}
+
+func (h *typeHierarchy) walk(key string, edges map[string][]string, seen map[string]bool, set func(*hierarchyNode, []*hierarchyNode)) []*hierarchyNode {
+	var nodes []*hierarchyNode
+	for _, to := range edges[key] {
+		n := h.node(to)
+		if !seen[to] {
+			seen[to] = true
+			set(n, h.walk(to, edges, seen, set))
+			delete(seen, to)
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes
+}
+
+// write writes the hierarchy rooted at n as an indented tree, the types n
+// embeds above those embedding it, each with its position as
+// path:line:column.
+func (n *hierarchyNode) write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	n.writeLine(bw, "")
+	if len(n.Embeds) > 0 {
+		fmt.Fprintln(bw, "embeds:")
+		writeHierarchy(bw, n.Embeds, "  ", func(n *hierarchyNode) []*hierarchyNode { return n.Embeds })
+	}
+	if len(n.EmbeddedBy) > 0 {
+		fmt.Fprintln(bw, "embedded by:")
+		writeHierarchy(bw, n.EmbeddedBy, "  ", func(n *hierarchyNode) []*hierarchyNode { return n.EmbeddedBy })
+	}
+	return bw.Flush()
+}
+
+func writeHierarchy(w io.Writer, nodes []*hierarchyNode, indent string, children func(*hierarchyNode) []*hierarchyNode) {
+	for _, n := range nodes {
+		n.writeLine(w, indent)
+		writeHierarchy(w, children(n), indent+"  ", children)
+	}
+}
+
+func (n *hierarchyNode) writeLine(w io.Writer, indent string) {
+	if n.Path == "" {
+		fmt.Fprintf(w, "%s%s\n", indent, n.Name)
+		return
+	}
+	fmt.Fprintf(w, "%s%s %s:%d:%d\n", indent, n.Name, n.Path, n.Line+1, n.Character+1)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// runHTML implements the html command, which writes a static, searchable
+// symbol browser to the directory given by -o (default "symbols").
+func runHTML(args []string) error {
+	dir, query := parseArgs(args)
+	out := *outputFlag
+	if out == "" {
+		out = "symbols"
+	}
+
+	syms := make([]symbol, 0)
+	scan(context.Background(), dir, query, func(found []symbol) {
+		syms = append(syms, found...)
+	})
+	sort.Slice(syms, func(i, j int) bool { return symbolOrders["path"](syms[i], syms[j]) })
+	rewritePaths(syms)
+	return writeHTML(out, dir, syms)
+}
+
+// htmlSymbol is the record for a symbol in symbols.js.
+type htmlSymbol struct {
+	symbol
+	ImportPath string `json:"importPath"`
+}
+
+// writeHTML writes index.html and the symbol data it filters, symbols.js,
+// to dir. The data is a script rather than JSON so that the page also
+// works when opened from the file system.
+func writeHTML(dir, root string, syms []symbol) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	data := make([]htmlSymbol, len(syms))
+	for i, s := range syms {
+		data[i] = htmlSymbol{s, s.ImportPath}
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	js := append([]byte("var SYMBOLS = "), b...)
+	js = append(js, ";\n"...)
+	if err := ioutil.WriteFile(filepath.Join(dir, "symbols.js"), js, 0644); err != nil {
+		return err
+	}
+
+	f, err := os.Create(filepath.Join(dir, "index.html"))
+	if err != nil {
+		return err
+	}
+	if err := htmlTemplate.Execute(f, struct{ Root string }{root}); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+var htmlTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Symbols in {{.Root}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+input { font-size: 1.2em; width: 30em; }
+h2 { font-size: 1.1em; margin-top: 1.5em; }
+code { font-size: 0.95em; }
+.kind { color: #888; display: inline-block; width: 3em; }
+.loc { color: #888; font-size: 0.85em; }
+</style>
+</head>
+<body>
+<h1>Symbols in {{.Root}}</h1>
+<input id="q" placeholder="Filter symbols" autofocus>
+<div id="results"></div>
+<script src="symbols.js"></script>
+<script>
+function render() {
+	var q = document.getElementById("q").value.toLowerCase();
+	var byPkg = {};
+	var pkgs = [];
+	SYMBOLS.forEach(function(s) {
+		if (q && s.name.toLowerCase().indexOf(q) < 0) {
+			return;
+		}
+		var pkg = s.importPath || s.package;
+		if (!byPkg[pkg]) {
+			byPkg[pkg] = [];
+			pkgs.push(pkg);
+		}
+		byPkg[pkg].push(s);
+	});
+	pkgs.sort();
+
+	var results = document.getElementById("results");
+	results.textContent = "";
+	pkgs.forEach(function(pkg) {
+		var h = document.createElement("h2");
+		h.textContent = pkg;
+		results.appendChild(h);
+		var ul = document.createElement("ul");
+		byPkg[pkg].forEach(function(s) {
+			var li = document.createElement("li");
+			var kind = document.createElement("span");
+			kind.className = "kind";
+			kind.textContent = s.kind;
+			var code = document.createElement("code");
+			code.textContent = (s.receiver ? "(" + s.receiver + ") " : "") + s.name + (s.signature || "");
+			var loc = document.createElement("span");
+			loc.className = "loc";
+			loc.textContent = " " + s.path + ":" + (s.line + 1);
+			li.appendChild(kind);
+			li.appendChild(code);
+			li.appendChild(loc);
+			ul.appendChild(li);
+		});
+		results.appendChild(ul);
+	});
+}
+document.getElementById("q").addEventListener("input", render);
+render();
+</script>
+</body>
+</html>
+`))
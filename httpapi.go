@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+var httpFlag = flag.String("http", "", "`address` on which the serve command answers HTTP requests instead of its line protocol")
+
+// serveHTTP answers HTTP requests on addr from the symbols held by the
+// workspaces of ws, each given by a root parameter, which may be omitted
+// if only one is registered:
+//
+//	/symbols?q=query&kind=kind&limit=n&continuationToken=token
+//	                the envelope of the matching symbols, as a serve request
+//	/symbols/stream?kind=kind&limit=n
+//	                a WebSocket answering each query sent with the
+//	                matching symbols, best first, in batches
+//	/packages       the packages holding symbols
+//	/status         the status of the workspace
+//	/workspaces     the workspaces registered; POST registers the root
+//	                and DELETE unregisters it
+//	/metrics        the metrics of the server, for Prometheus
+//	/healthz        whether the server is running
+//	/readyz         whether the workspace given on the command line is
+//	                loaded, so that queries can be answered
+//
+// It stops once ctx is done, letting requests in progress complete.
+func (ws *workspaceSet) serveHTTP(ctx context.Context, addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	logger.Info("serving over HTTP", "addr", addr)
+	hs := &http.Server{Handler: ws.handler()}
+	go func() {
+		<-ctx.Done()
+		hs.Shutdown(context.Background())
+	}()
+	return serveSecurely(hs, l, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeHTTPJSON(w, http.StatusUnauthorized, map[string]string{"error": "a valid bearer token is required"})
+	})
+}
+
+// handler returns the handler of the endpoints listed by serveHTTP, before
+// authentication.
+func (ws *workspaceSet) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/symbols", ws.withServer(handleSymbols))
+	mux.HandleFunc("/symbols/stream", ws.withServer(handleSymbolStream))
+	mux.HandleFunc("/packages", ws.withServer(func(s *symbolServer, w http.ResponseWriter, r *http.Request) {
+		writeHTTPJSON(w, http.StatusOK, s.packages())
+	}))
+	mux.HandleFunc("/status", ws.withServer(func(s *symbolServer, w http.ResponseWriter, r *http.Request) {
+		writeHTTPJSON(w, http.StatusOK, s.status())
+	}))
+	mux.HandleFunc("/workspaces", ws.handleWorkspaces)
+	mux.HandleFunc("/metrics", ws.handleMetrics)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeHTTPJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !ws.isReady() {
+			writeHTTPJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "loading"})
+			return
+		}
+		writeHTTPJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+	})
+	return mux
+}
+
+// withServer returns a handler calling h with the server of the workspace
+// named by the root parameter, if the request may access it.
+func (ws *workspaceSet) withServer(h func(s *symbolServer, w http.ResponseWriter, r *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s, err := ws.lookup(r.URL.Query().Get("root"))
+		if err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+		if !requestGrant(r.Context()).allows(s.dir) {
+			writeHTTPForbidden(w, s.dir)
+			return
+		}
+		h(s, w, r)
+	}
+}
+
+func (ws *workspaceSet) handleWorkspaces(w http.ResponseWriter, r *http.Request) {
+	root := r.URL.Query().Get("root")
+	g := requestGrant(r.Context())
+	if r.Method != http.MethodGet && !mayManageWorkspaces(r) {
+		writeHTTPJSON(w, http.StatusForbidden, map[string]string{"error": "registering and unregistering workspaces requires a token or client certificate, or a loopback connection"})
+		return
+	}
+	if r.Method != http.MethodGet && root != "" && !g.allows(root) {
+		writeHTTPForbidden(w, root)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if root == "" {
+			writeHTTPError(w, fmt.Errorf("register needs a root"))
+			return
+		}
+		ws.register(root)
+	case http.MethodDelete:
+		if err := ws.unregister(root); err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	list := []serverStatus{}
+	for _, st := range ws.list() {
+		if g.allows(st.Dir) {
+			list = append(list, st)
+		}
+	}
+	writeHTTPJSON(w, http.StatusOK, map[string]interface{}{"workspaces": list})
+}
+
+func handleSymbols(s *symbolServer, w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	req := serveRequest{
+		Query:             q.Get("q"),
+		Kind:              q.Get("kind"),
+		ContinuationToken: q.Get("continuationToken"),
+	}
+	if limit := q.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			writeHTTPError(w, fmt.Errorf("invalid limit %q", limit))
+			return
+		}
+		req.Limit = n
+	}
+	env, err := s.answer(req)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	completeEnvelope(&env)
+	writeHTTPJSON(w, http.StatusOK, env)
+}
+
+// A packageInfo describes a package in the /packages response.
+type packageInfo struct {
+	ImportPath string `json:"importPath"`
+	Name       string `json:"name"`
+	Dir        string `json:"dir"`
+	Symbols    int    `json:"symbols"`
+}
+
+// packages returns the packages holding the symbols of s, by directory.
+func (s *symbolServer) packages() []*packageInfo {
+	byDir := make(map[string]*packageInfo)
+	s.mu.RLock()
+	for _, sym := range s.syms {
+		dir := filepath.Dir(sym.Path)
+		p := byDir[dir]
+		if p == nil {
+			p = &packageInfo{ImportPath: sym.ImportPath, Name: sym.Package, Dir: dir}
+			byDir[dir] = p
+		}
+		p.Symbols++
+	}
+	s.mu.RUnlock()
+	pkgs := make([]*packageInfo, 0, len(byDir))
+	for _, p := range byDir {
+		pkgs = append(pkgs, p)
+	}
+	sort.Slice(pkgs, func(i, j int) bool { return pkgs[i].Dir < pkgs[j].Dir })
+	return pkgs
+}
+
+func writeHTTPJSON(w http.ResponseWriter, code int, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(append(b, '\n'))
+}
+
+// writeHTTPError answers a bad request with the error, as the line protocol
+// does.
+func writeHTTPError(w http.ResponseWriter, err error) {
+	writeHTTPJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+}
+
+// writeHTTPForbidden answers a request for a workspace its token does not
+// grant.
+func writeHTTPForbidden(w http.ResponseWriter, dir string) {
+	writeHTTPJSON(w, http.StatusForbidden, map[string]string{"error": "the token does not grant access to " + dir})
+}
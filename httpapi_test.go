@@ -0,0 +1,73 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+)
+
+// newTestWorkspace returns a directory holding a package declaring F, with
+// the cache directory moved out of the way.
+func newTestWorkspace(t *testing.T) string {
+	t.Helper()
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.go"), []byte("package a\n\nfunc F() {}\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestManageWorkspacesFailsClosed(t *testing.T) {
+	dir := newTestWorkspace(t)
+	deny := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusUnauthorized) }
+	tokens := &authorizer{tokens: []authToken{{token: "secret"}}}
+
+	tests := []struct {
+		name   string
+		auth   *authorizer
+		method string
+		remote string
+		token  string
+		want   int
+	}{
+		{"remote post", nil, http.MethodPost, "192.0.2.1:1234", "", http.StatusForbidden},
+		{"remote delete", nil, http.MethodDelete, "192.0.2.1:1234", "", http.StatusForbidden},
+		{"remote get", nil, http.MethodGet, "192.0.2.1:1234", "", http.StatusOK},
+		{"loopback post", nil, http.MethodPost, "127.0.0.1:1234", "", http.StatusOK},
+		{"ipv6 loopback post", nil, http.MethodPost, "[::1]:1234", "", http.StatusOK},
+		{"remote post without token", tokens, http.MethodPost, "192.0.2.1:1234", "", http.StatusUnauthorized},
+		{"remote post with token", tokens, http.MethodPost, "192.0.2.1:1234", "secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ws := newWorkspaceSet()
+			defer func() {
+				for _, st := range ws.list() {
+					ws.unregister(st.Dir)
+				}
+			}()
+			var h http.Handler = ws.handler()
+			if tt.auth != nil {
+				h = tt.auth.requireAuth(h, deny)
+			}
+			r := httptest.NewRequest(tt.method, "/workspaces?root="+url.QueryEscape(dir), nil)
+			r.RemoteAddr = tt.remote
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			registered := len(ws.list()) == 1
+			if want := tt.method == http.MethodPost && tt.want == http.StatusOK; registered != want {
+				t.Errorf("registered = %v, want %v", registered, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// writeImenu writes syms as an Emacs Lisp alist mapping each file to an
+// imenu index for it:
+//
+//	(("/path/foo.go"
+//	  ("Types" ("Server" . 229))
+//	  ("Functions" ("NewServer" . 377))
+//	  ("Methods" ("Server.Close" . 330))))
+//
+// Positions are 1-based character positions, as used by Emacs buffers.
+func writeImenu(w io.Writer, syms []symbol) error {
+	byPath := make(map[string][]symbol)
+	var paths []string
+	for _, s := range syms {
+		if _, ok := byPath[s.Path]; !ok {
+			paths = append(paths, s.Path)
+		}
+		byPath[s.Path] = append(byPath[s.Path], s)
+	}
+	sort.Strings(paths)
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString("(")
+	for i, path := range paths {
+		fsyms := byPath[path]
+		sort.SliceStable(fsyms, func(i, j int) bool { return fsyms[i].Offset < fsyms[j].Offset })
+		src, _ := ioutil.ReadFile(sourcePath(path))
+
+		if i > 0 {
+			bw.WriteString("\n ")
+		}
+		fmt.Fprintf(bw, "(%s", elispString(path))
+		for _, group := range []struct {
+			title string
+			match func(symbol) bool
+		}{
+			{"Types", func(s symbol) bool { return s.Kind == "type" }},
+			{"Functions", func(s symbol) bool { return s.Kind == "func" && s.Receiver == "" }},
+			{"Methods", func(s symbol) bool { return s.Kind == "func" && s.Receiver != "" }},
+		} {
+			var entries []string
+			for _, s := range fsyms {
+				if !group.match(s) {
+					continue
+				}
+				name := s.Name
+				if s.Receiver != "" {
+					name = receiverType(s.Receiver) + "." + name
+				}
+				entries = append(entries, fmt.Sprintf("(%s . %d)", elispString(name), bufferPos(src, s.Offset)))
+			}
+			if len(entries) > 0 {
+				fmt.Fprintf(bw, "\n  (%s %s)", elispString(group.title), strings.Join(entries, " "))
+			}
+		}
+		bw.WriteString(")")
+	}
+	bw.WriteString(")\n")
+	return bw.Flush()
+}
+
+// bufferPos converts a byte offset in src to an Emacs buffer position.
+// If src could not be read the offset is assumed to be all ASCII.
+func bufferPos(src []byte, offset int) int {
+	if offset > len(src) {
+		return offset + 1
+	}
+	return utf8.RuneCount(src[:offset]) + 1
+}
+
+// elispString quotes s as an Emacs Lisp string literal.
+func elispString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,573 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"go/build"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/newhook/go-symbols/symbols"
+)
+
+var cacheDirFlag = flag.String("cache-dir", "", "`dir` holding persistent indexes (default: the user cache directory)")
+
+// indexVersion is incremented whenever the index format changes, making
+// existing indexes unreadable.
+const indexVersion = 15
+
+// A symbolIndex holds all symbols of a workspace, as built by the index
+// command, by file.
+type symbolIndex struct {
+	Version int
+	Root    string
+	Tags    []string
+	Created time.Time
+	Errors  []scanError
+	Files   []indexedFile
+
+	Implements bool // whether types carry their implements relations
+	Fields     bool // whether it holds the fields of struct types
+
+	// PackageDocs tells whether it holds package symbols, which Summaries
+	// lists by their position in the list of all symbols, as queries
+	// match them by the words of their summaries.
+	PackageDocs bool
+	Summaries   []int
+
+	Packages []indexedPackage
+	Trigrams trigramIndex // of the symbols of all files, in order
+
+	table *trigramTable // replacing Trigrams in an index read from disk
+	unmap func()        // releasing the file mapping it was read from
+}
+
+// An indexedPackage is the run of files of an index in one directory,
+// with a Bloom filter telling which queries none of their symbols match.
+type indexedPackage struct {
+	First, Count int // files
+	Bloom        bloomFilter
+}
+
+// indexedFile records the symbols of a file and what identifies its
+// contents, so that unchanged files need not be parsed again.
+type indexedFile struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+	Hash    [sha256.Size]byte
+	Entries []indexEntry // if read from disk, decoded on first use by entries
+
+	count   int           // number of entries
+	raw     *indexDecoder // of the entries, until they are decoded
+	decode  *sync.Once    // decoding raw, as files are read concurrently
+	sum     uint32        // the checksum of the file as encoded
+	corrupt bool          // whether the entries did not match sum
+}
+
+// entries returns the entries of f, decoding them if needed. A corrupted
+// file has none.
+func (f *indexedFile) entries() []indexEntry {
+	if f.decode != nil {
+		f.decode.Do(func() {
+			f.Entries = f.raw.entries(f.Path, f.count)
+			if f.raw.err != nil || len(f.raw.b) > 0 || f.raw.crc != f.sum {
+				f.Entries, f.corrupt = nil, true
+			}
+			f.raw = nil
+		})
+	}
+	return f.Entries
+}
+
+// len returns the number of entries of f, as encoded if it was read from
+// disk, even if it was corrupted, so that the positions of the symbols of
+// the files after it still match the trigram index.
+func (f *indexedFile) len() int {
+	if f.decode != nil {
+		return f.count
+	}
+	return len(f.Entries)
+}
+
+// indexEntry is a symbol with the fields the JSON output omits.
+type indexEntry struct {
+	Symbol     symbol
+	ImportPath string
+	TypeKind   string
+	Offset     int
+	Embeds     []string
+	Doc        string
+}
+
+func newIndexEntry(s symbol) indexEntry {
+	return indexEntry{
+		Symbol:     s,
+		ImportPath: s.ImportPath,
+		TypeKind:   s.TypeKind,
+		Offset:     s.Offset,
+		Embeds:     s.Embeds,
+		Doc:        s.Doc,
+	}
+}
+
+func (e *indexEntry) symbol() symbol {
+	s := e.Symbol
+	s.ImportPath = e.ImportPath
+	s.TypeKind = e.TypeKind
+	s.Offset = e.Offset
+	s.Embeds = e.Embeds
+	s.Doc = e.Doc
+	return s
+}
+
+// cacheDir returns the directory holding the indexes.
+func cacheDir() (string, error) {
+	if *cacheDirFlag != "" {
+		return *cacheDirFlag, nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "gosymbols"), nil
+}
+
+// indexFile returns the path of the index of dir, which depends on the
+// build and release tags as these select the files that are scanned.
+func indexFile(dir string) (string, error) {
+	return cacheFile("index", dir)
+}
+
+// cacheFile returns the path of the file of the given kind caching the
+// symbols of dir, for the build tags, the release tags of the Go version,
+// -ignore-line-directives and any further key strings.
+func cacheFile(kind, dir string, key ...string) (string, error) {
+	cache, err := cacheDir()
+	if err != nil {
+		return "", err
+	}
+	var roots []string
+	for _, root := range filepath.SplitList(dir) {
+		roots = append(roots, symbols.CanonicalDir(root))
+	}
+	key = append([]string{
+		strings.Join(roots, string(filepath.ListSeparator)),
+		strings.Join(build.Default.BuildTags, ","),
+		strings.Join(build.Default.ReleaseTags, ","),
+	}, key...)
+	if *ignoreLinesFlag {
+		// Positions differ, so the symbols are cached apart.
+		key = append(key, "ignore-line-directives")
+	}
+	sum := sha256.Sum256([]byte(strings.Join(key, "\x00")))
+	return filepath.Join(cache, kind, hex.EncodeToString(sum[:8])+".idx"), nil
+}
+
+// runIndex implements the index command, which scans dir and stores all
+// of its symbols in a persistent index used by the search command. If
+// there already is an index, only the files that changed since it was
+// built are parsed.
+func runIndex(args []string) error {
+	dir, _ := parseArgs(args)
+	path, err := indexFile(dir)
+	if err != nil {
+		return err
+	}
+
+	if *verifyFlag {
+		return verifyIndex(path)
+	}
+
+	var old map[string]*cachedFile
+	if prev, err := loadIndex(dir); err == nil {
+		if prev.Fields == *fieldsFlag && prev.PackageDocs == *packageDocsFlag {
+			old = prev.cachedFiles()
+		}
+		prev.close()
+	}
+	cache := newFileSymbolCache(old)
+
+	start := time.Now()
+	idx, sum, count := buildIndex(context.Background(), dir, cache)
+	notePartial(context.Background(), sum)
+	if *statsFlag {
+		fmt.Fprintf(os.Stderr, "files: %d parsed, %d unchanged; symbols: %d; errors: %d; elapsed: %.1fms\n",
+			cache.parsed, cache.reused, count, len(sum.errors), ms(time.Since(start)))
+	}
+	return saveIndex(path, idx)
+}
+
+// buildIndex scans dir into an index with cache, as the index command
+// does, and returns it with the summary of the scan and the number of
+// symbols it holds.
+func buildIndex(ctx context.Context, dir string, cache *fileSymbolCache) (*symbolIndex, *scanSummary, int) {
+	parseDocs = true
+	sum := scanCached(ctx, dir, "", cache, func([]symbol) {})
+
+	idx := &symbolIndex{
+		Version: indexVersion,
+		Root:    dir,
+		Tags:    build.Default.BuildTags,
+		Created: time.Now(),
+		Errors:  sum.errors,
+		Fields:  *fieldsFlag,
+
+		PackageDocs: *packageDocsFlag,
+	}
+	if *withImplementsFlag {
+		idx.Implements = true
+		if errs := setImplements(ctx, dir, cache.seen); len(errs) > 0 {
+			idx.Errors = append(idx.Errors, errs...)
+			partial = true
+		}
+	} else {
+		for _, f := range cache.seen {
+			for i := range f.syms {
+				f.syms[i].Implements, f.syms[i].ImplementedBy = nil, nil
+			}
+		}
+	}
+	count := idx.setFiles(cache.seen)
+	return idx, sum, count
+}
+
+// cachedFiles returns the files of idx for a fileSymbolCache, leaving out
+// those that are corrupted so that they are parsed again.
+func (idx *symbolIndex) cachedFiles() map[string]*cachedFile {
+	files := make(map[string]*cachedFile, len(idx.Files))
+	for i := range idx.Files {
+		f := &idx.Files[i]
+		entries := f.entries()
+		if f.corrupt {
+			continue
+		}
+		cf := &cachedFile{size: f.Size, modTime: f.ModTime, hash: f.Hash}
+		for _, e := range entries {
+			cf.syms = append(cf.syms, e.symbol())
+		}
+		files[f.Path] = cf
+	}
+	return files
+}
+
+// setFiles sets the files of idx from those of a fileSymbolCache and
+// returns the number of symbols in them.
+func (idx *symbolIndex) setFiles(files map[string]*cachedFile) int {
+	idx.Files, idx.Packages, idx.Summaries = nil, nil, nil
+	var count int
+	var names []string
+	for i, filename := range sortedFiles(files) {
+		if i == 0 || filepath.Dir(filename) != filepath.Dir(idx.Files[i-1].Path) {
+			if len(idx.Packages) > 0 {
+				idx.Packages[len(idx.Packages)-1].Bloom = newBloomFilter(names)
+			}
+			idx.Packages = append(idx.Packages, indexedPackage{First: i})
+			names = names[:0]
+		}
+		idx.Packages[len(idx.Packages)-1].Count++
+
+		cf := files[filename]
+		f := indexedFile{Path: filename, Size: cf.size, ModTime: cf.modTime, Hash: cf.hash}
+		for j, s := range cf.syms {
+			f.Entries = append(f.Entries, newIndexEntry(s))
+			names = append(names, s.Name)
+			if s.Kind == "package" {
+				idx.Summaries = append(idx.Summaries, count+j)
+			}
+		}
+		count += len(cf.syms)
+		idx.Files = append(idx.Files, f)
+	}
+	if len(idx.Packages) > 0 {
+		idx.Packages[len(idx.Packages)-1].Bloom = newBloomFilter(names)
+	}
+	starts := idx.fileStarts()
+	idx.Trigrams = newTrigramIndex(count, func(i int) string {
+		return idx.entry(starts, i).Symbol.Name
+	})
+	return count
+}
+
+// fileStarts returns the position of the first symbol of each file in the
+// list of all symbols of idx.
+func (idx *symbolIndex) fileStarts() []int {
+	starts := make([]int, len(idx.Files))
+	n := 0
+	for i := range idx.Files {
+		starts[i] = n
+		n += idx.Files[i].len()
+	}
+	return starts
+}
+
+// entry returns the symbol at position i in the list of all symbols, or
+// nil if its file cannot be decoded.
+func (idx *symbolIndex) entry(starts []int, i int) *indexEntry {
+	f := sort.Search(len(starts), func(j int) bool { return starts[j] > i }) - 1
+	if entries := idx.Files[f].entries(); i-starts[f] < len(entries) {
+		return &entries[i-starts[f]]
+	}
+	return nil
+}
+
+// matching returns the symbols of idx matching query and the filters,
+// looking only at those holding the query's trigrams if it has any, and
+// otherwise only at the packages whose Bloom filter admits the query.
+func (idx *symbolIndex) matching(query string) []symbol {
+	filter := currentFilter()
+	syms := idx.matchingNames(query, filter)
+
+	// The trigrams and Bloom filters cover only names, so the package
+	// symbols are matched by their summaries apart.
+	if len(idx.Summaries) > 0 {
+		starts := idx.fileStarts()
+		for _, i := range idx.Summaries {
+			e := idx.entry(starts, i)
+			if e == nil || strings.Contains(strings.ToLower(e.Symbol.Name), query) {
+				continue // found by name, if at all
+			}
+			if s := e.symbol(); filter.match(s, query) {
+				syms = append(syms, s)
+			}
+		}
+	}
+	return syms
+}
+
+// matchingNames returns the symbols of idx whose names match query and
+// filter.
+func (idx *symbolIndex) matchingNames(query string, filter symbolFilter) []symbol {
+	var syms []symbol
+	candidates, ok := idx.Trigrams.candidates(query)
+	if idx.table != nil {
+		candidates, ok = idx.table.candidates(query)
+	}
+	if !ok {
+		for _, p := range idx.Packages {
+			if !p.Bloom.mayContain(query) {
+				continue
+			}
+			for i := p.First; i < p.First+p.Count; i++ {
+				for _, e := range idx.Files[i].entries() {
+					if s := e.symbol(); filter.match(s, query) {
+						syms = append(syms, s)
+					}
+				}
+			}
+		}
+		return syms
+	}
+	starts := idx.fileStarts()
+	for _, c := range candidates {
+		if e := idx.entry(starts, int(c)); e != nil {
+			if s := e.symbol(); filter.match(s, query) {
+				syms = append(syms, s)
+			}
+		}
+	}
+	return syms
+}
+
+// sortedFiles returns the file names in m ordered by directory and then
+// name, keeping the files of each directory together.
+func sortedFiles(m map[string]*cachedFile) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		di, dj := filepath.Dir(names[i]), filepath.Dir(names[j])
+		if di != dj {
+			return di < dj
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+func saveIndex(path string, idx *symbolIndex) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return writeFileIfChanged(path, encodeIndex(idx))
+}
+
+// loadIndex reads the index of dir. The error satisfies os.IsNotExist if
+// there is none.
+func loadIndex(dir string) (*symbolIndex, error) {
+	path, err := indexFile(dir)
+	if err != nil {
+		return nil, err
+	}
+	return readIndex(path)
+}
+
+// readIndex reads the index file at path. The file is mapped into memory
+// rather than read where possible, so only the parts of it used are read,
+// and the pages are shared by the processes reading it. The index must be
+// closed once done with.
+func readIndex(path string) (*symbolIndex, error) {
+	data, unmap, err := mapFile(path)
+	if err != nil {
+		return nil, err
+	}
+	idx, version, err := decodeIndex(data)
+	if err != nil {
+		unmap()
+		return nil, fmt.Errorf("reading index %s: %v", path, err)
+	}
+	if idx == nil {
+		unmap()
+		return nil, fmt.Errorf("index %s has version %d, want %d; rebuild it with the index command", path, version, indexVersion)
+	}
+	idx.unmap = unmap
+	return idx, nil
+}
+
+// close releases the file idx was read from, if any. What was decoded
+// from idx, such as its symbols and cached files, remains usable, but idx
+// itself does not.
+func (idx *symbolIndex) close() {
+	if idx.unmap != nil {
+		idx.unmap()
+		idx.unmap = nil
+	}
+}
+
+// A symbolFilter holds the -pkg-name and -only-prefix filters, read once
+// per query as configuration changes may set them meanwhile.
+type symbolFilter struct {
+	pkgName    string
+	onlyPrefix []string
+}
+
+// currentFilter returns the filters set now.
+func currentFilter() symbolFilter {
+	flagsMu.RLock()
+	defer flagsMu.RUnlock()
+	return symbolFilter{pkgName: *pkgNameFlag, onlyPrefix: onlyPrefix}
+}
+
+// match reports whether a symbol read from an index matches query and the
+// filters.
+func (f symbolFilter) match(s symbol, query string) bool {
+	if !symbols.MatchQuery(&s, query) {
+		return false
+	}
+	if f.pkgName != "" && s.Package != f.pkgName {
+		return false
+	}
+	allowed, _ := symbols.PrefixAllowed(s.ImportPath, f.onlyPrefix)
+	return allowed
+}
+
+// runSearch implements the search command, which answers the query from
+// the persistent index of dir, or by scanning if there is none. With
+// -remote, it asks a server instead.
+func runSearch(args []string) error {
+	source := searchIndex
+	if *remoteFlag != "" {
+		// The server is named in place of the directory.
+		if len(args) > 1 {
+			return usagef("with -remote, search takes only a query")
+		}
+		conn, err := dialRemote(*remoteFlag)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		source = remoteSource(conn)
+		args = append([]string{*remoteFlag}, args...)
+	}
+	if *stdlibFlag {
+		goroot, err := stdlibRoot()
+		if err != nil {
+			return err
+		}
+		source = withStdlib(goroot, source)
+	}
+	return searchSource(source, args)
+}
+
+// searchIndex is a symbolSource reading the persistent index, falling back
+// to a scan if dir has not been indexed. An index that is corrupted or of
+// another version is rebuilt first, parsing again only its corrupted files
+// where the rest can be read.
+func searchIndex(ctx context.Context, dir, query string, found func([]symbol)) *scanSummary {
+	start := time.Now()
+	idx, err := loadIndex(dir)
+	if err != nil && !os.IsNotExist(err) {
+		logger.Warn("rebuilding the index", "dir", dir, "err", err)
+		idx, err = repairIndex(ctx, dir, nil)
+	}
+	if os.IsNotExist(err) || err == nil && (*fieldsFlag && !idx.Fields || *packageDocsFlag && !idx.PackageDocs) {
+		// Unindexed, or indexed without the fields or packages asked for.
+		if idx != nil {
+			idx.close()
+		}
+		if *withImplementsFlag {
+			return typeChecked(scan, symbols.Implements)(ctx, dir, query, found)
+		}
+		return scan(ctx, dir, query, found)
+	}
+	sum := new(scanSummary)
+	if err != nil {
+		sum.errors = []scanError{{Dir: dir, Phase: "index", Message: err.Error()}}
+		sum.stats.Errors = 1
+		return sum
+	}
+
+	syms := idx.matching(query)
+	if bad := idx.corrupted(); len(bad) > 0 {
+		logger.Warn("rebuilding the corrupted files of the index", "dir", dir, "files", len(bad))
+		corrupted := idx
+		idx, err = repairIndex(ctx, dir, corrupted)
+		corrupted.close()
+		if err != nil {
+			sum.errors = []scanError{{Dir: dir, Phase: "index", Message: err.Error()}}
+			sum.stats.Errors = 1
+			return sum
+		}
+		syms = idx.matching(query)
+	}
+	idx.close()
+	if idx.Fields && !*fieldsFlag || idx.PackageDocs && !*packageDocsFlag {
+		kept := syms[:0]
+		for _, s := range syms {
+			if s.Kind == "field" && !*fieldsFlag || s.Kind == "package" && !*packageDocsFlag {
+				continue
+			}
+			kept = append(kept, s)
+		}
+		syms = kept
+	}
+	pkgs := make(map[string]bool)
+	for i, s := range syms {
+		pkgs[s.ImportPath] = true
+		if !*withImplementsFlag {
+			syms[i].Implements, syms[i].ImplementedBy = nil, nil
+		}
+	}
+	symbols.SetImportable(dir, syms)
+	sum.errors = idx.Errors
+	if *withImplementsFlag && !idx.Implements && len(syms) > 0 {
+		// Indexed without them, so they are found now.
+		errs := symbols.Implements(ctx, typeCheckOptions(dir), syms)
+		sum.errors = append(sum.errors[:len(sum.errors):len(sum.errors)], errs...)
+	}
+	found(syms)
+	sum.stats.Files = len(idx.Files)
+
+	sum.stats.Packages = len(pkgs)
+	sum.stats.Errors = len(sum.errors)
+	sum.stats.Walk = time.Since(start)
+	return sum
+}
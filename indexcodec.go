@@ -0,0 +1,516 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The index is stored as the magic string and version, followed by a table
+// of all distinct strings and then the index itself, in which strings are
+// varint references into the table and numbers are varints. Symbols take
+// their path from the file holding them. The trigram index follows as a
+// table of fixed-size records, each a trigram and the offset of its posting
+// list, which can be searched without decoding it. The positions in each
+// posting list are stored as differences.
+//
+// Each file is followed by a CRC-32C checksum of its path, size, time, hash
+// and entries, and the index ends with a checksum of the rest, strings
+// being checksummed where they are used rather than in the table. Files are
+// checked as their entries are decoded, so that a corrupted file is parsed
+// again alone, and the rest when the index is read.
+//
+// Decoding reads the file metadata but not the strings, symbols and posting
+// lists, which are only decoded as they are used.
+const indexMagic = "gosymbols index\n"
+
+var (
+	errBadIndex    = errors.New("malformed index")
+	errBadChecksum = errors.New("corrupted index: checksum mismatch")
+)
+
+var indexCRC = crc32.MakeTable(crc32.Castagnoli)
+
+// crcString adds s, preceded by its length, to the checksum crc.
+func crcString(crc uint32, s string) uint32 {
+	crc = crc32.Update(crc, indexCRC, binary.AppendUvarint(nil, uint64(len(s))))
+	return crc32.Update(crc, indexCRC, []byte(s))
+}
+
+// encodeIndex returns the binary encoding of idx.
+func encodeIndex(idx *symbolIndex) []byte {
+	e := &indexEncoder{
+		strs: []string{""}, // the most common
+		ids:  map[string]uint64{"": 0},
+	}
+
+	e.str(idx.Root)
+	e.varint(idx.Created.UnixNano())
+	e.uvarint(uint64(len(idx.Tags)))
+	for _, t := range idx.Tags {
+		e.str(t)
+	}
+	if idx.Implements {
+		e.uvarint(1)
+	} else {
+		e.uvarint(0)
+	}
+	if idx.Fields {
+		e.uvarint(1)
+	} else {
+		e.uvarint(0)
+	}
+	if idx.PackageDocs {
+		e.uvarint(1)
+	} else {
+		e.uvarint(0)
+	}
+	e.uvarint(uint64(len(idx.Summaries)))
+	for _, i := range idx.Summaries {
+		e.uvarint(uint64(i))
+	}
+	e.uvarint(uint64(len(idx.Errors)))
+	for _, se := range idx.Errors {
+		e.str(se.ImportPath)
+		e.str(se.Dir)
+		e.str(se.Phase)
+		e.str(se.Message)
+	}
+	e.uvarint(uint64(len(idx.Files)))
+	for i := range idx.Files {
+		f := &idx.Files[i]
+		meta := e.crc
+		e.crc = 0
+		e.str(f.Path)
+		e.uvarint(uint64(f.Size))
+		e.varint(f.ModTime.UnixNano())
+		e.raw(f.Hash[:])
+
+		// The entries are preceded by their length in bytes, so that
+		// they can be decoded on demand.
+		entries := f.entries()
+		body := e.body
+		e.body = nil
+		for j := range entries {
+			ent := &entries[j]
+			s := &ent.Symbol
+			e.str(s.Name)
+			e.str(s.Kind)
+			e.str(s.Package)
+			e.uvarint(uint64(s.Line))
+			e.uvarint(uint64(s.Character))
+			e.str(s.Receiver)
+			e.str(s.Signature)
+			e.str(s.Summary)
+			e.str(s.Source)
+			e.str(s.Module)
+			e.str(s.Build)
+			e.str(ent.ImportPath)
+			if s.Vendored {
+				e.uvarint(1)
+			} else {
+				e.uvarint(0)
+			}
+			e.str(ent.TypeKind)
+			e.uvarint(uint64(ent.Offset))
+			e.uvarint(uint64(len(ent.Embeds)))
+			for _, emb := range ent.Embeds {
+				e.str(emb)
+			}
+			e.str(ent.Doc)
+			e.uvarint(uint64(len(s.Implements)))
+			for _, name := range s.Implements {
+				e.str(name)
+			}
+			e.uvarint(uint64(len(s.ImplementedBy)))
+			for _, name := range s.ImplementedBy {
+				e.str(name)
+			}
+			e.uvarint(uint64(len(s.Tags)))
+			for _, key := range sortedTagKeys(s.Tags) {
+				e.str(key)
+				e.str(s.Tags[key])
+			}
+			// Only the symbols a //line directive maps to another
+			// file record their path.
+			if s.Path != f.Path {
+				e.str(s.Path)
+			} else {
+				e.str("")
+			}
+		}
+		sum, encoded := e.crc, e.body
+		e.crc, e.body = meta, binary.LittleEndian.AppendUint32(body, sum)
+		e.uvarint(uint64(len(entries)))
+		e.uvarint(uint64(len(encoded)))
+		e.body = append(e.body, encoded...) // checksummed with the file
+	}
+	e.uvarint(uint64(len(idx.Packages)))
+	for _, p := range idx.Packages {
+		e.uvarint(uint64(p.First))
+		e.uvarint(uint64(p.Count))
+		e.uvarint(uint64(len(p.Bloom)))
+		e.raw(p.Bloom)
+	}
+	grams := make([]uint32, 0, len(idx.Trigrams))
+	for g := range idx.Trigrams {
+		grams = append(grams, g)
+	}
+	sort.Slice(grams, func(i, j int) bool { return grams[i] < grams[j] })
+	var table, postings []byte
+	for _, g := range grams {
+		table = binary.LittleEndian.AppendUint32(table, g)
+		table = binary.LittleEndian.AppendUint32(table, uint32(len(postings)))
+		var last uint32
+		for _, p := range idx.Trigrams[g] {
+			postings = binary.AppendUvarint(postings, uint64(p-last)) // increasing, so small
+			last = p
+		}
+	}
+	e.uvarint(uint64(len(grams)))
+	e.uvarint(uint64(len(postings)))
+	e.raw(table)
+	e.raw(postings)
+
+	b := binary.AppendUvarint([]byte(indexMagic), indexVersion)
+	b = binary.AppendUvarint(b, uint64(len(e.strs)))
+	for _, s := range e.strs {
+		b = binary.AppendUvarint(b, uint64(len(s)))
+		b = append(b, s...)
+	}
+	b = append(b, e.body...)
+	return binary.LittleEndian.AppendUint32(b, e.crc)
+}
+
+// An indexEncoder appends to body, adding what it appends to the checksum
+// crc.
+type indexEncoder struct {
+	strs []string
+	ids  map[string]uint64
+	body []byte
+	crc  uint32
+}
+
+func (e *indexEncoder) str(s string) {
+	id, ok := e.ids[s]
+	if !ok {
+		id = uint64(len(e.strs))
+		e.ids[s] = id
+		e.strs = append(e.strs, s)
+	}
+	e.uvarint(id)
+	e.crc = crcString(e.crc, s)
+}
+
+func (e *indexEncoder) uvarint(x uint64) { e.raw(binary.AppendUvarint(nil, x)) }
+func (e *indexEncoder) varint(x int64)   { e.raw(binary.AppendVarint(nil, x)) }
+
+func (e *indexEncoder) raw(b []byte) {
+	e.body = append(e.body, b...)
+	e.crc = crc32.Update(e.crc, indexCRC, b)
+}
+
+// decodeIndex decodes an index encoded by encodeIndex. It returns the
+// version of an index of another version, with a nil index, and
+// errBadChecksum if the index is corrupted other than in its files.
+func decodeIndex(b []byte) (*symbolIndex, int, error) {
+	if len(b) < len(indexMagic) || string(b[:len(indexMagic)]) != indexMagic {
+		return nil, 0, errBadIndex
+	}
+	d := &indexDecoder{b: b[len(indexMagic):]}
+	version := int(d.uvarint())
+	if d.err != nil {
+		return nil, 0, d.err
+	}
+	if version != indexVersion {
+		return nil, version, nil
+	}
+	n := d.count()
+	d.strs = &stringTable{data: d.b, offs: make([]uint32, n), strs: make([]string, n), done: make([]atomic.Bool, n)}
+	start := len(d.b)
+	for i := 0; i < n && d.err == nil; i++ {
+		d.strs.offs[i] = uint32(start - len(d.b))
+		d.bytes(d.count())
+	}
+	d.crc = 0 // the strings are checked where they are used
+
+	idx := &symbolIndex{Version: version}
+	idx.Root = d.str()
+	idx.Created = time.Unix(0, d.varint())
+	if n := d.count(); n > 0 {
+		idx.Tags = make([]string, n)
+		for i := range idx.Tags {
+			idx.Tags[i] = d.str()
+		}
+	}
+	idx.Implements = d.uvarint() == 1
+	idx.Fields = d.uvarint() == 1
+	idx.PackageDocs = d.uvarint() == 1
+	if n := d.count(); n > 0 {
+		idx.Summaries = make([]int, n)
+		for i := range idx.Summaries {
+			idx.Summaries[i] = int(d.uvarint())
+		}
+	}
+	if n := d.count(); n > 0 {
+		idx.Errors = make([]scanError, n)
+		for i := range idx.Errors {
+			idx.Errors[i] = scanError{ImportPath: d.str(), Dir: d.str(), Phase: d.str(), Message: d.str()}
+		}
+	}
+	idx.Files = make([]indexedFile, d.count())
+	total := 0
+	for i := range idx.Files {
+		f := &idx.Files[i]
+		meta := d.crc
+		d.crc = 0
+		f.Path = d.str()
+		f.Size = int64(d.uvarint())
+		f.ModTime = time.Unix(0, d.varint())
+		copy(f.Hash[:], d.bytes(len(f.Hash)))
+		crc := d.crc
+		d.crc = meta
+		sum := d.skip(4)
+		f.count = d.count()
+		total += f.count
+		f.raw = &indexDecoder{b: d.skip(d.count()), strs: d.strs, crc: crc}
+		f.decode = new(sync.Once)
+		if d.err != nil {
+			return nil, version, d.err
+		}
+		f.sum = binary.LittleEndian.Uint32(sum)
+	}
+	for _, i := range idx.Summaries {
+		if i < 0 || i >= total {
+			d.fail()
+		}
+	}
+	idx.Packages = make([]indexedPackage, d.count())
+	for i := range idx.Packages {
+		p := &idx.Packages[i]
+		first, n := d.uvarint(), d.uvarint()
+		if first > uint64(len(idx.Files)) || n > uint64(len(idx.Files))-first {
+			d.fail()
+		}
+		p.First, p.Count = int(first), int(n)
+		p.Bloom = bloomFilter(d.bytes(d.count()))
+	}
+	grams := d.count()
+	size := d.count()
+	idx.table = &trigramTable{table: d.bytes(grams * 8), postings: d.bytes(size)}
+	crc := d.crc
+	sum := d.skip(4)
+	if d.err != nil || len(d.b) > 0 {
+		return nil, version, errBadIndex
+	}
+	if binary.LittleEndian.Uint32(sum) != crc {
+		return nil, version, errBadChecksum
+	}
+	return idx, version, nil
+}
+
+// entries decodes n entries of the file at path, or none if they are
+// malformed.
+func (d *indexDecoder) entries(path string, n int) []indexEntry {
+	entries := make([]indexEntry, n)
+	for j := range entries {
+		ent := &entries[j]
+		ent.Symbol = symbol{
+			Name:      d.str(),
+			Kind:      d.str(),
+			Package:   d.str(),
+			Path:      path,
+			Line:      int(d.uvarint()),
+			Character: int(d.uvarint()),
+			Receiver:  d.str(),
+			Signature: d.str(),
+			Summary:   d.str(),
+			Source:    d.str(),
+			Module:    d.str(),
+			Build:     d.str(),
+		}
+		ent.ImportPath = d.str()
+		ent.Symbol.Vendored = d.uvarint() == 1
+		ent.TypeKind = d.str()
+		ent.Offset = int(d.uvarint())
+		if n := d.count(); n > 0 {
+			ent.Embeds = make([]string, n)
+			for k := range ent.Embeds {
+				ent.Embeds[k] = d.str()
+			}
+		}
+		ent.Doc = d.str()
+		if n := d.count(); n > 0 {
+			ent.Symbol.Implements = make([]string, n)
+			for k := range ent.Symbol.Implements {
+				ent.Symbol.Implements[k] = d.str()
+			}
+		}
+		if n := d.count(); n > 0 {
+			ent.Symbol.ImplementedBy = make([]string, n)
+			for k := range ent.Symbol.ImplementedBy {
+				ent.Symbol.ImplementedBy[k] = d.str()
+			}
+		}
+		if n := d.count(); n > 0 {
+			ent.Symbol.Tags = make(map[string]string, n)
+			for k := 0; k < n; k++ {
+				key := d.str()
+				ent.Symbol.Tags[key] = d.str()
+			}
+		}
+		if p := d.str(); p != "" {
+			ent.Symbol.Path = p
+		}
+	}
+	if d.err != nil {
+		return nil
+	}
+	return entries
+}
+
+// An indexDecoder reads from b, recording the first error, and adds what
+// it reads to the checksum crc.
+type indexDecoder struct {
+	b    []byte
+	strs *stringTable
+	err  error
+	crc  uint32
+}
+
+// A stringTable holds the strings of an encoded index, decoding each the
+// first time it is used. It may be used concurrently, as the files of an
+// index are decoded by the goroutines reading them.
+type stringTable struct {
+	data []byte   // the encoded table
+	offs []uint32 // of each string in data
+	strs []string // decoded once done is set
+
+	mu   sync.Mutex    // held while decoding
+	done []atomic.Bool // whether each string is decoded
+}
+
+func (t *stringTable) get(id uint64) string {
+	if id == 0 || t.done[id].Load() {
+		return t.strs[id]
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.done[id].Load() {
+		b := t.data[t.offs[id]:]
+		n, k := binary.Uvarint(b)
+		t.strs[id] = string(b[k : k+int(n)]) // checked when the table was read
+		t.done[id].Store(true)
+	}
+	return t.strs[id]
+}
+
+// A trigramTable is the trigram index of an encoded index, searched
+// without decoding it.
+type trigramTable struct {
+	table    []byte // of trigrams and posting list offsets, in order
+	postings []byte
+}
+
+func (t *trigramTable) candidates(query string) ([]uint32, bool) {
+	return trigramCandidates(query, t.postingList)
+}
+
+// postingList decodes the positions holding trigram g, or none if they
+// are malformed.
+func (t *trigramTable) postingList(g uint32) []uint32 {
+	n := len(t.table) / 8
+	i := sort.Search(n, func(i int) bool { return binary.LittleEndian.Uint32(t.table[i*8:]) >= g })
+	if i == n || binary.LittleEndian.Uint32(t.table[i*8:]) != g {
+		return nil
+	}
+	start, end := binary.LittleEndian.Uint32(t.table[i*8+4:]), uint32(len(t.postings))
+	if i+1 < n {
+		end = binary.LittleEndian.Uint32(t.table[i*8+12:])
+	}
+	if start > end || end > uint32(len(t.postings)) {
+		return nil
+	}
+	d := &indexDecoder{b: t.postings[start:end]}
+	var list []uint32
+	var last uint64
+	for len(d.b) > 0 && d.err == nil {
+		last += d.uvarint()
+		list = append(list, uint32(last))
+	}
+	if d.err != nil {
+		return nil
+	}
+	return list
+}
+
+func (d *indexDecoder) uvarint() uint64 {
+	x, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.crc = crc32.Update(d.crc, indexCRC, d.b[:n])
+	d.b = d.b[n:]
+	return x
+}
+
+func (d *indexDecoder) varint() int64 {
+	x, n := binary.Varint(d.b)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.crc = crc32.Update(d.crc, indexCRC, d.b[:n])
+	d.b = d.b[n:]
+	return x
+}
+
+// count reads a length, which cannot exceed the remaining bytes.
+func (d *indexDecoder) count() int {
+	n := d.uvarint()
+	if n > uint64(len(d.b)) {
+		d.fail()
+		return 0
+	}
+	return int(n)
+}
+
+func (d *indexDecoder) bytes(n int) []byte {
+	b := d.skip(n)
+	d.crc = crc32.Update(d.crc, indexCRC, b)
+	return b
+}
+
+// skip returns the next n bytes without adding them to the checksum.
+func (d *indexDecoder) skip(n int) []byte {
+	if n > len(d.b) {
+		d.fail()
+		return nil
+	}
+	b := d.b[:n]
+	d.b = d.b[n:]
+	return b
+}
+
+func (d *indexDecoder) str() string {
+	id := d.uvarint()
+	if id >= uint64(len(d.strs.strs)) {
+		d.fail()
+		return ""
+	}
+	s := d.strs.get(id)
+	d.crc = crcString(d.crc, s)
+	return s
+}
+
+func (d *indexDecoder) fail() {
+	if d.err == nil {
+		d.err = errBadIndex
+	}
+	d.b = nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestIndex returns an index of two packages, holding symbols with most
+// fields set.
+func newTestIndex() (*symbolIndex, map[string]*cachedFile) {
+	files := map[string]*cachedFile{
+		"/w/a/a.go": {size: 10, modTime: time.Unix(1, 0), hash: [32]byte{1}, syms: []symbol{
+			{Name: "NewReader", Kind: "func", Package: "a", ImportPath: "w/a", Path: "/w/a/a.go", Line: 3, Character: 5, Signature: "func NewReader() *Reader"},
+			{Name: "Reader", Kind: "type", Package: "a", ImportPath: "w/a", Path: "/w/a/a.go", Line: 7, TypeKind: "struct", Embeds: []string{"io.Reader"}, Doc: "Reader reads.", Implements: []string{"io.Reader"}},
+			{Name: "Read", Kind: "func", Package: "a", ImportPath: "w/a", Path: "/w/a/gen.y", Line: 12, Receiver: "Reader", Offset: 140},
+		}},
+		"/w/a/b.go": {size: 20, modTime: time.Unix(2, 0), hash: [32]byte{2}, syms: []symbol{
+			{Name: "Options", Kind: "type", Package: "a", ImportPath: "w/a", Path: "/w/a/b.go", Line: 1, Module: "w@v1.0.0", Build: "linux"},
+			{Name: "Verbose", Kind: "field", Package: "a", ImportPath: "w/a", Path: "/w/a/b.go", Line: 2, Receiver: "Options", Tags: map[string]string{"json": "verbose"}},
+		}},
+		"/w/c/c.go": {size: 30, modTime: time.Unix(3, 0), hash: [32]byte{3}, syms: []symbol{
+			{Name: "Uniquely", Kind: "func", Package: "c", ImportPath: "w/c", Path: "/w/c/c.go", Vendored: true},
+		}},
+	}
+	idx := &symbolIndex{Version: indexVersion, Root: "/w", Tags: []string{"x"}, Created: time.Unix(4, 0), Fields: true}
+	idx.setFiles(files)
+	return idx, files
+}
+
+func symbolNames(syms []symbol) []string {
+	var names []string
+	for _, s := range syms {
+		names = append(names, s.Receiver+"."+s.Name+"@"+s.Path)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestIndexRoundTrip(t *testing.T) {
+	idx, files := newTestIndex()
+	decoded, version, err := decodeIndex(encodeIndex(idx))
+	if err != nil || decoded == nil {
+		t.Fatalf("decodeIndex: %v, %v (version %d)", decoded, err, version)
+	}
+	if decoded.Root != idx.Root || !reflect.DeepEqual(decoded.Tags, idx.Tags) || !decoded.Created.Equal(idx.Created) || !decoded.Fields {
+		t.Errorf("decoded header %+v, want %+v", decoded, idx)
+	}
+	if got := decoded.cachedFiles(); !reflect.DeepEqual(got, files) {
+		for name, f := range got {
+			t.Logf("%s: %+v", name, f.syms)
+		}
+		t.Errorf("decoded files differ from those encoded")
+	}
+	for _, query := range []string{"", "read", "reader", "opt", "uniquely", "nothing"} {
+		got, want := symbolNames(decoded.matching(query)), symbolNames(idx.matching(query))
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("matching(%q) = %v decoded, %v encoded", query, got, want)
+		}
+	}
+}
+
+func TestReadIndex(t *testing.T) {
+	idx, files := newTestIndex()
+	path := filepath.Join(t.TempDir(), "test.idx")
+	if err := saveIndex(path, idx); err != nil {
+		t.Fatal(err)
+	}
+	read, err := readIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := read.cachedFiles()
+	read.close()
+	if !reflect.DeepEqual(got, files) {
+		t.Errorf("files read differ from those saved")
+	}
+}
+
+func TestDecodeIndexTruncated(t *testing.T) {
+	idx, _ := newTestIndex()
+	b := encodeIndex(idx)
+	for n := 0; n < len(b); n++ {
+		if decoded, _, err := decodeIndex(b[:n]); err == nil {
+			t.Fatalf("decoding the first %d of %d bytes: got %v, want an error", n, len(b), decoded)
+		}
+	}
+}
+
+func TestDecodeIndexCorrupted(t *testing.T) {
+	idx, _ := newTestIndex()
+	b := encodeIndex(idx)
+
+	// A string of a single file is checked with that file only.
+	i := bytes.Index(b, []byte("Uniquely"))
+	b[i] ^= 0x20
+	decoded, _, err := decodeIndex(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bad := decoded.verify(); !reflect.DeepEqual(bad, []string{"/w/c/c.go"}) {
+		t.Errorf("corrupted files %v, want /w/c/c.go", bad)
+	}
+	if syms := decoded.matching("read"); len(syms) != 3 {
+		t.Errorf("matching(read) in the intact files = %v, want 3 symbols", symbolNames(syms))
+	}
+	b[i] ^= 0x20
+
+	// Whatever byte is changed, decoding fails or finds the changed
+	// files corrupted, without panicking.
+	for i := range b {
+		b[i] ^= 0x55
+		if decoded, _, err := decodeIndex(b); err == nil && decoded != nil {
+			decoded.matching("read")
+			decoded.verify()
+		}
+		b[i] ^= 0x55
+	}
+}
+
+func TestDecodedIndexConcurrentReads(t *testing.T) {
+	idx, _ := newTestIndex()
+	want := symbolNames(idx.matching("read"))
+	decoded, _, err := decodeIndex(encodeIndex(idx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got := symbolNames(decoded.matching("read")); !reflect.DeepEqual(got, want) {
+				t.Errorf("matching(read) = %v, want %v", got, want)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestIndexRoundTripLargePackage(t *testing.T) {
+	// The package's file count exceeds the bytes encoding it.
+	files := map[string]*cachedFile{
+		"/w/a/a.go": {size: 1, modTime: time.Unix(1, 0), syms: []symbol{
+			{Name: "Last", Kind: "func", Package: "a", ImportPath: "w/a", Path: "/w/a/a.go", Line: 1},
+		}},
+	}
+	for i := 0; i < 300; i++ {
+		files[fmt.Sprintf("/w/a/empty%d.go", i)] = &cachedFile{size: 1, modTime: time.Unix(1, 0)}
+	}
+	idx := &symbolIndex{Version: indexVersion, Root: "/w", Created: time.Unix(4, 0)}
+	idx.setFiles(files)
+	decoded, _, err := decodeIndex(encodeIndex(idx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bad := decoded.verify(); len(bad) > 0 {
+		t.Errorf("corrupted files %v, want none", bad)
+	}
+	if got := symbolNames(decoded.matching("last")); !reflect.DeepEqual(got, []string{".Last@/w/a/a.go"}) {
+		t.Errorf("matching(last) = %v, want Last", got)
+	}
+}
+
+func TestDecodeIndexSummaries(t *testing.T) {
+	idx, files := newTestIndex()
+	files["/w/c/doc.go"] = &cachedFile{size: 1, modTime: time.Unix(1, 0), syms: []symbol{
+		{Name: "c", Kind: "package", Package: "c", ImportPath: "w/c", Path: "/w/c/doc.go", Doc: "Package c has a summary."},
+	}}
+	idx.PackageDocs = true
+	idx.setFiles(files)
+	decoded, _, err := decodeIndex(encodeIndex(idx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.Summaries, idx.Summaries) {
+		t.Errorf("decoded summaries %v, want %v", decoded.Summaries, idx.Summaries)
+	}
+
+	// A summary past the last symbol is rejected, however few bytes follow.
+	idx.Summaries = []int{6, 1 << 40}
+	if decoded, _, err := decodeIndex(encodeIndex(idx)); err == nil {
+		t.Errorf("decoding summaries %v: got %v, want an error", idx.Summaries, decoded.Summaries)
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/newhook/go-symbols/symbols"
+)
+
+// appendSymbolJSON appends the JSON encoding of s to b, the same as
+// json.Marshal, or json.MarshalIndent with the given prefix and an indent
+// of one space unless compact, produces without reflection or allocation.
+func appendSymbolJSON(b []byte, s *symbol, prefix string, compact bool) []byte {
+	b = append(b, '{')
+	first := true
+	field := func(name string) {
+		if !first {
+			b = append(b, ',')
+		}
+		first = false
+		if !compact {
+			b = append(b, '\n')
+			b = append(b, prefix...)
+			b = append(b, ' ')
+		}
+		b = append(b, '"')
+		b = append(b, name...)
+		b = append(b, '"', ':')
+		if !compact {
+			b = append(b, ' ')
+		}
+	}
+	field("name")
+	b = appendJSONString(b, s.Name)
+	field("kind")
+	if *kindFormatFlag == "lsp" {
+		b = strconv.AppendInt(b, int64(lspSymbolKind(*s)), 10)
+	} else {
+		b = appendJSONString(b, s.Kind)
+	}
+	field("package")
+	b = appendJSONString(b, s.Package)
+	if s.ImportPath != "" {
+		field("importPath")
+		b = appendJSONString(b, s.ImportPath)
+	}
+	if s.Vendored {
+		field("vendored")
+		b = append(b, "true"...)
+	}
+	field("path")
+	b = appendJSONString(b, s.Path)
+	field("line")
+	b = strconv.AppendInt(b, int64(s.Line), 10)
+	field("character")
+	b = strconv.AppendInt(b, int64(s.Character), 10)
+	if s.Receiver != "" {
+		field("receiver")
+		b = appendJSONString(b, s.Receiver)
+	}
+	if s.Signature != "" {
+		field("signature")
+		b = appendJSONString(b, s.Signature)
+	}
+	if s.Summary != "" {
+		field("summary")
+		b = appendJSONString(b, s.Summary)
+	}
+	if s.Source != "" {
+		field("source")
+		b = appendJSONString(b, s.Source)
+	}
+	if s.Module != "" {
+		field("module")
+		b = appendJSONString(b, s.Module)
+	}
+	if s.Build != "" {
+		field("build")
+		b = appendJSONString(b, s.Build)
+	}
+	if s.Object != "" {
+		field("object")
+		b = appendJSONString(b, s.Object)
+	}
+	if s.Type != "" {
+		field("type")
+		b = appendJSONString(b, s.Type)
+	}
+	if s.DefinedIn != "" {
+		field("definedIn")
+		b = appendJSONString(b, s.DefinedIn)
+	}
+	if s.Aliased != "" {
+		field("aliased")
+		b = appendJSONString(b, s.Aliased)
+	}
+	if len(s.TypeParams) > 0 {
+		field("typeParams")
+		b = appendJSONTypeParams(b, s.TypeParams, prefix, compact)
+	}
+	if s.Importable != nil {
+		field("importable")
+		b = strconv.AppendBool(b, *s.Importable)
+	}
+	if s.References != nil {
+		field("references")
+		b = strconv.AppendInt(b, int64(*s.References), 10)
+	}
+	if len(s.Implements) > 0 {
+		field("implements")
+		b = appendJSONStrings(b, s.Implements, prefix, compact)
+	}
+	if len(s.ImplementedBy) > 0 {
+		field("implementedBy")
+		b = appendJSONStrings(b, s.ImplementedBy, prefix, compact)
+	}
+	if len(s.Tags) > 0 {
+		field("tags")
+		b = appendJSONTags(b, s.Tags, prefix, compact)
+	}
+	if s.Promoted {
+		field("promoted")
+		b = append(b, "true"...)
+	}
+	if !compact {
+		b = append(b, '\n')
+		b = append(b, prefix...)
+	}
+	return append(b, '}')
+}
+
+// appendJSONStrings appends list as a JSON array, indented as a field of
+// appendSymbolJSON.
+func appendJSONStrings(b []byte, list []string, prefix string, compact bool) []byte {
+	b = append(b, '[')
+	for i, s := range list {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		if !compact {
+			b = append(b, '\n')
+			b = append(b, prefix...)
+			b = append(b, "  "...)
+		}
+		b = appendJSONString(b, s)
+	}
+	if !compact {
+		b = append(b, '\n')
+		b = append(b, prefix...)
+		b = append(b, ' ')
+	}
+	return append(b, ']')
+}
+
+// appendJSONTypeParams appends tparams as a JSON array of objects, indented
+// as appendJSONStrings indents arrays.
+func appendJSONTypeParams(b []byte, tparams []symbols.TypeParam, prefix string, compact bool) []byte {
+	inner := prefix + "  "
+	newline := func(indent string) {
+		if !compact {
+			b = append(b, '\n')
+			b = append(b, indent...)
+		}
+	}
+	field := func(name string) {
+		newline(inner + " ")
+		b = appendJSONString(b, name)
+		b = append(b, ':')
+		if !compact {
+			b = append(b, ' ')
+		}
+	}
+	b = append(b, '[')
+	for i, tp := range tparams {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		newline(inner)
+		b = append(b, '{')
+		field("name")
+		b = appendJSONString(b, tp.Name)
+		b = append(b, ',')
+		field("constraint")
+		b = appendJSONString(b, tp.Constraint)
+		if len(tp.Constraints) > 0 {
+			b = append(b, ',')
+			field("constraints")
+			b = appendJSONStrings(b, tp.Constraints, inner, compact)
+		}
+		newline(inner)
+		b = append(b, '}')
+	}
+	newline(prefix + " ")
+	return append(b, ']')
+}
+
+// appendJSONTags appends tags as a JSON object with sorted keys, indented
+// as appendJSONStrings indents arrays.
+func appendJSONTags(b []byte, tags map[string]string, prefix string, compact bool) []byte {
+	b = append(b, '{')
+	for i, key := range sortedTagKeys(tags) {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		if !compact {
+			b = append(b, '\n')
+			b = append(b, prefix...)
+			b = append(b, "  "...)
+		}
+		b = appendJSONString(b, key)
+		b = append(b, ':')
+		if !compact {
+			b = append(b, ' ')
+		}
+		b = appendJSONString(b, tags[key])
+	}
+	if !compact {
+		b = append(b, '\n')
+		b = append(b, prefix...)
+		b = append(b, ' ')
+	}
+	return append(b, '}')
+}
+
+// sortedTagKeys returns the keys of tags in order.
+func sortedTagKeys(tags map[string]string) []string {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+const hexDigits = "0123456789abcdef"
+
+// appendJSONString appends s as a JSON string, escaped as encoding/json
+// does, including its escaping of HTML characters.
+func appendJSONString(b []byte, s string) []byte {
+	b = append(b, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if c := s[i]; c < utf8.RuneSelf {
+			if c >= ' ' && c != '"' && c != '\\' && c != '<' && c != '>' && c != '&' {
+				i++
+				continue
+			}
+			b = append(b, s[start:i]...)
+			switch c {
+			case '"', '\\':
+				b = append(b, '\\', c)
+			case '\n':
+				b = append(b, '\\', 'n')
+			case '\r':
+				b = append(b, '\\', 'r')
+			case '\t':
+				b = append(b, '\\', 't')
+			default:
+				b = append(b, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			b = append(b, s[start:i]...)
+			b = append(b, `\ufffd`...)
+			i += size
+			start = i
+			continue
+		}
+		if r == '\u2028' || r == '\u2029' {
+			b = append(b, s[start:i]...)
+			b = append(b, '\\', 'u', '2', '0', '2', hexDigits[r&0xF])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	b = append(b, s[start:]...)
+	return append(b, '"')
+}
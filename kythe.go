@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+)
+
+// A kytheVName names a Kythe node.
+type kytheVName struct {
+	Signature string `json:"signature,omitempty"`
+	Corpus    string `json:"corpus,omitempty"`
+	Root      string `json:"root,omitempty"`
+	Path      string `json:"path,omitempty"`
+	Language  string `json:"language,omitempty"`
+}
+
+// A kytheEntry is a fact about a node, or an edge between two nodes, as
+// written by Kythe's entrystream tool in JSON.
+type kytheEntry struct {
+	Source    kytheVName  `json:"source"`
+	EdgeKind  string      `json:"edge_kind,omitempty"`
+	Target    *kytheVName `json:"target,omitempty"`
+	FactName  string      `json:"fact_name"`
+	FactValue []byte      `json:"fact_value,omitempty"`
+}
+
+// writeKythe writes syms as a stream of Kythe entries, one per line: a
+// file node for each file, with its text, and for each symbol an anchor
+// over its name defining a semantic node. Files are named by their path
+// relative to the workspace root and semantic nodes by their import path
+// and name, or receiver type and name, all in the -kythe-corpus corpus.
+// Symbols whose name is not found at their position are left out.
+func writeKythe(w io.Writer, syms []symbol) error {
+	byPath := make(map[string][]symbol)
+	var paths []string
+	for _, s := range syms {
+		if _, ok := byPath[s.Path]; !ok {
+			paths = append(paths, s.Path)
+		}
+		byPath[s.Path] = append(byPath[s.Path], s)
+	}
+	sort.Strings(paths)
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	enc.SetEscapeHTML(false)
+	var err error
+	fact := func(v kytheVName, name, value string) {
+		if err == nil {
+			err = enc.Encode(kytheEntry{Source: v, FactName: name, FactValue: []byte(value)})
+		}
+	}
+	edge := func(v kytheVName, kind string, target kytheVName) {
+		if err == nil {
+			err = enc.Encode(kytheEntry{Source: v, EdgeKind: kind, Target: &target, FactName: "/"})
+		}
+	}
+	for _, path := range paths {
+		src, rerr := ioutil.ReadFile(sourcePath(path))
+		if rerr != nil {
+			continue
+		}
+		starts := lineStarts(src)
+		file := kytheVName{Corpus: *kytheCorpusFlag, Path: rootRelative(path)}
+		fact(file, "/kythe/node/kind", "file")
+		fact(file, "/kythe/text", string(src))
+		for _, s := range byPath[path] {
+			start, ok := nameOffset(src, starts, s)
+			if !ok {
+				continue
+			}
+			end := start + len(s.Name)
+			anchor := file
+			anchor.Language = "go"
+			anchor.Signature = "@" + strconv.Itoa(start) + ":" + strconv.Itoa(end)
+			fact(anchor, "/kythe/node/kind", "anchor")
+			fact(anchor, "/kythe/loc/start", strconv.Itoa(start))
+			fact(anchor, "/kythe/loc/end", strconv.Itoa(end))
+
+			node := kytheNode(s)
+			kind, subkind := kytheKind(s)
+			fact(node, "/kythe/node/kind", kind)
+			if subkind != "" {
+				fact(node, "/kythe/subkind", subkind)
+			}
+			edge(anchor, "/kythe/edge/defines/binding", node)
+			if s.Receiver != "" {
+				parent := node
+				parent.Signature = receiverType(s.Receiver)
+				edge(node, "/kythe/edge/childof", parent)
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// kytheNode returns the VName of the semantic node s defines.
+func kytheNode(s symbol) kytheVName {
+	pkg := s.ImportPath
+	if pkg == "" {
+		pkg = s.Package
+	}
+	v := kytheVName{Corpus: *kytheCorpusFlag, Path: pkg, Language: "go", Signature: s.Name}
+	switch {
+	case s.Kind == "package":
+		v.Signature = "package"
+	case s.Receiver != "":
+		v.Signature = receiverType(s.Receiver) + "." + s.Name
+	}
+	return v
+}
+
+// kytheKind returns the Kythe node kind and subkind of the node s defines.
+func kytheKind(s symbol) (kind, subkind string) {
+	switch s.Kind {
+	case "func":
+		return "function", ""
+	case "var":
+		return "variable", ""
+	case "field":
+		return "variable", "field"
+	case "package":
+		return "package", ""
+	case "type":
+		switch s.TypeKind {
+		case "interface":
+			return "interface", ""
+		case "struct":
+			return "record", "struct"
+		}
+		return "record", ""
+	}
+	return s.Kind, ""
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log/slog"
+	"os"
+)
+
+var (
+	verboseFlag   = flag.Bool("v", false, "log debugging details")
+	quietFlag     = flag.Bool("q", false, "only log errors")
+	logFileFlag   = flag.String("log-file", "", "append logs to `file` instead of standard error")
+	logFormatFlag = flag.String("log-format", "text", "log `format`: text or json")
+)
+
+// logger receives the diagnostics of gosymbols, which never go to standard
+// output where they would corrupt the results.
+var logger = slog.New(newLogHandler(os.Stderr, "text", slog.LevelInfo))
+
+// startLogging configures logger as requested by the flags.
+func startLogging() error {
+	if *verboseFlag && *quietFlag {
+		return usagef("-v and -q cannot be combined")
+	}
+	level := slog.LevelInfo
+	if *verboseFlag {
+		level = slog.LevelDebug
+	} else if *quietFlag {
+		level = slog.LevelError
+	}
+	if *logFormatFlag != "text" && *logFormatFlag != "json" {
+		return usagef("unknown -log-format %q", *logFormatFlag)
+	}
+	var w io.Writer = os.Stderr
+	if *logFileFlag != "" {
+		f, err := os.OpenFile(*logFileFlag, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return err
+		}
+		w = f
+	}
+	logger = slog.New(newLogHandler(w, *logFormatFlag, level))
+	return nil
+}
+
+func newLogHandler(w io.Writer, format string, level slog.Level) slog.Handler {
+	opts := &slog.HandlerOptions{Level: level}
+	if format == "json" {
+		return slog.NewJSONHandler(w, opts)
+	}
+	if w == io.Writer(os.Stderr) {
+		// Read as it is written, so the time is noise.
+		opts.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey && len(groups) == 0 {
+				return slog.Attr{}
+			}
+			return a
+		}
+	}
+	return slog.NewTextHandler(w, opts)
+}
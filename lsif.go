@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+)
+
+// runLSIF implements the lsif command, which writes an LSIF dump of the
+// matching symbols to standard output.
+func runLSIF(args []string) error {
+	dir, query := parseArgs(args)
+
+	var syms []symbol
+	scan(context.Background(), dir, query, func(found []symbol) {
+		syms = append(syms, found...)
+	})
+	return writeLSIF(os.Stdout, workspaceRoot, syms)
+}
+
+type lsifPos struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lsifHover struct {
+	Contents []lsifMarked `json:"contents"`
+}
+
+type lsifMarked struct {
+	Language string `json:"language"`
+	Value    string `json:"value"`
+}
+
+// lsifElement is a vertex or an edge of the LSIF graph. Only the fields
+// relevant to each label are set.
+type lsifElement struct {
+	ID    int    `json:"id"`
+	Type  string `json:"type"`
+	Label string `json:"label"`
+
+	// metaData
+	Version          string            `json:"version,omitempty"`
+	ProjectRoot      string            `json:"projectRoot,omitempty"`
+	PositionEncoding string            `json:"positionEncoding,omitempty"`
+	ToolInfo         map[string]string `json:"toolInfo,omitempty"`
+
+	// project, document and $event
+	Kind       string `json:"kind,omitempty"`
+	URI        string `json:"uri,omitempty"`
+	LanguageID string `json:"languageId,omitempty"`
+	Scope      string `json:"scope,omitempty"`
+	Data       int    `json:"data,omitempty"`
+
+	// range and hoverResult
+	Start  *lsifPos   `json:"start,omitempty"`
+	End    *lsifPos   `json:"end,omitempty"`
+	Result *lsifHover `json:"result,omitempty"`
+
+	// edges
+	OutV     int   `json:"outV,omitempty"`
+	InV      int   `json:"inV,omitempty"`
+	InVs     []int `json:"inVs,omitempty"`
+	Document int   `json:"document,omitempty"`
+}
+
+// lsifWriter assigns ids and writes elements as line-delimited JSON.
+type lsifWriter struct {
+	enc *json.Encoder
+	id  int
+	err error
+}
+
+func (lw *lsifWriter) vertex(e lsifElement) int {
+	return lw.emit("vertex", e)
+}
+
+// edge adds a 1:1 edge.
+func (lw *lsifWriter) edge(label string, out, in int) {
+	lw.emit("edge", lsifElement{Label: label, OutV: out, InV: in})
+}
+
+// edges adds a 1:n edge, optionally scoped to a document.
+func (lw *lsifWriter) edges(label string, out int, in []int, doc int) {
+	lw.emit("edge", lsifElement{Label: label, OutV: out, InVs: in, Document: doc})
+}
+
+func (lw *lsifWriter) emit(typ string, e lsifElement) int {
+	lw.id++
+	e.ID = lw.id
+	e.Type = typ
+	if lw.err == nil {
+		lw.err = lw.enc.Encode(e)
+	}
+	return e.ID
+}
+
+// writeLSIF writes an LSIF dump with a document per file and a range,
+// definition result and hover result for every symbol.
+func writeLSIF(w io.Writer, root string, syms []symbol) error {
+	bw := bufio.NewWriter(w)
+	lw := &lsifWriter{enc: json.NewEncoder(bw)}
+
+	lw.vertex(lsifElement{
+		Label:            "metaData",
+		Version:          "0.4.3",
+		ProjectRoot:      fileURI(root),
+		PositionEncoding: "utf-16",
+		ToolInfo:         map[string]string{"name": "gosymbols"},
+	})
+	project := lw.vertex(lsifElement{Label: "project", Kind: "go"})
+	lw.vertex(lsifElement{Label: "$event", Kind: "begin", Scope: "project", Data: project})
+
+	byPath := make(map[string][]symbol)
+	var paths []string
+	for _, s := range syms {
+		if _, ok := byPath[s.Path]; !ok {
+			paths = append(paths, s.Path)
+		}
+		byPath[s.Path] = append(byPath[s.Path], s)
+	}
+	sort.Strings(paths)
+
+	var docs []int
+	for _, path := range paths {
+		doc := lw.vertex(lsifElement{Label: "document", URI: fileURI(path), LanguageID: "go"})
+		docs = append(docs, doc)
+		lw.vertex(lsifElement{Label: "$event", Kind: "begin", Scope: "document", Data: doc})
+
+		var ranges []int
+		for _, s := range byPath[path] {
+			start := lsifPos{Line: s.Line, Character: s.Character}
+			end := lsifPos{Line: s.Line, Character: s.Character + utf16Len(s.Name)}
+			rng := lw.vertex(lsifElement{Label: "range", Start: &start, End: &end})
+			ranges = append(ranges, rng)
+
+			set := lw.vertex(lsifElement{Label: "resultSet"})
+			lw.edge("next", rng, set)
+
+			def := lw.vertex(lsifElement{Label: "definitionResult"})
+			lw.edge("textDocument/definition", set, def)
+			lw.edges("item", def, []int{rng}, doc)
+
+			hover := lw.vertex(lsifElement{
+				Label:  "hoverResult",
+				Result: &lsifHover{Contents: []lsifMarked{{Language: "go", Value: declString(s)}}},
+			})
+			lw.edge("textDocument/hover", set, hover)
+		}
+		if len(ranges) > 0 {
+			lw.edges("contains", doc, ranges, 0)
+		}
+		lw.vertex(lsifElement{Label: "$event", Kind: "end", Scope: "document", Data: doc})
+	}
+	if len(docs) > 0 {
+		lw.edges("contains", project, docs, 0)
+	}
+	lw.vertex(lsifElement{Label: "$event", Kind: "end", Scope: "project", Data: project})
+
+	if lw.err != nil {
+		return lw.err
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"unicode/utf16"
+)
+
+// LSP SymbolKind values.
+const (
+	lspKindPackage   = 4
+	lspKindClass     = 5
+	lspKindMethod    = 6
+	lspKindField     = 8
+	lspKindInterface = 11
+	lspKindFunction  = 12
+	lspKindVariable  = 13
+	lspKindStruct    = 23
+)
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+// lspSymbolInformation is the LSP SymbolInformation structure.
+type lspSymbolInformation struct {
+	Name          string      `json:"name"`
+	Kind          int         `json:"kind"`
+	Location      lspLocation `json:"location"`
+	ContainerName string      `json:"containerName,omitempty"`
+}
+
+// lspDocumentSymbol is the LSP DocumentSymbol structure, nesting the
+// symbols of a document. Symbols only have the range of their name.
+type lspDocumentSymbol struct {
+	Name           string              `json:"name"`
+	Detail         string              `json:"detail,omitempty"`
+	Kind           int                 `json:"kind"`
+	Range          lspRange            `json:"range"`
+	SelectionRange lspRange            `json:"selectionRange"`
+	Children       []lspDocumentSymbol `json:"children,omitempty"`
+}
+
+// lspWorkspaceSymbol is the LSP WorkspaceSymbol structure. It is sent
+// without a range to clients that resolve it later, with the position of
+// the symbol as its data.
+type lspWorkspaceSymbol struct {
+	Name     string `json:"name"`
+	Kind     int    `json:"kind"`
+	Location struct {
+		URI   string    `json:"uri"`
+		Range *lspRange `json:"range,omitempty"`
+	} `json:"location"`
+	ContainerName string         `json:"containerName,omitempty"`
+	Data          *lspSymbolData `json:"data,omitempty"`
+}
+
+// lspSymbolData locates the symbol of a lspWorkspaceSymbol to resolve.
+type lspSymbolData struct {
+	Path      string `json:"path"`
+	Line      int    `json:"line"`
+	Character int    `json:"character"`
+}
+
+// lspProgress is the parameter of the LSP $/progress notification.
+type lspProgress struct {
+	Token json.RawMessage `json:"token"`
+	Value lspWorkDone     `json:"value"`
+}
+
+// lspWorkDone is the value of a $/progress notification reporting work
+// done: the begin, report or end of the work.
+type lspWorkDone struct {
+	Kind       string `json:"kind"`
+	Title      string `json:"title,omitempty"` // of begin
+	Message    string `json:"message,omitempty"`
+	Percentage *int   `json:"percentage,omitempty"`
+}
+
+// lspSymbolKind returns the LSP SymbolKind for s.
+func lspSymbolKind(s symbol) int {
+	switch s.Kind {
+	case "func":
+		if s.Receiver != "" {
+			return lspKindMethod
+		}
+		return lspKindFunction
+	case "type":
+		switch s.TypeKind {
+		case "struct":
+			return lspKindStruct
+		case "interface":
+			return lspKindInterface
+		}
+		return lspKindClass
+	case "field":
+		return lspKindField
+	case "package":
+		return lspKindPackage
+	case "var":
+		return lspKindVariable
+	}
+	return lspKindClass
+}
+
+// lspSymbol returns the LSP SymbolInformation of s, whose name spans r.
+func lspSymbol(s symbol, r lspRange) lspSymbolInformation {
+	return lspSymbolInformation{
+		Name:          s.Name,
+		Kind:          lspSymbolKind(s),
+		Location:      lspLocation{URI: fileURI(sourcePath(s.Path)), Range: r},
+		ContainerName: lspContainer(s),
+	}
+}
+
+// utf16Range returns the range of the name of s in UTF-16 code units,
+// reading the line it is on from its file. lines caches the lines of the
+// files read.
+func utf16Range(s symbol, lines map[string][]string) lspRange {
+	start := utf16Column(lines, sourcePath(s.Path), s.Line, s.Character)
+	return lspRange{lspPosition{s.Line, start}, lspPosition{s.Line, start + utf16Len(s.Name)}}
+}
+
+// lspStub returns the workspace symbol for s without its range, which
+// workspaceSymbol/resolve fills in.
+func lspStub(s symbol) lspWorkspaceSymbol {
+	stub := lspWorkspaceSymbol{
+		Name:          s.Name,
+		Kind:          lspSymbolKind(s),
+		ContainerName: lspContainer(s),
+		Data:          &lspSymbolData{s.Path, s.Line, s.Character},
+	}
+	stub.Location.URI = fileURI(sourcePath(s.Path))
+	return stub
+}
+
+// lspContainer returns the container name of s: its receiver type, or
+// otherwise its package.
+func lspContainer(s symbol) string {
+	if s.Receiver != "" {
+		return receiverType(s.Receiver)
+	}
+	return s.Package
+}
+
+// writeLSP writes syms as a JSON array of LSP SymbolInformation, as
+// returned by a workspace/symbol request.
+func writeLSP(w io.Writer, syms []symbol) error {
+	infos := make([]lspSymbolInformation, len(syms))
+	lines := make(map[string][]string)
+	for i, s := range syms {
+		infos[i] = lspSymbol(s, utf16Range(s, lines))
+	}
+	b, err := marshalJSON(infos)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
+
+// utf16Len returns the length of s in UTF-16 code units, the unit of LSP
+// character offsets.
+func utf16Len(s string) int {
+	return len(utf16.Encode([]rune(s)))
+}
+
+// utf16Column returns the offset col, in bytes, of the given line of the
+// file at path in UTF-16 code units. The lines of the files read are kept
+// in lines. Offsets beyond the file, as it changed since it was parsed, are
+// returned as they are.
+func utf16Column(lines map[string][]string, path string, line, col int) int {
+	l, ok := lines[path]
+	if !ok {
+		if b, err := ioutil.ReadFile(path); err == nil {
+			l = strings.Split(string(b), "\n")
+		}
+		lines[path] = l
+	}
+	if line >= len(l) || col > len(l[line]) {
+		return col
+	}
+	return utf16Len(l[line][:col])
+}
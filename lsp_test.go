@@ -0,0 +1,33 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteLSPRangesInUTF16(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.go")
+	src := "package a\n\ntype T int\n\nfunc (é *T) Größe() {}\n"
+	if err := ioutil.WriteFile(path, []byte(src), 0666); err != nil {
+		t.Fatal(err)
+	}
+	// Größe follows é (2 bytes, 1 code unit) and is 7 bytes long.
+	s := symbol{Name: "Größe", Kind: "func", Receiver: "*T", Path: path, Line: 4, Character: 13}
+	var b bytes.Buffer
+	if err := writeLSP(&b, []symbol{s}); err != nil {
+		t.Fatal(err)
+	}
+	var infos []lspSymbolInformation
+	if err := json.Unmarshal(b.Bytes(), &infos); err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 {
+		t.Fatalf("writeLSP wrote %s, want one symbol", b.Bytes())
+	}
+	if r := infos[0].Location.Range; r.Start.Character != 12 || r.End.Character != 17 {
+		t.Errorf("range %v, want characters 12 to 17", r)
+	}
+}
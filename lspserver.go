@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/newhook/go-symbols/symbols"
+)
+
+// lspServerNotInitialized is the LSP error code for requests preceding
+// initialize.
+const lspServerNotInitialized = -32002
+
+// readLSPMessage reads a message framed by a Content-Length header, as
+// LSP sends them.
+func readLSPMessage(r *bufio.Reader) ([]byte, error) {
+	length := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if name, value, ok := strings.Cut(line, ":"); ok && strings.EqualFold(name, "Content-Length") {
+			if length, err = strconv.Atoi(strings.TrimSpace(value)); err != nil {
+				return nil, fmt.Errorf("invalid Content-Length %q", value)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("message without Content-Length")
+	}
+	b := make([]byte, length)
+	_, err := io.ReadFull(r, b)
+	return b, err
+}
+
+// writeLSPMessage writes v as a message framed by a Content-Length header.
+func writeLSPMessage(w *bufio.Writer, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Content-Length: %d\r\n\r\n", len(b))
+	w.Write(b)
+	return w.Flush()
+}
+
+// An lspServer answers LSP requests from the symbols held by srv, which is
+// loaded once the client initializes it.
+type lspServer struct {
+	srv      *symbolServer
+	shutdown bool
+	w        *bufio.Writer // to the client
+
+	// lazy is set if the client resolves the ranges of workspace
+	// symbols, which are then answered without them.
+	lazy bool
+
+	// utf8 is set if the client accepts positions counted in bytes, as
+	// symbols are. They are otherwise converted to the UTF-16 code units
+	// LSP counts by default.
+	utf8 bool
+}
+
+// runLSP implements the lsp command, a language server speaking LSP over
+// standard input and output. It scans dir, or the root given by the client
+// if none, once initialized and keeps the symbols up to date as files
+// change.
+func runLSP(args []string) error {
+	ls := new(lspServer)
+	if len(args) > 0 {
+		dir, _ := parseArgs(args)
+		ls.srv = newSymbolServer(dir)
+	}
+	r := bufio.NewReader(os.Stdin)
+	w := bufio.NewWriter(os.Stdout)
+	ls.w = w
+	for {
+		b, err := readLSPMessage(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var req rpcRequest
+		if err := json.Unmarshal(b, &req); err != nil {
+			writeLSPMessage(w, newRPCResponse(nil, nil, &rpcError{rpcParseError, err.Error()}))
+			continue
+		}
+		if req.Method == "exit" {
+			if !ls.shutdown {
+				return fmt.Errorf("exit before shutdown")
+			}
+			return nil
+		}
+		result, err := ls.handle(req)
+		if req.ID == nil {
+			continue // a notification
+		}
+		if err := writeLSPMessage(w, newRPCResponse(req.ID, result, err)); err != nil {
+			return err
+		}
+	}
+}
+
+// absDirs makes each directory of the list dirs absolute, so that the paths
+// of symbols can be compared with those of documents.
+func absDirs(dirs string) string {
+	list := filepath.SplitList(dirs)
+	for i, dir := range list {
+		if abs, err := filepath.Abs(dir); err == nil {
+			list[i] = abs
+		}
+	}
+	return strings.Join(list, string(filepath.ListSeparator))
+}
+
+// handle returns the result of req.
+func (ls *lspServer) handle(req rpcRequest) (interface{}, error) {
+	if req.Method == "initialize" {
+		return ls.initialize(req.Params)
+	}
+	if ls.srv == nil || !ls.srv.isLoaded() {
+		return nil, &rpcError{lspServerNotInitialized, "server not initialized"}
+	}
+	switch req.Method {
+	case "workspace/symbol":
+		var params struct {
+			Query string `json:"query"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, &rpcError{rpcInvalidParams, err.Error()}
+		}
+		return ls.workspaceSymbols(params.Query), nil
+	case "workspaceSymbol/resolve":
+		var stub lspWorkspaceSymbol
+		if err := json.Unmarshal(req.Params, &stub); err != nil {
+			return nil, &rpcError{rpcInvalidParams, err.Error()}
+		}
+		return ls.resolve(stub)
+	case "textDocument/documentSymbol":
+		var params struct {
+			TextDocument struct {
+				URI string `json:"uri"`
+			} `json:"textDocument"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, &rpcError{rpcInvalidParams, err.Error()}
+		}
+		return ls.documentSymbols(uriPath(params.TextDocument.URI)), nil
+	case "shutdown":
+		ls.shutdown = true
+		return nil, nil
+	}
+	return nil, &rpcError{rpcMethodNotFound, "method not found: " + req.Method}
+}
+
+// initialize scans the workspace, given by the client unless it was on the
+// command line, and returns the server's capabilities.
+func (ls *lspServer) initialize(params json.RawMessage) (interface{}, error) {
+	var p struct {
+		RootURI       string          `json:"rootUri"`
+		RootPath      string          `json:"rootPath"`
+		WorkDoneToken json.RawMessage `json:"workDoneToken"`
+		Capabilities  struct {
+			General struct {
+				PositionEncodings []string `json:"positionEncodings"`
+			} `json:"general"`
+			Workspace struct {
+				Symbol struct {
+					ResolveSupport struct {
+						Properties []string `json:"properties"`
+					} `json:"resolveSupport"`
+				} `json:"symbol"`
+			} `json:"workspace"`
+		} `json:"capabilities"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, &rpcError{rpcInvalidParams, err.Error()}
+	}
+	for _, prop := range p.Capabilities.Workspace.Symbol.ResolveSupport.Properties {
+		if prop == "location.range" {
+			ls.lazy = true
+		}
+	}
+	for _, enc := range p.Capabilities.General.PositionEncodings {
+		if enc == "utf-8" {
+			ls.utf8 = true
+		}
+	}
+	if ls.srv == nil {
+		dir := p.RootPath
+		if p.RootURI != "" {
+			dir = uriPath(p.RootURI)
+		}
+		if dir == "" {
+			return nil, &rpcError{rpcInvalidParams, "no workspace root given"}
+		}
+		setWorkspace(dir)
+		ls.srv = newSymbolServer(dir)
+	}
+	if !ls.srv.isLoaded() {
+		ctx := context.Background()
+		if p.WorkDoneToken != nil {
+			ctx = withProgress(ctx, ls.reportProgress(p.WorkDoneToken))
+		}
+		ls.srv.loadAndWatch(ctx)
+	}
+	encoding := "utf-16"
+	if ls.utf8 {
+		encoding = "utf-8"
+	}
+	return map[string]interface{}{
+		"capabilities": map[string]interface{}{
+			"positionEncoding":        encoding,
+			"workspaceSymbolProvider": map[string]bool{"resolveProvider": true},
+			"documentSymbolProvider":  true,
+		},
+		"serverInfo": map[string]string{"name": "go-symbols"},
+	}, nil
+}
+
+// symbolInformation returns the LSP SymbolInformation of s, with its
+// position in the encoding agreed with the client. lines caches the lines
+// of the files read to convert positions.
+func (ls *lspServer) symbolInformation(s symbol, lines map[string][]string) lspSymbolInformation {
+	if !ls.utf8 {
+		return lspSymbol(s, utf16Range(s, lines))
+	}
+	return lspSymbol(s, lspRange{lspPosition{s.Line, s.Character}, lspPosition{s.Line, s.Character + len(s.Name)}})
+}
+
+// reportProgress returns the function reporting the progress of the scan
+// made by initialize to the client as that of the work done for token.
+func (ls *lspServer) reportProgress(token json.RawMessage) func(symbols.Progress) {
+	ls.notify("$/progress", lspProgress{token, lspWorkDone{Kind: "begin", Title: "Scanning " + ls.srv.dir, Percentage: new(int)}})
+	return func(p symbols.Progress) {
+		percent := progressPercent(p)
+		done := lspWorkDone{Kind: "report", Message: progressMessage(p), Percentage: &percent}
+		if p.Listed && p.Done == p.Packages {
+			done = lspWorkDone{Kind: "end", Message: progressMessage(p)}
+		}
+		ls.notify("$/progress", lspProgress{token, done})
+	}
+}
+
+// notify sends the client a notification.
+func (ls *lspServer) notify(method string, params interface{}) {
+	b, err := json.Marshal(params)
+	if err != nil {
+		return
+	}
+	writeLSPMessage(ls.w, rpcRequest{JSONRPC: "2.0", Method: method, Params: b})
+}
+
+// workspaceSymbols returns the symbols matching query, at most -limit of
+// them if it is set. Clients resolving ranges get stubs without them.
+func (ls *lspServer) workspaceSymbols(query string) interface{} {
+	var syms []symbol
+	ls.srv.source(context.Background(), ls.srv.dir, strings.ToLower(query), func(found []symbol) {
+		syms = append(syms, found...)
+	})
+	less := symbolOrders["path"]
+	sort.Slice(syms, func(i, j int) bool { return less(syms[i], syms[j]) })
+	if *limitFlag > 0 && len(syms) > *limitFlag {
+		syms = syms[:*limitFlag]
+	}
+	if ls.lazy {
+		stubs := make([]lspWorkspaceSymbol, len(syms))
+		for i, s := range syms {
+			stubs[i] = lspStub(s)
+		}
+		return stubs
+	}
+	infos := make([]lspSymbolInformation, len(syms))
+	lines := make(map[string][]string)
+	for i, s := range syms {
+		infos[i] = ls.symbolInformation(s, lines)
+	}
+	return infos
+}
+
+// resolve returns the workspace symbol stub with its range, found by the
+// position in its data or, if its file changed since, by its name and
+// container.
+func (ls *lspServer) resolve(stub lspWorkspaceSymbol) (interface{}, error) {
+	if stub.Data == nil {
+		return nil, &rpcError{rpcInvalidParams, "workspace symbol without data"}
+	}
+	ls.srv.mu.RLock()
+	var match *symbol
+	if f := ls.srv.files[filepath.Clean(stub.Data.Path)]; f != nil {
+		for i, s := range f.syms {
+			if s.Name != stub.Name || lspContainer(s) != stub.ContainerName {
+				continue
+			}
+			if match == nil || s.Line == stub.Data.Line && s.Character == stub.Data.Character {
+				match = &f.syms[i]
+			}
+		}
+	}
+	ls.srv.mu.RUnlock() // the symbols of a file are replaced, not changed
+	if match == nil {
+		return nil, &rpcError{rpcInvalidParams, "symbol not found: " + stub.Name}
+	}
+	return ls.symbolInformation(*match, make(map[string][]string)), nil
+}
+
+// documentSymbols returns the symbols declared in the file at path.
+func (ls *lspServer) documentSymbols(path string) []lspSymbolInformation {
+	path = filepath.Clean(path)
+	ls.srv.mu.RLock()
+	f := ls.srv.files[path]
+	if f == nil && runtime.GOOS == "windows" {
+		// Editors may spell drive letters and directories in another
+		// case than the workspace.
+		for name, file := range ls.srv.files {
+			if strings.EqualFold(name, path) {
+				f = file
+				break
+			}
+		}
+	}
+	ls.srv.mu.RUnlock()
+	infos := make([]lspSymbolInformation, 0)
+	if f != nil {
+		lines := make(map[string][]string)
+		for _, s := range f.syms {
+			infos = append(infos, ls.symbolInformation(s, lines))
+		}
+	}
+	return infos
+}
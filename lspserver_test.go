@@ -0,0 +1,36 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestSymbolInformationEncoding(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.go")
+	src := "package a\n\nvar é, 𝔸, X, Größe = 1, 2, 3, 4\n"
+	if err := ioutil.WriteFile(path, []byte(src), 0666); err != nil {
+		t.Fatal(err)
+	}
+	// X follows é (2 bytes, 1 code unit) and 𝔸 (4 bytes, 2 code units).
+	// Größe is 7 bytes and 5 code units long.
+	x := symbol{Name: "X", Kind: "var", Path: path, Line: 2, Character: 14}
+	long := symbol{Name: "Größe", Kind: "var", Path: path, Line: 2, Character: 17}
+	tests := []struct {
+		sym        symbol
+		utf8       bool
+		start, end int
+	}{
+		{x, false, 11, 12},
+		{x, true, 14, 15},
+		{long, false, 14, 19},
+		{long, true, 17, 24},
+	}
+	for _, tt := range tests {
+		ls := &lspServer{utf8: tt.utf8}
+		r := ls.symbolInformation(tt.sym, make(map[string][]string)).Location.Range
+		if r.Start.Character != tt.start || r.End.Character != tt.end {
+			t.Errorf("%s, utf8 %v: range %v, want characters %d to %d", tt.sym.Name, tt.utf8, r, tt.start, tt.end)
+		}
+	}
+}
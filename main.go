@@ -2,246 +2,515 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"flag"
 	"fmt"
-	"go/ast"
 	"go/build"
-	"go/parser"
-	"go/token"
+	"io"
 	"io/ioutil"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
-	"sync"
+	"syscall"
+	"time"
 
+	"github.com/newhook/go-symbols/symbols"
 	"golang.org/x/tools/go/buildutil"
 )
 
-const usage = `Usage: gosymbols <package> ...`
+var (
+	pkgNameFlag     = flag.String("pkg-name", "", "only report symbols from packages declared with this `name`")
+	relativeTo      = flag.String("relative-to", "", "report paths relative to `dir`")
+	uriFlag         = flag.Bool("uri", false, "report paths as file:// URIs")
+	symlinksFlag    = flag.String("symlinks", "", "report paths with symbolic links resolved, or under the directories as given: `mode` resolve or preserve (default as found)")
+	outputFlag      = flag.String("o", "", "write the output to `file`, atomically replacing it")
+	compactFlag     = flag.Bool("compact", false, "write json without indentation")
+	envelopeFlag    = flag.Bool("envelope", false, "wrap json output in an object carrying the schema version, scope and errors")
+	compress        = flag.String("compress", "", "compress the output with `method` gzip")
+	sortFlag        = flag.String("sort", "", "sort results by `key`: path, name or none (default path, or none for streaming formats)")
+	formatFlag      = flag.String("format", "json", "output `format`: json, ndjson, lsp, plain, table, csv, tsv, proto, msgpack, fzf, ctags, ctags-json, etags, cscope, imenu, markdown, quickfix, acme, scip, kythe or zoekt")
+	positionBase    = flag.Int("position-base", 0, "number the lines and columns of json, ndjson, csv, tsv, proto, msgpack and -format-template output from `n`, 0 or 1")
+	kindFormatFlag  = flag.String("kind-format", "", "write kinds in json, ndjson and msgpack output as `format` lsp, the numbers of the LSP SymbolKind enum")
+	kytheCorpusFlag = flag.String("kythe-corpus", "", "name files and symbols in -format kythe output as in the Kythe `corpus`")
+	templateFlag    = flag.String("format-template", "", "write each symbol using the text/template `template`, overriding -format")
+	groupBy         = flag.String("group-by", "", "group json output by `key`: package")
+	statsFlag       = flag.Bool("stats", false, "report scan statistics on standard error, or in the -envelope")
+	colorFlag       = flag.String("color", "auto", "colorize output: `mode` auto, always or never")
+	withSource      = flag.Bool("with-source", false, "include the first line of each declaration")
+	fieldsFlag      = flag.Bool("fields", false, "also report the fields of struct types, with the keys of their tags")
+	packageDocsFlag = flag.Bool("package-docs", false, "also report packages with the first sentence of their package comment, matching queries by its words")
+	outputDir       = flag.String("output-dir", "", "write a json file per package to `dir`")
+	jobsFlag        = flag.Int("j", runtime.NumCPU(), "parse at most `n` packages in parallel")
+	limitFlag       = flag.Int("limit", 0, "stop once `n` symbols have been found (0 means no limit)")
+	maxSymbolsFlag  = flag.Int("max-symbols", 0, "stop once more than `n` symbols have been found, writing n marked truncated (0 means no limit)")
+	timeoutFlag     = flag.Duration("timeout", 0, "stop scanning after `duration`, writing the symbols found so far")
+	pkgTimeoutFlag  = flag.Duration("package-timeout", 0, "skip packages taking longer than `duration` to read and parse (0 means no limit)")
+	fastFlag        = flag.Bool("fast", false, "find declarations by tokenizing files instead of parsing function bodies")
+	ignoreLinesFlag = flag.Bool("ignore-line-directives", false, "report positions as they are in the files, not as //line directives map them")
+	depsFlag        = flag.Bool("deps", false, "also scan the modules the scanned module requires, from the module cache")
+	packagesFlag    = flag.Bool("packages", false, "list packages with the go command, following modules and build constraints, instead of walking the tree")
+	modifiedFlag    = flag.Bool("modified", false, "read an archive of modified files from standard input, used instead of those on disk")
+	onlyPrefix      listFlag
+)
 
 func init() {
 	flag.Var((*buildutil.TagsFlag)(&build.Default.BuildTags), "tags", buildutil.TagsFlagDoc)
+	flag.Var(&onlyPrefix, "only-prefix", "comma-separated `prefixes`; only import paths starting with one of them are read")
 }
 
-func main() {
-	if err := doMain(); err != nil {
-		fmt.Fprintf(os.Stderr, "go-symbols: %s\n", err)
-		os.Exit(1)
-	}
-}
+// listFlag is a flag.Value holding a comma-separated list of strings.
+type listFlag []string
 
-type symbol struct {
-	Name      string `json:"name"`
-	Kind      string `json:"kind"`
-	Package   string `json:"package"`
-	Path      string `json:"path"`
-	Line      int    `json:"line"`
-	Character int    `json:"character"`
-}
-
-var mutex sync.Mutex
-var syms = make([]symbol, 0)
+func (l *listFlag) String() string { return strings.Join(*l, ",") }
 
-type visitor struct {
-	pkg   *ast.Package
-	fset  *token.FileSet
-	query string
-	syms  []symbol
+func (l *listFlag) Set(s string) error {
+	*l = nil
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			*l = append(*l, e)
+		}
+	}
+	return nil
 }
 
-func (v *visitor) Visit(node ast.Node) bool {
-	descend := true
-
-	var ident *ast.Ident
-	var kind string
-	switch t := node.(type) {
-	case *ast.FuncDecl:
-		kind = "func"
-		ident = t.Name
-		descend = false
-
-	case *ast.TypeSpec:
-		kind = "type"
-		ident = t.Name
-		descend = false
-	}
-
-	if ident != nil && strings.Contains(strings.ToLower(ident.Name), v.query) {
-		f := v.fset.File(ident.Pos())
-		v.syms = append(v.syms, symbol{
-			Package: v.pkg.Name,
-			Path:    f.Name(),
-			Name:    ident.Name,
-			Kind:    kind,
-			Line:    f.Line(ident.Pos()) - 1,
-		})
+func main() {
+	cmd, args, err := parseCommand()
+	if err == nil {
+		err = doMain(cmd, args)
 	}
-
-	return descend
+	exit(cmd, err)
 }
 
-var	haveSrcDir = true
-
-func forEachPackage(ctxt *build.Context, found func(importPath string, err error)) {
-	// We use a counting semaphore to limit
-	// the number of parallel calls to ReadDir.
-	sema := make(chan bool, 20)
+// symbol, scanError and scanStats are the library's types, which the
+// output formats use.
+type (
+	symbol    = symbols.Symbol
+	scanError = symbols.Error
+	scanSkip  = symbols.Skip
+	scanStats = symbols.Stats
+)
 
-	ch := make(chan item)
+// parseDocs is set when the output includes doc comments, which are
+// otherwise not parsed.
+var parseDocs bool
 
-	var srcDirs []string
-	if haveSrcDir {
-		srcDirs	= ctxt.SrcDirs()
-	} else {
-		srcDirs = append(srcDirs, ctxt.GOPATH)
-	}
-	
-	var wg sync.WaitGroup
-	for _, root := range srcDirs {
-		root := root
-		wg.Add(1)
-		go func() {
-			allPackages(ctxt, sema, root, ch)
-			wg.Done()
-		}()
+func doMain(cmd *command, args []string) error {
+	if err := loadConfig(cmd, configRoot(args)); err != nil {
+		return err
+	}
+	if err := startLogging(); err != nil {
+		return err
+	}
+	if *jobsFlag < 1 {
+		return usagef("-j must be at least 1")
+	}
+	if *limitFlag < 0 {
+		return usagef("-limit must not be negative")
+	}
+	if *maxSymbolsFlag < 0 {
+		return usagef("-max-symbols must not be negative")
+	}
+	if *symlinksFlag != "" && *symlinksFlag != "resolve" && *symlinksFlag != "preserve" {
+		return usagef("unknown -symlinks mode %q", *symlinksFlag)
 	}
-	go func() {
-		wg.Wait()
-		close(ch)
-	}()
 
-	// All calls to found occur in the caller's goroutine.
-	for i := range ch {
-		found(i.importPath, i.err)
+	stopProfiling, err := startProfiling()
+	if err != nil {
+		stopProfiling()
+		return err
+	}
+	err = cmd.run(args)
+	if perr := stopProfiling(); err == nil {
+		err = perr
 	}
+	return err
 }
 
-type item struct {
-	importPath string
-	err        error // (optional)
-}
+// overlay holds the files read with -modified.
+var overlay map[string][]byte
 
-func allPackages(ctxt *build.Context, sema chan bool, root string, ch chan<- item) {
-	root = filepath.Clean(root) + string(os.PathSeparator)
+// workspaceRoot is the resolved directory being scanned, against which
+// formats that need one compute relative paths.
+var workspaceRoot string
 
-	var wg sync.WaitGroup
+// givenRoots are the directories being scanned as they were given, whose
+// spelling -symlinks preserve keeps.
+var givenRoots []string
 
-	var walkDir func(dir string)
-	walkDir = func(dir string) {
-		// Avoid .foo, _foo, and testdata directory trees.
-		base := filepath.Base(dir)
-		if base == "" || base[0] == '.' || base[0] == '_' || base == "testdata" {
-			return
+// setWorkspace sets workspaceRoot and givenRoots from dir, a list of
+// directories separated as in GOPATH.
+func setWorkspace(dir string) {
+	givenRoots = nil
+	for _, d := range filepath.SplitList(dir) {
+		if d != "" {
+			givenRoots = append(givenRoots, filepath.Clean(d))
 		}
+	}
+	workspaceRoot = symbols.CanonicalDir(filepath.SplitList(dir)[0])
+}
 
-		pkg := filepath.ToSlash(strings.TrimPrefix(dir, root))
+// parseArgs returns the directory and lower-cased query from the positional
+// arguments, exiting with the usage message if there is no directory.
+func parseArgs(args []string) (dir, query string) {
+	if len(args) < 1 || args[0] == "" {
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+	dir = args[0]
+	setWorkspace(dir)
+	if len(args) > 1 {
+		query = args[1]
+	}
+	return dir, strings.ToLower(query)
+}
 
-		// Prune search if we encounter any of these import paths.
-		switch pkg {
-		case "builtin":
-			return
-		}
+// A symbolSource calls found with batches of the symbols in dir matching
+// query. Calls to found are serialized. Sources stop early, with possibly
+// some more calls to found, once ctx is canceled.
+type symbolSource func(ctx context.Context, dir, query string, found func([]symbol)) *scanSummary
 
-		sema <- true
-		files, err := ioutil.ReadDir(dir)
-		<-sema
-		if pkg != "" || err != nil {
-			ch <- item{pkg, err}
+// search implements the default command, which scans the source tree.
+func search(args []string) error {
+	if *modifiedFlag {
+		if *queriesFile == "-" {
+			return usagef("-modified and -queries-file - both read standard input")
 		}
-		for _, fi := range files {
-			fi := fi
-			if fi.IsDir() {
-				wg.Add(1)
-				go func() {
-					walkDir(filepath.Join(dir, fi.Name()))
-					wg.Done()
-				}()
-			}
+		var err error
+		if overlay, err = buildutil.ParseOverlayArchive(os.Stdin); err != nil {
+			return fmt.Errorf("reading -modified archive: %v", err)
 		}
 	}
-
-	walkDir(root)
-	wg.Wait()
+	if *prefilterFlag != "" && *prefilterFlag != "rg" {
+		return usagef("unknown -prefilter %q (want rg)", *prefilterFlag)
+	}
+	source := scan
+	if *parseCacheFlag {
+		source = cachedScan
+	}
+	if *withImplementsFlag {
+		source = typeChecked(source, symbols.Implements)
+	}
+	if *stdlibFlag {
+		goroot, err := stdlibRoot()
+		if err != nil {
+			return err
+		}
+		source = withStdlib(goroot, source)
+	}
+	return searchSource(source, args)
 }
 
-func doMain() error {
-	flag.Parse()
-	runtime.GOMAXPROCS(runtime.NumCPU())
+// searchSource writes the symbols from source matching the query in args in
+// the output format selected by the flags.
+func searchSource(source symbolSource, args []string) error {
+	dir, query := parseArgs(args)
 
-	args := flag.Args()
+	format, err := lookupFormat(*formatFlag)
+	if err != nil {
+		return err
+	}
+	streaming := streamingFormats[*formatFlag]
+	if *templateFlag != "" {
+		if format, err = templateFormat(*templateFlag); err != nil {
+			return err
+		}
+		streaming = true
+	}
+	if *gorootFlag != "" && !*stdlibFlag {
+		return usagef("-goroot requires -stdlib")
+	}
+	if (*typedFlag || *refsFlag || *promotedFlag) && *remoteFlag != "" {
+		return usagef("-typed, -refs and -promoted cannot be combined with -remote, whose server reports what it holds")
+	}
+	if *promotedFlag {
+		source = withPromoted(source)
+	}
+	if *typedFlag {
+		source = typeChecked(source, symbols.Typecheck)
+	}
+	if *refsFlag {
+		source = typeChecked(source, symbols.CountReferences)
+	}
+	if *envelopeFlag && *formatFlag != "json" {
+		return usagef("-envelope is only supported with -format json")
+	}
+	switch *groupBy {
+	case "":
+	case "package":
+		if *formatFlag != "json" {
+			return usagef("-group-by is only supported with -format json")
+		}
+		format, streaming = writeGroupedJSON, false
+	default:
+		return usagef("unknown -group-by key %q", *groupBy)
+	}
+	parseDocs = docFormats[*formatFlag]
+	if colors, err = useColor(); err != nil {
+		return err
+	}
+	highlight = query
+	if *uriFlag && *relativeTo != "" {
+		return usagef("-uri and -relative-to are mutually exclusive")
+	}
+	queries, err := readQueries()
+	if err != nil {
+		return err
+	}
+	if len(queries) > 0 {
+		if query != "" {
+			return usagef("a query and -queries or -queries-file are mutually exclusive")
+		}
+		if *formatFlag != "json" || *templateFlag != "" || *groupBy != "" || *outputDir != "" {
+			return usagef("-queries writes json grouped by query and excludes -format, -format-template, -group-by and -output-dir")
+		}
+	}
 
-	if len(args) < 1 {
-		fmt.Fprint(os.Stderr, usage)
-		os.Exit(1)
+	order := *sortFlag
+	if order == "" {
+		order = "path"
+		if streaming {
+			order = "none"
+		}
+	}
+	less, ok := symbolOrders[order]
+	if !ok {
+		return usagef("unknown sort order %q", order)
+	}
+	if less, err = collated(order, less); err != nil {
+		return err
 	}
 
-	dir := args[0]
-	var query string
-	if len(args) > 1 {
-		query = args[1]
+	if maxMemory > 0 && !streaming && (*formatFlag != "json" || *envelopeFlag || *groupBy != "") {
+		return usagef("-max-memory requires plain json or a streaming format")
 	}
-	query = strings.ToLower(query)
 
-	ctxt := build.Default // copy
-	ctxt.GOPATH = dir     // disable GOPATH
-	ctxt.GOROOT = ""
+	// Stopping the scan at the deadline or when interrupted leaves the
+	// results incomplete. A second interrupt kills the process.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	if *timeoutFlag > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeoutFlag)
+		defer cancel()
+	}
 
-	fset := token.NewFileSet()
-	sema := make(chan int, 8) // concurrency-limiting semaphore
-	var wg sync.WaitGroup
+	if *outputDir != "" {
+		if *formatFlag != "json" || *envelopeFlag || *groupBy != "" || *outputFlag != "" {
+			return usagef("-output-dir writes plain json and excludes -format, -envelope, -group-by and -o")
+		}
+		shards := func(_ io.Writer, syms []symbol) error {
+			return writeShards(*outputDir, syms)
+		}
+		return writeResults(ctx, ioutil.Discard, source, dir, query, shards, false, less)
+	}
 
-	if _, err := os.Stat(filepath.Join(dir, "src")); err != nil {
-		haveSrcDir = false
+	out, commit, err := createOutput()
+	if err != nil {
+		return err
 	}
-	
-	// Here we can't use buildutil.ForEachPackage here since it only considers
-	// src dirs and this tool should be able to run against a golang source dir.
-	forEachPackage(&ctxt, func(path string, err error) {
-		if path == "" {
-			return
+	w, closeOutput, err := openOutput(out)
+	if err != nil {
+		return commit(err)
+	}
+	if len(queries) > 0 {
+		err = writeBatch(ctx, w, source, dir, queries, less)
+		if cerr := closeOutput(); err == nil {
+			err = cerr
 		}
-		wg.Add(1)
-		go func() {
-			sema <- 1 // acquire token
-			defer func() {
-				<-sema // release token
-			}()
+		return commit(err)
+	}
+
+	// Unsorted json is written as it is found, rather than held in memory,
+	// as is sorted json that may be spilled to disk.
+	var array *jsonArrayWriter
+	if *formatFlag == "json" && *templateFlag == "" && *groupBy == "" && !*envelopeFlag && (less == nil || maxMemory > 0) {
+		array = new(jsonArrayWriter)
+		format, streaming = array.write, true
+	}
+	err = writeResults(ctx, w, source, dir, query, format, streaming, less)
+	if array != nil && err == nil {
+		err = array.close(w)
+	}
+	if cerr := closeOutput(); err == nil {
+		err = cerr
+	}
+	return commit(err)
+}
 
-			v := &visitor{
-				fset:  fset,
-				query: query,
+// writeResults writes the symbols from source matching query to w, as they
+// are found if the format is streaming and no order is requested. With
+// -limit, the source is stopped once enough symbols were found. If ctx is
+// done before the source finishes, the symbols found so far are written and
+// marked incomplete.
+func writeResults(ctx context.Context, w io.Writer, source symbolSource, dir, query string, format formatter, streaming bool, less func(a, b symbol) bool) error {
+	start := time.Now()
+	defer func() {
+		if ctx.Err() != nil && !*envelopeFlag {
+			why := "interrupted"
+			if ctx.Err() == context.DeadlineExceeded {
+				why = "timed out"
 			}
-			defer func() {
-				mutex.Lock()
-				syms = append(syms, v.syms...)
-				mutex.Unlock()
-			}()
-
-			defer wg.Done()
-			
-			if haveSrcDir {
-				path = filepath.Join(dir, "src", path)
-			} else {
-				path = filepath.Join(dir, path)
+			logger.Warn("scan " + why + "; results are incomplete")
+		}
+	}()
+	sourceCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	dedup := newDeduper()
+	remaining := *limitFlag
+	var taken int
+	var truncated bool // at -max-symbols
+	take := func(found []symbol) []symbol {
+		found = dedup.filter(found)
+		if *limitFlag > 0 {
+			if len(found) >= remaining {
+				found = found[:remaining]
+				cancel()
 			}
+			remaining -= len(found)
+		}
+		if *maxSymbolsFlag > 0 && taken+len(found) > *maxSymbolsFlag {
+			found = found[:*maxSymbolsFlag-taken]
+			truncated = true
+			cancel()
+		}
+		taken += len(found)
+		return found
+	}
+	defer func() {
+		if truncated {
+			partial = true
+			logger.Warn("more symbols than -max-symbols were found; results are truncated", "max-symbols", *maxSymbolsFlag)
+		}
+	}()
+	if less == nil && streaming {
+		var streamErr error
+		var count int
+		sum := source(sourceCtx, dir, query, func(found []symbol) {
+			found = take(found)
+			count += len(found)
+			rewritePaths(found)
+			if err := format(w, found); err != nil && streamErr == nil {
+				streamErr = err
+			}
+		})
+		sum.stats.Symbols = count
+		notePartial(ctx, sum)
+		finishStats(&sum.stats, start, time.Time{})
+		printStats(&sum.stats)
+		return streamErr
+	}
 
-			parsed, _ := parser.ParseDir(fset, path, nil, 0)
-			// Ignore any errors, they are irrelevant for symbol search.
+	if streaming && maxMemory > 0 {
+		return writeSpilled(sourceCtx, w, source, dir, query, format, less, take)
+	}
 
-			for _, astpkg := range parsed {
-				v.pkg = astpkg
-				for _, f := range astpkg.Files {
-					ast.Inspect(f, v.Visit)
-				}
-			}
-		}()
+	syms := make([]symbol, 0)
+	sum := source(sourceCtx, dir, query, func(found []symbol) {
+		syms = append(syms, take(found)...)
 	})
-	wg.Wait()
+	if less != nil {
+		sort.Slice(syms, func(i, j int) bool { return less(syms[i], syms[j]) })
+	}
+	rewritePaths(syms)
+	sum.stats.Symbols = len(syms)
+	notePartial(ctx, sum)
+
+	outputStart := time.Now()
+	var err error
+	if *envelopeFlag {
+		var v interface{} = syms
+		maxSymbols := 0
+		if truncated {
+			maxSymbols = *maxSymbolsFlag
+		}
+		if *groupBy == "package" {
+			v = groupByPackage(syms)
+		}
+		var stats *scanStats
+		if *statsFlag {
+			finishStats(&sum.stats, start, time.Time{})
+			stats = &sum.stats
+		}
+		err = writeEnvelope(w, envelope{
+			Scope:      scope{Dir: dir, Query: query},
+			Errors:     sum.errors,
+			Skipped:    sum.skipped,
+			Stats:      stats,
+			Incomplete: ctx.Err() != nil,
+			Truncated:  truncated,
+			MaxSymbols: maxSymbols,
+			Symbols:    v,
+		})
+	} else {
+		err = format(w, syms)
+	}
+	finishStats(&sum.stats, start, outputStart)
+	printStats(&sum.stats)
+	return err
+}
 
-	b, _ := json.MarshalIndent(syms, "", " ")
-	fmt.Println(string(b))
+// A scanSummary describes a completed scan.
+type scanSummary struct {
+	errors  []scanError
+	skipped []scanSkip
+	stats   scanStats
+}
 
-	return nil
+// scan is the symbolSource walking the source tree rooted at dir, with the
+// options given by the flags.
+func scan(ctx context.Context, dir, query string, found func([]symbol)) *scanSummary {
+	return scanCached(ctx, dir, query, nil, found)
+}
+
+// scanCached scans like scan, but consults cache, if not nil, before
+// parsing each file and records in it all symbols of every file it parses,
+// whatever the query.
+func scanCached(ctx context.Context, dir, query string, cache *fileSymbolCache, found func([]symbol)) *scanSummary {
+	opts := symbols.Options{
+		Dir:         dir,
+		Query:       query,
+		PackageName: *pkgNameFlag,
+		OnlyPrefix:  onlyPrefix,
+		WithSource:  *withSource,
+		Docs:        parseDocs,
+		Fast:        *fastFlag,
+		Packages:    *packagesFlag,
+		Deps:        *depsFlag,
+		Slowest:     *slowFlag,
+		Overlay:     overlay,
+
+		IgnoreLineDirectives: *ignoreLinesFlag,
+	}
+	if flagSet("j") {
+		opts.Jobs = *jobsFlag
+	}
+	if cache != nil {
+		opts.Cache = cache
+	}
+	if *fieldsFlag {
+		opts.Extractors = append(opts.Extractors, symbols.StructFields{})
+	}
+	if *packageDocsFlag {
+		opts.Extractors = append(opts.Extractors, symbols.PackageDocs{})
+	}
+	// The module cache, scanned with -deps, is not below dir.
+	if *prefilterFlag == "rg" && query != "" && !*depsFlag {
+		opts.Candidates, _ = ripgrepCandidates(ctx, dir, query)
+	}
+	opts.Progress = scanProgress(ctx, dir)
+	opts.PackageTimeout = *pkgTimeoutFlag
+	sum := symbols.Scan(ctx, opts, found)
+	for _, s := range sum.Skipped {
+		if s.TimedOut {
+			logger.Warn("skipped a package taking too long", "dir", s.Dir, "timeout", *pkgTimeoutFlag)
+		}
+	}
+	return &scanSummary{errors: sum.Errors, skipped: sum.Skipped, stats: sum.Stats}
 }
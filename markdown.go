@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"go/ast"
+	"io"
+	"sort"
+)
+
+// writeMarkdown writes the exported symbols as a Markdown API reference
+// with a section per package, listing each type with its methods followed
+// by the package's functions.
+func writeMarkdown(w io.Writer, syms []symbol) error {
+	byPkg := make(map[string][]symbol)
+	names := make(map[string]string)
+	var pkgs []string
+	for _, s := range syms {
+		if !ast.IsExported(s.Name) || s.Receiver != "" && !ast.IsExported(receiverType(s.Receiver)) {
+			continue
+		}
+		key := s.ImportPath
+		if key == "" {
+			key = s.Package
+		}
+		if _, ok := byPkg[key]; !ok {
+			pkgs = append(pkgs, key)
+			names[key] = s.Package
+		}
+		byPkg[key] = append(byPkg[key], s)
+	}
+	sort.Strings(pkgs)
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "# API reference")
+	for _, pkg := range pkgs {
+		psyms := byPkg[pkg]
+		sort.SliceStable(psyms, func(i, j int) bool { return psyms[i].Name < psyms[j].Name })
+
+		fmt.Fprintf(bw, "\n## %s\n\n", pkg)
+		fmt.Fprintf(bw, "```go\nimport %q // package %s\n```\n", pkg, names[pkg])
+
+		methods := make(map[string][]symbol)
+		for _, s := range psyms {
+			if s.Receiver != "" {
+				recv := receiverType(s.Receiver)
+				methods[recv] = append(methods[recv], s)
+			}
+		}
+		for _, s := range psyms {
+			if s.Kind != "type" {
+				continue
+			}
+			writeMarkdownSymbol(bw, "###", s)
+			for _, m := range methods[s.Name] {
+				writeMarkdownSymbol(bw, "####", m)
+			}
+		}
+		for _, s := range psyms {
+			if s.Kind == "func" && s.Receiver == "" {
+				writeMarkdownSymbol(bw, "###", s)
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+func writeMarkdownSymbol(w io.Writer, heading string, s symbol) {
+	title := s.Name
+	if s.Receiver != "" {
+		title = receiverType(s.Receiver) + "." + s.Name
+	}
+	fmt.Fprintf(w, "\n%s %s %s\n\n", heading, s.Kind, title)
+	fmt.Fprintf(w, "```go\n%s\n```\n", declString(s))
+	if s.Doc != "" {
+		fmt.Fprintf(w, "\n%s\n", s.Doc)
+	}
+	fmt.Fprintf(w, "\n`%s:%d`\n", s.Path, s.Line+1)
+}
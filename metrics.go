@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// queryBuckets are the upper bounds, in seconds, of the buckets of the
+// query latency histogram.
+var queryBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5}
+
+// A histogram counts observations by bucket, as a Prometheus histogram.
+type histogram struct {
+	bounds []float64
+	counts []int64 // by bucket, the last for those above all bounds
+	sum    float64
+	n      int64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]int64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(v float64) {
+	h.counts[sort.SearchFloat64s(h.bounds, v)]++
+	h.sum += v
+	h.n++
+}
+
+// metrics counts the work done by the serve command, for /metrics.
+var metrics = struct {
+	mu          sync.Mutex
+	queries     *histogram
+	loads       int64
+	watchEvents map[string]int64 // by operation
+	cacheReused int64            // files whose symbols loads reused
+	cacheParsed int64            // files loads parsed
+}{
+	queries:     newHistogram(queryBuckets),
+	watchEvents: make(map[string]int64),
+}
+
+// observeQuery records a query answered in d.
+func observeQuery(d time.Duration) {
+	metrics.mu.Lock()
+	metrics.queries.observe(d.Seconds())
+	metrics.mu.Unlock()
+}
+
+// observeLoad records a load of a workspace and the use it made of the
+// symbols of the previous one.
+func observeLoad(cache *fileSymbolCache) {
+	metrics.mu.Lock()
+	metrics.loads++
+	metrics.cacheReused += int64(cache.reused)
+	metrics.cacheParsed += int64(cache.parsed)
+	metrics.mu.Unlock()
+}
+
+// watchOps are the operations of watch events, by metric label.
+var watchOps = []struct {
+	label string
+	op    fsnotify.Op
+}{
+	{"create", fsnotify.Create},
+	{"write", fsnotify.Write},
+	{"remove", fsnotify.Remove},
+	{"rename", fsnotify.Rename},
+	{"chmod", fsnotify.Chmod},
+}
+
+// observeWatchEvent records an event of the watcher of a workspace.
+func observeWatchEvent(ev fsnotify.Event) {
+	metrics.mu.Lock()
+	for _, o := range watchOps {
+		if ev.Has(o.op) {
+			metrics.watchEvents[o.label]++
+		}
+	}
+	metrics.mu.Unlock()
+}
+
+// handleMetrics answers with the metrics of the serve command, and the
+// size of each workspace the request may access, in the Prometheus text
+// format.
+func (ws *workspaceSet) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	g := requestGrant(r.Context())
+	list := []serverStatus{}
+	for _, st := range ws.list() {
+		if g.allows(st.Dir) {
+			list = append(list, st)
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writeMetrics(w, list)
+}
+
+func writeMetrics(w io.Writer, list []serverStatus) {
+	metric := func(name, typ, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+
+	h := metrics.queries
+	metric("gosymbols_query_duration_seconds", "histogram", "Time taken to answer queries.")
+	var cumulative int64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "gosymbols_query_duration_seconds_bucket{le=\"%s\"} %d\n", promFloat(bound), cumulative)
+	}
+	fmt.Fprintf(w, "gosymbols_query_duration_seconds_bucket{le=\"+Inf\"} %d\n", h.n)
+	fmt.Fprintf(w, "gosymbols_query_duration_seconds_sum %s\n", promFloat(h.sum))
+	fmt.Fprintf(w, "gosymbols_query_duration_seconds_count %d\n", h.n)
+
+	metric("gosymbols_loads_total", "counter", "Scans of workspaces, initial or after changes.")
+	fmt.Fprintf(w, "gosymbols_loads_total %d\n", metrics.loads)
+
+	metric("gosymbols_file_cache_lookups_total", "counter", "Files scanned by loads, by whether the symbols of the previous load were reused.")
+	fmt.Fprintf(w, "gosymbols_file_cache_lookups_total{result=\"hit\"} %d\n", metrics.cacheReused)
+	fmt.Fprintf(w, "gosymbols_file_cache_lookups_total{result=\"miss\"} %d\n", metrics.cacheParsed)
+
+	metric("gosymbols_watch_events_total", "counter", "File system events seen by the watchers of workspaces, by operation.")
+	for _, o := range watchOps {
+		fmt.Fprintf(w, "gosymbols_watch_events_total{op=%q} %d\n", o.label, metrics.watchEvents[o.label])
+	}
+
+	metric("gosymbols_workspaces", "gauge", "Workspaces registered.")
+	fmt.Fprintf(w, "gosymbols_workspaces %d\n", len(list))
+	gauges := []struct {
+		name, help string
+		value      func(st serverStatus) string
+	}{
+		{"gosymbols_workspace_files", "Files held by the index of a workspace.", func(st serverStatus) string { return strconv.Itoa(st.Files) }},
+		{"gosymbols_workspace_symbols", "Symbols held by the index of a workspace.", func(st serverStatus) string { return strconv.Itoa(st.Symbols) }},
+		{"gosymbols_workspace_errors", "Packages of a workspace that could not be read or parsed.", func(st serverStatus) string { return strconv.Itoa(st.Errors) }},
+		{"gosymbols_workspace_load_duration_seconds", "Time taken by the last load of a workspace.", func(st serverStatus) string { return promFloat(st.LoadMs / 1000) }},
+		{"gosymbols_workspace_loaded_timestamp_seconds", "When a workspace was last loaded.", func(st serverStatus) string {
+			return promFloat(float64(st.LoadedAt.UnixNano()) / 1e9)
+		}},
+	}
+	for _, g := range gauges {
+		metric(g.name, "gauge", g.help)
+		for _, st := range list {
+			fmt.Fprintf(w, "%s{workspace=\"%s\"} %s\n", g.name, promLabel.Replace(st.Dir), g.value(st))
+		}
+	}
+}
+
+// promLabel escapes label values.
+var promLabel = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func promFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
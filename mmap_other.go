@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "io/ioutil"
+
+func mapFile(path string) ([]byte, func(), error) {
+	data, err := ioutil.ReadFile(path)
+	return data, func() {}, err
+}
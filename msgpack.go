@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+
+	"github.com/newhook/go-symbols/symbols"
+)
+
+// writeMsgpack writes syms as a MessagePack array of maps with the same
+// keys as the JSON output.
+func writeMsgpack(w io.Writer, syms []symbol) error {
+	bw := bufio.NewWriter(w)
+	var b []byte
+	b = appendMsgpackArrayHeader(b, len(syms))
+	for _, s := range syms {
+		var kind interface{} = s.Kind
+		if *kindFormatFlag == "lsp" {
+			kind = lspSymbolKind(s)
+		}
+		fields := []struct {
+			key   string
+			value interface{}
+		}{
+			{"name", s.Name},
+			{"kind", kind},
+			{"package", s.Package},
+			{"importPath", s.ImportPath},
+			{"vendored", s.Vendored},
+			{"path", s.Path},
+			{"line", s.Line},
+			{"character", s.Character},
+			{"receiver", s.Receiver},
+			{"signature", s.Signature},
+			{"summary", s.Summary},
+			{"module", s.Module},
+			{"build", s.Build},
+			{"object", s.Object},
+			{"type", s.Type},
+			{"definedIn", s.DefinedIn},
+			{"aliased", s.Aliased},
+			{"typeParams", s.TypeParams},
+			{"importable", s.Importable},
+			{"references", s.References},
+			{"implements", s.Implements},
+			{"implementedBy", s.ImplementedBy},
+			{"tags", s.Tags},
+			{"promoted", s.Promoted},
+		}
+		n := 0
+		for _, f := range fields {
+			if list, ok := f.value.([]string); ok && len(list) == 0 {
+				continue
+			}
+			if tags, ok := f.value.(map[string]string); ok && len(tags) == 0 {
+				continue
+			}
+			if tparams, ok := f.value.([]symbols.TypeParam); ok && len(tparams) == 0 {
+				continue
+			}
+			if f.value != "" && f.value != (*int)(nil) && f.value != (*bool)(nil) && f.value != false {
+				n++
+			}
+		}
+		b = appendMsgpackMapHeader(b, n)
+		for _, f := range fields {
+			switch v := f.value.(type) {
+			case string:
+				if v == "" {
+					continue
+				}
+				b = appendMsgpackString(b, f.key)
+				b = appendMsgpackString(b, v)
+			case int:
+				b = appendMsgpackString(b, f.key)
+				b = appendMsgpackInt(b, int64(v))
+			case map[string]string:
+				if len(v) == 0 {
+					continue
+				}
+				b = appendMsgpackString(b, f.key)
+				b = appendMsgpackMapHeader(b, len(v))
+				for _, key := range sortedTagKeys(v) {
+					b = appendMsgpackString(b, key)
+					b = appendMsgpackString(b, v[key])
+				}
+			case bool:
+				if !v {
+					continue
+				}
+				b = appendMsgpackString(b, f.key)
+				b = append(b, 0xc3)
+			case *bool:
+				if v == nil {
+					continue
+				}
+				b = appendMsgpackString(b, f.key)
+				if *v {
+					b = append(b, 0xc3)
+				} else {
+					b = append(b, 0xc2)
+				}
+			case *int:
+				if v == nil {
+					continue
+				}
+				b = appendMsgpackString(b, f.key)
+				b = appendMsgpackInt(b, int64(*v))
+			case []string:
+				if len(v) == 0 {
+					continue
+				}
+				b = appendMsgpackString(b, f.key)
+				b = appendMsgpackArrayHeader(b, len(v))
+				for _, s := range v {
+					b = appendMsgpackString(b, s)
+				}
+			case []symbols.TypeParam:
+				if len(v) == 0 {
+					continue
+				}
+				b = appendMsgpackString(b, f.key)
+				b = appendMsgpackArrayHeader(b, len(v))
+				for _, tp := range v {
+					n := 2
+					if len(tp.Constraints) > 0 {
+						n++
+					}
+					b = appendMsgpackMapHeader(b, n)
+					b = appendMsgpackString(b, "name")
+					b = appendMsgpackString(b, tp.Name)
+					b = appendMsgpackString(b, "constraint")
+					b = appendMsgpackString(b, tp.Constraint)
+					if len(tp.Constraints) > 0 {
+						b = appendMsgpackString(b, "constraints")
+						b = appendMsgpackArrayHeader(b, len(tp.Constraints))
+						for _, name := range tp.Constraints {
+							b = appendMsgpackString(b, name)
+						}
+					}
+				}
+			}
+		}
+		if len(b) > 4096 {
+			bw.Write(b)
+			b = b[:0]
+		}
+	}
+	bw.Write(b)
+	return bw.Flush()
+}
+
+func appendMsgpackArrayHeader(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x90|byte(n))
+	case n < 1<<16:
+		return binary.BigEndian.AppendUint16(append(b, 0xdc), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(b, 0xdd), uint32(n))
+}
+
+func appendMsgpackMapHeader(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x80|byte(n))
+	case n < 1<<16:
+		return binary.BigEndian.AppendUint16(append(b, 0xde), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(b, 0xdf), uint32(n))
+}
+
+func appendMsgpackString(b []byte, s string) []byte {
+	switch n := len(s); {
+	case n < 32:
+		b = append(b, 0xa0|byte(n))
+	case n < 1<<8:
+		b = append(b, 0xd9, byte(n))
+	case n < 1<<16:
+		b = binary.BigEndian.AppendUint16(append(b, 0xda), uint16(n))
+	default:
+		b = binary.BigEndian.AppendUint32(append(b, 0xdb), uint32(n))
+	}
+	return append(b, s...)
+}
+
+func appendMsgpackInt(b []byte, v int64) []byte {
+	switch {
+	case v >= 0 && v < 128:
+		return append(b, byte(v))
+	case v >= -32 && v < 0:
+		return append(b, byte(v))
+	case v >= 0 && v < 1<<16:
+		return binary.BigEndian.AppendUint16(append(b, 0xcd), uint16(v))
+	case v >= 0 && v < 1<<32:
+		return binary.BigEndian.AppendUint32(append(b, 0xce), uint32(v))
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xd3), uint64(v))
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/newhook/go-symbols/symbols"
+	"golang.org/x/tools/go/buildutil"
+)
+
+// runOutline implements the outline command, which writes the symbols
+// declared in a file in the order they appear. With -modified, the file is
+// read from the archive on standard input if it is there.
+func runOutline(args []string) error {
+	if len(args) != 1 {
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+	filename := args[0]
+	setWorkspace(filepath.Dir(filename))
+	format, err := outputFormat()
+	if err != nil {
+		return err
+	}
+
+	var src []byte
+	if *modifiedFlag {
+		archive, err := buildutil.ParseOverlayArchive(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("reading -modified archive: %v", err)
+		}
+		abs, _ := filepath.Abs(filename)
+		for name, contents := range archive {
+			if a, _ := filepath.Abs(name); a == abs {
+				src = contents
+			}
+		}
+	}
+	syms, err := parseFile(filename, src)
+	if err != nil {
+		return err
+	}
+	rewritePaths(syms)
+	return format(os.Stdout, syms)
+}
+
+// parseFile returns the symbols declared in the named file, whose contents
+// are src or, if src is nil, read from the file, in the order they appear.
+// Syntax errors are logged, leaving out the declarations that could not be
+// parsed.
+func parseFile(filename string, src []byte) ([]symbol, error) {
+	opts := symbols.Options{
+		WithSource: *withSource,
+		Docs:       parseDocs,
+		Fast:       *fastFlag,
+
+		IgnoreLineDirectives: *ignoreLinesFlag,
+	}
+	if *fieldsFlag {
+		opts.Extractors = append(opts.Extractors, symbols.StructFields{})
+	}
+	syms, err := symbols.File(filename, src, opts)
+	if err != nil {
+		if syms == nil {
+			return nil, err
+		}
+		logger.Warn("the symbols of the file are incomplete", "err", err)
+		partial = true
+	}
+	sort.Slice(syms, func(i, j int) bool { return syms[i].Offset < syms[j].Offset })
+	return syms, nil
+}
+
+// outputFormat returns the formatter selected by -format or
+// -format-template for commands writing symbols other than searches.
+func outputFormat() (formatter, error) {
+	if *uriFlag && *relativeTo != "" {
+		return nil, usagef("-uri and -relative-to are mutually exclusive")
+	}
+	var err error
+	if colors, err = useColor(); err != nil {
+		return nil, err
+	}
+	parseDocs = docFormats[*formatFlag]
+	if *templateFlag != "" {
+		return templateFormat(*templateFlag)
+	}
+	return lookupFormat(*formatFlag)
+}
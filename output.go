@@ -0,0 +1,716 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/newhook/go-symbols/symbols"
+)
+
+// A formatter writes a set of symbols to w in one output format.
+type formatter func(w io.Writer, syms []symbol) error
+
+// formats maps the -format names to their formatters.
+var formats = map[string]formatter{
+	"json":       writeJSON,
+	"ndjson":     writeNDJSON,
+	"ctags":      writeCtags,
+	"ctags-json": writeCtagsJSON,
+	"etags":      writeEtags,
+	"cscope":     writeCscope,
+	"scip":       writeSCIP,
+	"kythe":      writeKythe,
+	"zoekt":      writeZoekt,
+	"csv":        writeCSV(','),
+	"tsv":        writeCSV('\t'),
+	"plain":      writePlain,
+	"lsp":        writeLSP,
+	"table":      writeTable,
+	"proto":      writeProto,
+	"msgpack":    writeMsgpack,
+	"imenu":      writeImenu,
+	"fzf":        writeFzf,
+	"markdown":   writeMarkdown,
+	"quickfix":   writeQuickfix,
+	"acme":       writeAcme,
+}
+
+// docFormats are the formats that include doc comments.
+var docFormats = map[string]bool{
+	"markdown": true,
+}
+
+// streamingFormats are the formats whose output for a set of symbols is
+// simply the concatenation of the output for each subset, so symbols can be
+// written as soon as each package has been scanned.
+var streamingFormats = map[string]bool{
+	"ndjson":     true,
+	"plain":      true,
+	"proto":      true,
+	"fzf":        true,
+	"quickfix":   true,
+	"acme":       true,
+	"ctags-json": true,
+}
+
+// createOutput returns the destination selected by -o: standard output,
+// or a temporary file next to the named file. The returned function must
+// be called with the result of writing the output; if it is nil the
+// temporary file is renamed into place, so readers of the file never see
+// partial output.
+func createOutput() (io.Writer, func(error) error, error) {
+	if *outputFlag == "" || *outputFlag == "-" {
+		if *formatFlag == "table" && isTerminal(os.Stdout) {
+			if w, wait, err := startPager(); err == nil {
+				return w, wait, nil
+			}
+		}
+		return os.Stdout, func(err error) error { return err }, nil
+	}
+	f, err := ioutil.TempFile(filepath.Dir(*outputFlag), "."+filepath.Base(*outputFlag)+".tmp")
+	if err != nil {
+		return nil, nil, err
+	}
+	bw := bufio.NewWriter(f)
+	return bw, func(err error) error {
+		if err == nil {
+			err = bw.Flush()
+		}
+		if err == nil {
+			err = f.Chmod(0644)
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Rename(f.Name(), *outputFlag)
+		}
+		if err != nil {
+			os.Remove(f.Name())
+		}
+		return err
+	}, nil
+}
+
+// isTerminal reports whether f is a terminal rather than a file or pipe.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// startPager starts $PAGER, or less, with standard output as its output
+// and returns a writer to its input and a function waiting for it to exit.
+func startPager() (io.Writer, func(error) error, error) {
+	pager := os.Getenv("PAGER")
+	if pager == "" {
+		pager = "less"
+	}
+	args := strings.Fields(pager)
+	if len(args) == 0 {
+		return nil, nil, fmt.Errorf("empty $PAGER")
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if os.Getenv("LESS") == "" {
+		// Quit if the output fits on one screen.
+		cmd.Env = append(os.Environ(), "LESS=FRX")
+	}
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, nil, err
+	}
+	return in, func(err error) error {
+		in.Close()
+		if werr := cmd.Wait(); err == nil {
+			err = werr
+		}
+		return err
+	}, nil
+}
+
+// openOutput wraps w as requested by -compress. The returned function
+// must be called to flush the output once it is complete.
+func openOutput(w io.Writer) (io.Writer, func() error, error) {
+	switch *compress {
+	case "", "none":
+		return w, func() error { return nil }, nil
+	case "gzip":
+		zw := gzip.NewWriter(w)
+		return zw, zw.Close, nil
+	}
+	return nil, nil, usagef("unknown compression method %q", *compress)
+}
+
+// symbolOrders maps the -sort keys to orderings. The "none" order leaves
+// symbols in the order the scan produced them. The others are total, so
+// that output does not depend on the order packages were scanned in.
+var symbolOrders = map[string]func(a, b symbol) bool{
+	"none": nil,
+	"path": pathOrder(strings.Compare),
+	"name": nameOrder(strings.Compare),
+}
+
+// pathOrder returns the "path" order, comparing paths and names with cmp.
+func pathOrder(cmp func(a, b string) int) func(a, b symbol) bool {
+	return func(a, b symbol) bool {
+		if c := cmp(a.Path, b.Path); c != 0 {
+			return c < 0
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		if a.Character != b.Character {
+			return a.Character < b.Character
+		}
+		if c := cmp(a.Name, b.Name); c != 0 {
+			return c < 0
+		}
+		return breakTie(a, b)
+	}
+}
+
+// nameOrder returns the "name" order, comparing names and paths with cmp.
+func nameOrder(cmp func(a, b string) int) func(a, b symbol) bool {
+	return func(a, b symbol) bool {
+		if c := cmp(a.Name, b.Name); c != 0 {
+			return c < 0
+		}
+		// Symbols of the tree come before those of the modules it
+		// requires.
+		if (a.Module == "") != (b.Module == "") {
+			return a.Module == ""
+		}
+		if c := cmp(a.Path, b.Path); c != 0 {
+			return c < 0
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		if a.Character != b.Character {
+			return a.Character < b.Character
+		}
+		return breakTie(a, b)
+	}
+}
+
+// breakTie orders symbols declared at the same position, such as a method
+// and the methods promoted from it, or the same file reached as part of
+// several modules.
+func breakTie(a, b symbol) bool {
+	if a.Receiver != b.Receiver {
+		return a.Receiver < b.Receiver
+	}
+	if a.Kind != b.Kind {
+		return a.Kind < b.Kind
+	}
+	if a.Module != b.Module {
+		return a.Module < b.Module
+	}
+	return a.ImportPath < b.ImportPath
+}
+
+func lookupFormat(name string) (formatter, error) {
+	switch {
+	case *positionBase != 0 && *positionBase != 1:
+		return nil, usagef("-position-base must be 0 or 1")
+	case *kindFormatFlag != "" && *kindFormatFlag != "lsp":
+		return nil, usagef("unknown -kind-format %q (want lsp)", *kindFormatFlag)
+	case *kindFormatFlag != "" && name != "json" && name != "ndjson" && name != "msgpack":
+		return nil, usagef("-kind-format is only supported with -format json, ndjson or msgpack")
+	}
+	if f, ok := formats[name]; ok {
+		return f, nil
+	}
+	var names []string
+	for n := range formats {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return nil, usagef("unknown format %q (want one of %s)", name, strings.Join(names, ", "))
+}
+
+// marshalJSON encodes v as indented JSON, or compact JSON with -compact.
+func marshalJSON(v interface{}) ([]byte, error) {
+	if *compactFlag {
+		return json.Marshal(v)
+	}
+	return json.MarshalIndent(v, "", " ")
+}
+
+// jsonSymbols returns v, symbols or a map of lists of them, for encoding by
+// marshalJSON. With -kind-format, the symbols are encoded as by the json
+// format instead, as raw JSON, which encoding/json does not do.
+func jsonSymbols(v interface{}) interface{} {
+	if *kindFormatFlag == "" {
+		return v
+	}
+	switch v := v.(type) {
+	case []symbol:
+		var a jsonArrayWriter
+		var buf bytes.Buffer
+		a.write(&buf, v)
+		a.close(&buf)
+		return json.RawMessage(buf.Bytes())
+	case map[string][]symbol:
+		m := make(map[string]interface{}, len(v))
+		for key, syms := range v {
+			m[key] = jsonSymbols(syms)
+		}
+		return m
+	}
+	return v
+}
+
+func writeJSON(w io.Writer, syms []symbol) error {
+	var a jsonArrayWriter
+	if err := a.write(w, syms); err != nil {
+		return err
+	}
+	return a.close(w)
+}
+
+// A jsonArrayWriter writes symbols as they are found, producing the same
+// output as writeJSON does for all of them once closed.
+type jsonArrayWriter struct {
+	n   int    // symbols written
+	buf []byte // reused for each batch
+}
+
+func (a *jsonArrayWriter) write(w io.Writer, syms []symbol) error {
+	b := a.buf[:0]
+	for i := range syms {
+		sep := ",\n "
+		if *compactFlag {
+			sep = ","
+		}
+		if a.n == 0 {
+			sep = "[" + sep[1:]
+		}
+		a.n++
+		b = append(b, sep...)
+		b = appendSymbolJSON(b, &syms[i], " ", *compactFlag)
+		if len(b) >= 64<<10 {
+			if _, err := w.Write(b); err != nil {
+				return err
+			}
+			b = b[:0]
+		}
+	}
+	a.buf = b
+	_, err := w.Write(b)
+	return err
+}
+
+// close ends the array.
+func (a *jsonArrayWriter) close(w io.Writer) error {
+	end := "\n]\n"
+	switch {
+	case a.n == 0:
+		end = "[]\n"
+	case *compactFlag:
+		end = "]\n"
+	}
+	_, err := io.WriteString(w, end)
+	return err
+}
+
+// schemaVersion is the version of the JSON output schema, incremented on
+// incompatible changes.
+const schemaVersion = 1
+
+// envelope is the JSON document written with -envelope.
+type envelope struct {
+	SchemaVersion int         `json:"schemaVersion"`
+	GeneratedAt   time.Time   `json:"generatedAt"`
+	Scope         scope       `json:"scope"`
+	Errors        []scanError `json:"errors"`
+	Skipped       []scanSkip  `json:"skipped,omitempty"` // packages whose files were not scanned
+	Stats         *scanStats  `json:"stats,omitempty"`
+	Incomplete    bool        `json:"incomplete,omitempty"` // the scan was stopped early
+	Truncated     bool        `json:"truncated,omitempty"`  // more symbols were found than MaxSymbols
+	MaxSymbols    int         `json:"maxSymbols,omitempty"` // the number written if truncated
+	Symbols       interface{} `json:"symbols"`              // []symbol, or map[string][]symbol with -group-by
+
+	// ContinuationToken is set by the serve command if there are more
+	// results, which it returns for a request with the token.
+	ContinuationToken string `json:"continuationToken,omitempty"`
+}
+
+// completeEnvelope fills in the schema version and time of env and sorts
+// its errors and skipped packages.
+func completeEnvelope(env *envelope) {
+	env.SchemaVersion = schemaVersion
+	env.GeneratedAt = time.Now().UTC()
+	if env.Errors == nil {
+		env.Errors = []scanError{}
+	}
+	errs := env.Errors
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Dir < errs[j].Dir })
+	skips := env.Skipped
+	sort.Slice(skips, func(i, j int) bool { return skips[i].Dir < skips[j].Dir })
+}
+
+type scope struct {
+	Dir   string `json:"dir"`
+	Query string `json:"query"`
+}
+
+// writeEnvelope writes env, filling in the schema version and time.
+func writeEnvelope(w io.Writer, env envelope) error {
+	completeEnvelope(&env)
+	env.Symbols = jsonSymbols(env.Symbols)
+	b, err := marshalJSON(env)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
+
+// groupByPackage returns syms keyed by import path.
+func groupByPackage(syms []symbol) map[string][]symbol {
+	groups := make(map[string][]symbol)
+	for _, s := range syms {
+		key := s.ImportPath
+		if key == "" {
+			key = s.Package
+		}
+		groups[key] = append(groups[key], s)
+	}
+	return groups
+}
+
+// writeGroupedJSON writes a JSON object mapping import paths to the
+// symbols declared in that package.
+func writeGroupedJSON(w io.Writer, syms []symbol) error {
+	b, err := marshalJSON(jsonSymbols(groupByPackage(syms)))
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
+
+// writeNDJSON writes one JSON object per line.
+func writeNDJSON(w io.Writer, syms []symbol) error {
+	var b []byte
+	for i := range syms {
+		b = appendSymbolJSON(b, &syms[i], "", true)
+		b = append(b, '\n')
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+// writePlain writes grep-style "path:line:col: kind name" lines with
+// 1-based line and column numbers. Kinds and the matches of the query in
+// names are colorized according to -color.
+func writePlain(w io.Writer, syms []symbol) error {
+	bw := bufio.NewWriter(w)
+	for _, s := range syms {
+		fmt.Fprintf(bw, "%s:%d:%d: %s %s\n", s.Path, s.Line+1, s.Character+1, colorize(s.Kind, kindColor(s.Kind)), highlightMatches(s.Name))
+	}
+	return bw.Flush()
+}
+
+// writeTable writes an aligned table of symbols for reading in a terminal,
+// colorized as plain output. Columns are padded by hand rather than with
+// a tabwriter, which would count escape sequences in their widths.
+func writeTable(w io.Writer, syms []symbol) error {
+	rows := [][]string{{"NAME", "KIND", "PACKAGE", "LOCATION"}}
+	for _, s := range syms {
+		name := s.Name
+		if s.Receiver != "" {
+			name = receiverType(s.Receiver) + "." + name
+		}
+		rows = append(rows, []string{name, s.Kind, s.Package, fmt.Sprintf("%s:%d:%d", s.Path, s.Line+1, s.Character+1)})
+	}
+	var widths [3]int
+	for _, row := range rows {
+		for i := range widths {
+			if n := utf8.RuneCountInString(row[i]); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+	bw := bufio.NewWriter(w)
+	for r, row := range rows {
+		cells := append([]string(nil), row...)
+		if r > 0 {
+			s := syms[r-1]
+			cells[0] = strings.TrimSuffix(row[0], s.Name) + highlightMatches(s.Name)
+			cells[1] = colorize(s.Kind, kindColor(s.Kind))
+		}
+		for i := range widths {
+			bw.WriteString(cells[i])
+			bw.WriteString(strings.Repeat(" ", widths[i]-utf8.RuneCountInString(row[i])+2))
+		}
+		bw.WriteString(cells[3])
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+// templateFormat returns a formatter executing the text/template text for
+// each symbol, followed by a newline.
+func templateFormat(text string) (formatter, error) {
+	tmpl, err := template.New("format").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return func(w io.Writer, syms []symbol) error {
+		bw := bufio.NewWriter(w)
+		for _, s := range syms {
+			if err := tmpl.Execute(bw, s); err != nil {
+				return err
+			}
+			bw.WriteByte('\n')
+		}
+		return bw.Flush()
+	}, nil
+}
+
+// writeQuickfix writes "path:line:col: name (kind)" lines, which vim's
+// default errorformat parses, so that :cexpr system('gosymbols ...')
+// fills the quickfix list.
+func writeQuickfix(w io.Writer, syms []symbol) error {
+	bw := bufio.NewWriter(w)
+	for _, s := range syms {
+		name := s.Name
+		if s.Receiver != "" {
+			name = receiverType(s.Receiver) + "." + name
+		}
+		fmt.Fprintf(bw, "%s:%d:%d: %s (%s)\n", s.Path, s.Line+1, s.Character+1, name, s.Kind)
+	}
+	return bw.Flush()
+}
+
+// writeAcme writes "path:line.col<TAB>kind name" lines, with 1-based line
+// and column numbers, whose addresses acme and the plumber open when
+// clicked with the right button.
+func writeAcme(w io.Writer, syms []symbol) error {
+	bw := bufio.NewWriter(w)
+	for _, s := range syms {
+		name := s.Name
+		if s.Receiver != "" {
+			name = receiverType(s.Receiver) + "." + name
+		}
+		fmt.Fprintf(bw, "%s:%d.%d\t%s %s\n", s.Path, s.Line+1, s.Character+1, s.Kind, name)
+	}
+	return bw.Flush()
+}
+
+// writeFzf writes "display<TAB>path<TAB>line<TAB>col" records for use
+// with fzf --delimiter '\t' --with-nth 1, with 1-based line and column
+// numbers. The display text is colorized according to -color.
+func writeFzf(w io.Writer, syms []symbol) error {
+	bw := bufio.NewWriter(w)
+	for _, s := range syms {
+		name := s.Name
+		if s.Receiver != "" {
+			name = receiverType(s.Receiver) + "." + name
+		}
+		display := colorize(name, ansiBold) + " " + colorize(s.Kind, kindColor(s.Kind)) + " " + colorize(s.Package, ansiFaint)
+		fmt.Fprintf(bw, "%s\t%s\t%d\t%d\n", display, s.Path, s.Line+1, s.Character+1)
+	}
+	return bw.Flush()
+}
+
+// writeCSV returns a formatter writing a header row followed by one record
+// per symbol, with fields separated by comma.
+func writeCSV(comma rune) formatter {
+	return func(w io.Writer, syms []symbol) error {
+		cw := csv.NewWriter(w)
+		cw.Comma = comma
+		cw.Write([]string{"name", "kind", "package", "path", "line", "character", "receiver", "signature"})
+		for _, s := range syms {
+			cw.Write([]string{
+				s.Name,
+				s.Kind,
+				s.Package,
+				s.Path,
+				strconv.Itoa(s.Line),
+				strconv.Itoa(s.Character),
+				s.Receiver,
+				s.Signature,
+			})
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+}
+
+// rawPositionFormats are the formats that write the positions of symbols
+// as they are, 0-based unless -position-base is 1. The others number
+// lines and columns as the tools reading them expect.
+var rawPositionFormats = map[string]bool{
+	"json":    true,
+	"ndjson":  true,
+	"csv":     true,
+	"tsv":     true,
+	"proto":   true,
+	"msgpack": true,
+}
+
+// rewritePaths rewrites the paths of syms as requested by -symlinks,
+// -relative-to or -uri, and their positions as requested by -position-base.
+func rewritePaths(syms []symbol) {
+	if *positionBase == 1 && (*templateFlag != "" || rawPositionFormats[*formatFlag]) {
+		for i := range syms {
+			syms[i].Line++
+			syms[i].Character++
+		}
+	}
+	if *symlinksFlag != "" {
+		for i := range syms {
+			syms[i].Path = symlinkPath(syms[i].Path)
+		}
+	}
+	switch {
+	case *uriFlag:
+		for i := range syms {
+			syms[i].Path = fileURI(syms[i].Path)
+		}
+	case *relativeTo != "":
+		base, err := filepath.Abs(*relativeTo)
+		if err != nil {
+			return
+		}
+		for i := range syms {
+			abs, err := filepath.Abs(syms[i].Path)
+			if err != nil {
+				continue
+			}
+			if rel, err := filepath.Rel(base, abs); err == nil {
+				syms[i].Path = rel
+			}
+		}
+	}
+}
+
+// resolvedPaths caches the paths returned by resolvedPath, by path.
+var resolvedPaths sync.Map
+
+// resolvedPath returns the absolute form of path with its symbolic links
+// resolved.
+func resolvedPath(path string) string {
+	if r, ok := resolvedPaths.Load(path); ok {
+		return r.(string)
+	}
+	r := symbols.CanonicalDir(path)
+	resolvedPaths.Store(path, r)
+	return r
+}
+
+// symlinkPath returns path as requested by -symlinks: resolved, or, if it
+// resolves to a file below one of the directories being scanned, spelled
+// below that directory as it was given, so that paths reached through a
+// link, such as by the go command, match those an editor has open.
+func symlinkPath(path string) string {
+	if *symlinksFlag == "resolve" {
+		return resolvedPath(path)
+	}
+	for _, root := range givenRoots {
+		if strings.HasPrefix(path, root+string(filepath.Separator)) {
+			return path
+		}
+	}
+	resolved := resolvedPath(path)
+	for _, root := range givenRoots {
+		prefix := resolvedPath(root) + string(filepath.Separator)
+		if strings.HasPrefix(resolved, prefix) {
+			return filepath.Join(root, resolved[len(prefix):])
+		}
+	}
+	return path
+}
+
+// sourcePath returns the file system path of a path as reported in the
+// output, undoing -relative-to and -uri.
+func sourcePath(path string) string {
+	if strings.HasPrefix(path, "file://") {
+		return uriPath(path)
+	}
+	if *relativeTo != "" && !filepath.IsAbs(path) {
+		return filepath.Join(*relativeTo, path)
+	}
+	return path
+}
+
+// fileURI returns the percent-encoded file:// URI for path, which is made
+// absolute first. Windows drive letters and UNC paths are handled as
+// described in RFC 8089: C:\x becomes file:///C:/x and \\host\share\x
+// becomes file://host/share/x.
+func fileURI(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	path = filepath.ToSlash(path)
+
+	u := url.URL{Scheme: "file"}
+	switch {
+	case strings.HasPrefix(path, "//"):
+		host, rest := path[2:], "/"
+		if i := strings.IndexByte(host, '/'); i >= 0 {
+			host, rest = host[:i], host[i:]
+		}
+		u.Host, u.Path = host, rest
+	case len(path) >= 2 && path[1] == ':':
+		u.Path = "/" + path
+	default:
+		u.Path = path
+	}
+	return u.String()
+}
+
+// uriPath is the inverse of fileURI.
+func uriPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	path := u.Path
+	if u.Host != "" && u.Host != "localhost" {
+		path = "//" + u.Host + path
+	} else if len(path) >= 3 && path[0] == '/' && path[2] == ':' {
+		path = path[1:]
+	}
+	return filepath.FromSlash(path)
+}
+
+// declString returns a Go-like declaration for s, e.g.
+// "func (s *Server) Close() error".
+func declString(s symbol) string {
+	switch s.Kind {
+	case "func":
+		if s.Receiver != "" {
+			return fmt.Sprintf("func (%s) %s%s", s.Receiver, s.Name, s.Signature)
+		}
+		return "func " + s.Name + s.Signature
+	case "var":
+		return "var " + s.Name + " " + s.Signature
+	default:
+		return s.Kind + " " + s.Name
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"flag"
+	"os/exec"
+	"path/filepath"
+)
+
+var prefilterFlag = flag.String("prefilter", "", "list the files that may match the query with `tool` rg, ripgrep, reading only those")
+
+// ripgrepCandidates returns the absolute names of the Go files in the
+// directories of dir that contain query, ignoring case, as ripgrep lists
+// them. It returns false if ripgrep is not installed or fails, in which
+// case every file must be read.
+func ripgrepCandidates(ctx context.Context, dir, query string) (map[string]bool, bool) {
+	rg, err := exec.LookPath("rg")
+	if err != nil {
+		logger.Debug("not prefiltering", "err", err)
+		return nil, false
+	}
+	args := []string{"--files-with-matches", "--ignore-case", "--fixed-strings", "--no-ignore", "--hidden", "--no-messages", "--glob", "*.go", "-e", query, "--"}
+	args = append(args, filepath.SplitList(dir)...)
+	out, err := exec.CommandContext(ctx, rg, args...).Output()
+	if e, ok := err.(*exec.ExitError); ok && e.ExitCode() == 1 {
+		err = nil // no file matches
+	}
+	if err != nil {
+		logger.Debug("not prefiltering", "err", err)
+		return nil, false
+	}
+	files := make(map[string]bool)
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		if abs, err := filepath.Abs(sc.Text()); err == nil {
+			files[abs] = true
+		}
+	}
+	return files, true
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/newhook/go-symbols/symbols"
+)
+
+var progressFlag = flag.Bool("progress", false, "report the progress of scans on stderr")
+
+// progressKey is the context key of the function given the progress of
+// the scans made under the context.
+type progressKey struct{}
+
+// withProgress returns a context under which scans report their progress
+// to fn.
+func withProgress(ctx context.Context, fn func(symbols.Progress)) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+// scanProgress returns the function to give the progress of the scan of
+// dir under ctx, which also reports it on stderr with -progress, or nil if
+// none.
+func scanProgress(ctx context.Context, dir string) func(symbols.Progress) {
+	fn, _ := ctx.Value(progressKey{}).(func(symbols.Progress))
+	if !*progressFlag {
+		return fn
+	}
+	// On a terminal each report replaces the last.
+	terminal := isTerminal(os.Stderr)
+	return func(p symbols.Progress) {
+		line := fmt.Sprintf("go-symbols: scanning %s: %s", dir, progressMessage(p))
+		switch {
+		case !terminal:
+			fmt.Fprintln(os.Stderr, line)
+		case p.Listed && p.Done == p.Packages:
+			fmt.Fprintf(os.Stderr, "\r%s\x1b[K\n", line)
+		default:
+			fmt.Fprintf(os.Stderr, "\r%s\x1b[K", line)
+		}
+		if fn != nil {
+			fn(p)
+		}
+	}
+}
+
+// progressMessage describes p, with an estimate of the share of the scan
+// done: a lower bound while packages are still being found.
+func progressMessage(p symbols.Progress) string {
+	found := ""
+	if !p.Listed {
+		found = " found so far"
+	}
+	return fmt.Sprintf("%d of %d packages%s (%d%%), %d files, %d symbols", p.Done, p.Packages, found, progressPercent(p), p.Files, p.Symbols)
+}
+
+// progressPercent returns the share of the packages found that p has
+// scanned, in percent.
+func progressPercent(p symbols.Progress) int {
+	if p.Packages == 0 {
+		if p.Listed {
+			return 100
+		}
+		return 0
+	}
+	return 100 * p.Done / p.Packages
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"io"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Field numbers of the Symbol message in proto/symbol.proto.
+const (
+	protoSymbolName          = 1
+	protoSymbolKind          = 2
+	protoSymbolPackage       = 3
+	protoSymbolPath          = 4
+	protoSymbolLine          = 5
+	protoSymbolCharacter     = 6
+	protoSymbolReceiver      = 7
+	protoSymbolSignature     = 8
+	protoSymbolModule        = 9
+	protoSymbolObject        = 10
+	protoSymbolType          = 11
+	protoSymbolDefinedIn     = 12
+	protoSymbolReferences    = 13
+	protoSymbolImplements    = 14
+	protoSymbolImplementedBy = 15
+	protoSymbolAliased       = 16
+	protoSymbolPromoted      = 17
+	protoSymbolTags          = 18
+	protoSymbolImportPath    = 19
+	protoSymbolImportable    = 20
+	protoSymbolTypeParams    = 21
+	protoSymbolVendored      = 22
+	protoSymbolBuild         = 23
+	protoSymbolSummary       = 24
+)
+
+// Field numbers of the TypeParam message.
+const (
+	protoTypeParamName        = 1
+	protoTypeParamConstraint  = 2
+	protoTypeParamConstraints = 3
+)
+
+// writeProto writes syms as length-delimited Symbol messages.
+func writeProto(w io.Writer, syms []symbol) error {
+	bw := bufio.NewWriter(w)
+	var buf, msg []byte
+	for _, s := range syms {
+		msg = appendProtoSymbol(msg[:0], s)
+		buf = protowire.AppendVarint(buf[:0], uint64(len(msg)))
+		bw.Write(buf)
+		bw.Write(msg)
+	}
+	return bw.Flush()
+}
+
+// appendProtoSymbol appends the encoding of s as a Symbol message to b.
+// Fields with zero values are omitted, as proto3 requires.
+func appendProtoSymbol(b []byte, s symbol) []byte {
+	b = appendProtoString(b, protoSymbolName, s.Name)
+	b = appendProtoString(b, protoSymbolKind, s.Kind)
+	b = appendProtoString(b, protoSymbolPackage, s.Package)
+	b = appendProtoString(b, protoSymbolImportPath, s.ImportPath)
+	if s.Vendored {
+		b = protowire.AppendTag(b, protoSymbolVendored, protowire.VarintType)
+		b = protowire.AppendVarint(b, 1)
+	}
+	b = appendProtoString(b, protoSymbolPath, s.Path)
+	b = appendProtoInt(b, protoSymbolLine, s.Line)
+	b = appendProtoInt(b, protoSymbolCharacter, s.Character)
+	b = appendProtoString(b, protoSymbolReceiver, s.Receiver)
+	b = appendProtoString(b, protoSymbolSignature, s.Signature)
+	b = appendProtoString(b, protoSymbolSummary, s.Summary)
+	b = appendProtoString(b, protoSymbolModule, s.Module)
+	b = appendProtoString(b, protoSymbolBuild, s.Build)
+	b = appendProtoString(b, protoSymbolObject, s.Object)
+	b = appendProtoString(b, protoSymbolType, s.Type)
+	b = appendProtoString(b, protoSymbolDefinedIn, s.DefinedIn)
+	if s.Importable != nil {
+		// Unlike a bool, an optional field records false.
+		b = protowire.AppendTag(b, protoSymbolImportable, protowire.VarintType)
+		b = protowire.AppendVarint(b, protowire.EncodeBool(*s.Importable))
+	}
+	if s.References != nil {
+		b = appendProtoInt(b, protoSymbolReferences, *s.References)
+	}
+	b = appendProtoString(b, protoSymbolAliased, s.Aliased)
+	for _, tp := range s.TypeParams {
+		var msg []byte
+		msg = appendProtoString(msg, protoTypeParamName, tp.Name)
+		msg = appendProtoString(msg, protoTypeParamConstraint, tp.Constraint)
+		for _, name := range tp.Constraints {
+			msg = protowire.AppendTag(msg, protoTypeParamConstraints, protowire.BytesType)
+			msg = protowire.AppendString(msg, name)
+		}
+		b = protowire.AppendTag(b, protoSymbolTypeParams, protowire.BytesType)
+		b = protowire.AppendBytes(b, msg)
+	}
+	for _, name := range s.Implements {
+		b = protowire.AppendTag(b, protoSymbolImplements, protowire.BytesType)
+		b = protowire.AppendString(b, name)
+	}
+	for _, name := range s.ImplementedBy {
+		b = protowire.AppendTag(b, protoSymbolImplementedBy, protowire.BytesType)
+		b = protowire.AppendString(b, name)
+	}
+	for _, key := range sortedTagKeys(s.Tags) {
+		// A map entry is a message with the key and value as fields 1
+		// and 2.
+		var entry []byte
+		entry = appendProtoString(entry, 1, key)
+		entry = appendProtoString(entry, 2, s.Tags[key])
+		b = protowire.AppendTag(b, protoSymbolTags, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+	if s.Promoted {
+		b = protowire.AppendTag(b, protoSymbolPromoted, protowire.VarintType)
+		b = protowire.AppendVarint(b, 1)
+	}
+	return b
+}
+
+func appendProtoString(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+func appendProtoInt(b []byte, num protowire.Number, v int) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(int32(v)))
+}
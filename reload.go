@@ -0,0 +1,160 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// hotFlags are the flags of the serve command that changes to the
+// configuration apply while it runs, each with whether the symbols of
+// files unchanged since the last scan can be reused once it changed.
+var hotFlags = map[string]bool{
+	"j":                      true,
+	"only-prefix":            true,
+	"tags":                   true,
+	"deps":                   true,
+	"packages":               true,
+	"package-timeout":        true,
+	"with-implements":        true,
+	"fast":                   false,
+	"ignore-line-directives": false,
+}
+
+// flagsMu guards the hot flags, which configuration changes write while
+// workspaces are loaded and queried. Loads hold it for reading throughout
+// their scan; queries read the flags they filter by under it once, when
+// they start.
+var flagsMu sync.RWMutex
+
+// reloadConfig applies the changes to the configuration of the workspace in
+// root to the hot flags that cmd accepts and the command line leaves to the
+// configuration. It returns the names of the flags changed. Changes to
+// other flags are logged as needing a restart.
+func reloadConfig(cmd *command, root string) ([]string, error) {
+	settings, err := readConfig(root)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(settings)+len(configFlags))
+	for name := range settings {
+		names = append(names, name)
+	}
+	for name := range configFlags {
+		if _, ok := settings[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	flagsMu.Lock()
+	defer flagsMu.Unlock()
+	var changed []string
+	for _, name := range names {
+		old, fromConfig := configFlags[name]
+		if !cmd.accepts(name) || flagSet(name) && !fromConfig {
+			continue
+		}
+		s, ok := settings[name]
+		if ok && fromConfig && s.value == old {
+			continue
+		}
+		if _, hot := hotFlags[name]; !hot {
+			logger.Warn("restart to apply the changed configuration", "flag", name)
+			continue
+		}
+		if ok {
+			if err := flag.Set(name, s.value); err != nil {
+				return changed, fmt.Errorf("%s: invalid value for %s: %v", s.source, name, err)
+			}
+			configFlags[name] = s.value
+			setFlags[name] = true
+		} else {
+			// Removed from the configuration.
+			f := flag.Lookup(name)
+			f.Value.Set(f.DefValue)
+			delete(configFlags, name)
+			delete(setFlags, name)
+		}
+		changed = append(changed, name)
+	}
+	return changed, nil
+}
+
+// watchConfig applies changes to the configuration files of the workspace
+// in root to the flags of cmd, and loads the workspaces of ws again if the
+// symbols they hold depend on the flags changed.
+func (ws *workspaceSet) watchConfig(cmd *command, root string) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	for _, dir := range configDirs(root) {
+		// The directories are watched, as editors replace files.
+		w.Add(dir)
+	}
+	go func() {
+		var timer <-chan time.Time
+		for {
+			select {
+			case ev, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				for _, name := range configNames {
+					if filepath.Base(ev.Name) == name {
+						timer = time.After(watchDelay)
+					}
+				}
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				logger.Error("watching the configuration", "err", err)
+			case <-timer:
+				timer = nil
+				changed, err := reloadConfig(cmd, root)
+				if err != nil {
+					logger.Error("reloading the configuration", "err", err)
+				}
+				ws.reload(changed)
+			}
+		}
+	}()
+	return nil
+}
+
+// reload loads the workspaces of ws again after the named flags changed,
+// reusing the symbols of unchanged files where the flags allow.
+func (ws *workspaceSet) reload(changed []string) {
+	rescan, reuse := false, true
+	for _, name := range changed {
+		logger.Info("applied the changed configuration", "flag", name, "value", flag.Lookup(name).Value.String())
+		if name != "j" {
+			rescan = true
+		}
+		reuse = reuse && hotFlags[name]
+	}
+	if !rescan {
+		return
+	}
+	ws.mu.RLock()
+	servers := make([]*symbolServer, 0, len(ws.servers))
+	for _, srv := range ws.servers {
+		servers = append(servers, srv)
+	}
+	ws.mu.RUnlock()
+	for _, srv := range servers {
+		if !reuse {
+			srv.mu.Lock()
+			srv.files = nil
+			srv.mu.Unlock()
+		}
+		srv.load()
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"net"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var remoteFlag = flag.String("remote", "", "with search, query the serve command listening at `address` instead of an index")
+
+// remotePage is the number of symbols requested from a server at a time.
+const remotePage = 1000
+
+// dialRemote connects to the serve command listening at addr, which is
+// host:port or unix:path as for -listen.
+func dialRemote(addr string) (net.Conn, error) {
+	network := "tcp"
+	if strings.HasPrefix(addr, "unix:") {
+		network, addr = "unix", strings.TrimPrefix(addr, "unix:")
+	}
+	return net.DialTimeout(network, addr, 10*time.Second)
+}
+
+// remoteSource returns a symbolSource answering queries over conn, from
+// the symbols held by a serve command. They are requested a page at a time
+// until found has enough. Paths are those of the server's file system,
+// and relative paths are computed against the directory it serves.
+func remoteSource(conn net.Conn) symbolSource {
+	return func(ctx context.Context, dir, query string, found func([]symbol)) *scanSummary {
+		sum := new(scanSummary)
+		fail := func(err error) *scanSummary {
+			if ctx.Err() != nil {
+				err = ctx.Err()
+			}
+			sum.errors = append(sum.errors, scanError{Dir: dir, Phase: "remote", Message: "querying the server: " + err.Error()})
+			sum.stats.Errors++
+			return sum
+		}
+		stop := context.AfterFunc(ctx, func() { conn.Close() })
+		defer stop()
+
+		enc := json.NewEncoder(conn)
+		dec := json.NewDecoder(conn)
+		req := serveRequest{Query: query, Limit: remotePage}
+		for {
+			if err := enc.Encode(req); err != nil {
+				return fail(err)
+			}
+			var resp struct {
+				Error             string      `json:"error"`
+				Scope             scope       `json:"scope"`
+				Errors            []scanError `json:"errors"`
+				Symbols           []symbol    `json:"symbols"`
+				ContinuationToken string      `json:"continuationToken"`
+			}
+			if err := dec.Decode(&resp); err != nil {
+				return fail(err)
+			}
+			if resp.Error != "" {
+				return fail(errors.New(resp.Error))
+			}
+			if req.ContinuationToken == "" {
+				sum.errors = resp.Errors
+				sum.stats.Errors = len(resp.Errors)
+				if roots := filepath.SplitList(resp.Scope.Dir); len(roots) > 0 && filepath.IsAbs(roots[0]) {
+					workspaceRoot = roots[0]
+				}
+			}
+			found(resp.Symbols)
+			if resp.ContinuationToken == "" || ctx.Err() != nil {
+				return sum
+			}
+			req.ContinuationToken = resp.ContinuationToken
+		}
+	}
+}
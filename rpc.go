@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+)
+
+// JSON-RPC error codes.
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+)
+
+// An rpcRequest is a JSON-RPC 2.0 request, or a notification if it has no
+// ID.
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// An rpcResponse answers the request with the same ID with either a result
+// or an error.
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string { return e.Message }
+
+// newRPCResponse returns the response to the request with the given ID,
+// holding result or, if err is not nil, the error.
+func newRPCResponse(id json.RawMessage, result interface{}, err error) *rpcResponse {
+	resp := &rpcResponse{JSONRPC: "2.0", ID: id}
+	if id == nil {
+		resp.ID = json.RawMessage("null")
+	}
+	if err == nil {
+		resp.Result, err = json.Marshal(result)
+	}
+	if err != nil {
+		rerr, ok := err.(*rpcError)
+		if !ok {
+			rerr = &rpcError{Code: rpcInternalError, Message: err.Error()}
+		}
+		resp.Result, resp.Error = nil, rerr
+	}
+	return resp
+}
+
+// runRPC implements the rpc command, a JSON-RPC 2.0 server reading one
+// request per line from standard input and writing each response on a line
+// of standard output. It scans dir once and keeps the symbols up to date as
+// files change, like the serve command.
+func runRPC(args []string) error {
+	dir, _ := parseArgs(args)
+	srv := newSymbolServer(dir)
+	srv.loadAndWatch(context.Background())
+
+	r := bufio.NewScanner(os.Stdin)
+	w := bufio.NewWriter(os.Stdout)
+	for r.Scan() {
+		if len(r.Bytes()) == 0 {
+			continue
+		}
+		var req rpcRequest
+		if err := json.Unmarshal(r.Bytes(), &req); err != nil {
+			rerr := &rpcError{rpcParseError, err.Error()}
+			if json.Valid(r.Bytes()) {
+				// Such as a batch, which is not supported.
+				rerr.Code = rpcInvalidRequest
+			}
+			if err := writeRPCMessage(w, newRPCResponse(nil, nil, rerr)); err != nil {
+				return err
+			}
+			continue
+		}
+		result, err := srv.call(req)
+		if req.ID == nil {
+			continue // a notification
+		}
+		if err := writeRPCMessage(w, newRPCResponse(req.ID, result, err)); err != nil {
+			return err
+		}
+	}
+	return r.Err()
+}
+
+// writeRPCMessage writes v on a line of its own.
+func writeRPCMessage(w *bufio.Writer, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	w.Write(b)
+	w.WriteByte('\n')
+	return w.Flush()
+}
+
+// call returns the result of the JSON-RPC request req. The search method
+// takes the fields of a serve request and returns its envelope; index scans
+// the workspace again at once, without waiting for changes to be noticed,
+// and returns the status; and status returns the status.
+func (s *symbolServer) call(req rpcRequest) (interface{}, error) {
+	if req.JSONRPC != "2.0" {
+		return nil, &rpcError{rpcInvalidRequest, "not a JSON-RPC 2.0 request"}
+	}
+	switch req.Method {
+	case "search":
+		var sreq serveRequest
+		if len(req.Params) > 0 {
+			if err := json.Unmarshal(req.Params, &sreq); err != nil {
+				return nil, &rpcError{rpcInvalidParams, err.Error()}
+			}
+		}
+		env, err := s.answer(sreq)
+		if err != nil {
+			return nil, &rpcError{rpcInvalidParams, err.Error()}
+		}
+		completeEnvelope(&env)
+		return env, nil
+	case "index":
+		s.load()
+		return s.status(), nil
+	case "status":
+		return s.status(), nil
+	}
+	return nil, &rpcError{rpcMethodNotFound, "method not found: " + req.Method}
+}
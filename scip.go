@@ -0,0 +1,178 @@
+package main
+
+import (
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Field numbers and enum values from the SCIP schema, scip.proto.
+const (
+	scipIndexMetadata  = 1
+	scipIndexDocuments = 2
+
+	scipMetadataVersion      = 1
+	scipMetadataToolInfo     = 2
+	scipMetadataProjectRoot  = 3
+	scipMetadataTextEncoding = 4
+
+	scipToolInfoName = 1
+
+	scipDocumentRelativePath = 1
+	scipDocumentOccurrences  = 2
+	scipDocumentSymbols      = 3
+	scipDocumentLanguage     = 4
+
+	scipOccurrenceRange       = 1
+	scipOccurrenceSymbol      = 2
+	scipOccurrenceSymbolRoles = 3
+
+	scipSymbolInfoSymbol        = 1
+	scipSymbolInfoDocumentation = 3
+
+	scipTextEncodingUTF8 = 1
+	scipRoleDefinition   = 1
+)
+
+// writeSCIP writes syms as a SCIP index with one document per file,
+// relative to the workspace root.
+func writeSCIP(w io.Writer, syms []symbol) error {
+	byPath := make(map[string][]symbol)
+	var paths []string
+	for _, s := range syms {
+		if _, ok := byPath[s.Path]; !ok {
+			paths = append(paths, s.Path)
+		}
+		byPath[s.Path] = append(byPath[s.Path], s)
+	}
+	sort.Strings(paths)
+
+	var tool []byte
+	tool = protowire.AppendTag(tool, scipToolInfoName, protowire.BytesType)
+	tool = protowire.AppendString(tool, "gosymbols")
+
+	var meta []byte
+	meta = protowire.AppendTag(meta, scipMetadataVersion, protowire.VarintType)
+	meta = protowire.AppendVarint(meta, 0)
+	meta = protowire.AppendTag(meta, scipMetadataToolInfo, protowire.BytesType)
+	meta = protowire.AppendBytes(meta, tool)
+	meta = protowire.AppendTag(meta, scipMetadataProjectRoot, protowire.BytesType)
+	meta = protowire.AppendString(meta, fileURI(workspaceRoot))
+	meta = protowire.AppendTag(meta, scipMetadataTextEncoding, protowire.VarintType)
+	meta = protowire.AppendVarint(meta, scipTextEncodingUTF8)
+
+	var index []byte
+	index = protowire.AppendTag(index, scipIndexMetadata, protowire.BytesType)
+	index = protowire.AppendBytes(index, meta)
+	for _, path := range paths {
+		index = protowire.AppendTag(index, scipIndexDocuments, protowire.BytesType)
+		index = protowire.AppendBytes(index, scipDocument(path, byPath[path]))
+	}
+
+	_, err := w.Write(index)
+	return err
+}
+
+func scipDocument(path string, syms []symbol) []byte {
+	var doc []byte
+	doc = protowire.AppendTag(doc, scipDocumentRelativePath, protowire.BytesType)
+	doc = protowire.AppendString(doc, rootRelative(path))
+	doc = protowire.AppendTag(doc, scipDocumentLanguage, protowire.BytesType)
+	doc = protowire.AppendString(doc, "go")
+	for _, s := range syms {
+		moniker := scipSymbol(s)
+
+		var rng []byte
+		for _, v := range []int{s.Line, s.Character, s.Character + len(s.Name)} {
+			rng = protowire.AppendVarint(rng, uint64(v))
+		}
+		var occ []byte
+		occ = protowire.AppendTag(occ, scipOccurrenceRange, protowire.BytesType)
+		occ = protowire.AppendBytes(occ, rng)
+		occ = protowire.AppendTag(occ, scipOccurrenceSymbol, protowire.BytesType)
+		occ = protowire.AppendString(occ, moniker)
+		occ = protowire.AppendTag(occ, scipOccurrenceSymbolRoles, protowire.VarintType)
+		occ = protowire.AppendVarint(occ, scipRoleDefinition)
+		doc = protowire.AppendTag(doc, scipDocumentOccurrences, protowire.BytesType)
+		doc = protowire.AppendBytes(doc, occ)
+
+		var info []byte
+		info = protowire.AppendTag(info, scipSymbolInfoSymbol, protowire.BytesType)
+		info = protowire.AppendString(info, moniker)
+		info = protowire.AppendTag(info, scipSymbolInfoDocumentation, protowire.BytesType)
+		info = protowire.AppendString(info, "```go\n"+declString(s)+"\n```")
+		doc = protowire.AppendTag(doc, scipDocumentSymbols, protowire.BytesType)
+		doc = protowire.AppendBytes(doc, info)
+	}
+	return doc
+}
+
+// rootRelative returns the slash-separated path of the file at path
+// relative to the workspace root, or path if it is not below it.
+func rootRelative(path string) string {
+	rel := path
+	if abs, err := filepath.Abs(sourcePath(path)); err == nil {
+		if r, err := filepath.Rel(workspaceRoot, abs); err == nil {
+			rel = r
+		}
+	}
+	return filepath.ToSlash(rel)
+}
+
+// scipSymbol returns the SCIP symbol for s, built from its import path,
+// receiver type and name, e.g.
+// "scip-go gomod example.com/foo . `example.com/foo`/Server#Close().".
+func scipSymbol(s symbol) string {
+	pkg := s.ImportPath
+	if pkg == "" {
+		pkg = s.Package
+	}
+	var b strings.Builder
+	b.WriteString("scip-go gomod ")
+	b.WriteString(strings.Replace(pkg, " ", "  ", -1))
+	b.WriteString(" . ")
+	b.WriteString(scipEscape(pkg))
+	b.WriteString("/")
+	switch {
+	case s.Kind == "package":
+		// The namespace itself.
+	case s.Kind == "type":
+		b.WriteString(scipEscape(s.Name) + "#")
+	case s.Kind == "var":
+		b.WriteString(scipEscape(s.Name) + ".")
+	case s.Kind == "field":
+		b.WriteString(scipEscape(receiverType(s.Receiver)) + "#")
+		b.WriteString(scipEscape(s.Name) + ".")
+	case s.Receiver != "":
+		b.WriteString(scipEscape(receiverType(s.Receiver)) + "#")
+		b.WriteString(scipEscape(s.Name) + "().")
+	default:
+		b.WriteString(scipEscape(s.Name) + "().")
+	}
+	return b.String()
+}
+
+// scipEscape returns name as a SCIP descriptor name, quoting it with
+// backticks unless it consists only of identifier characters.
+func scipEscape(name string) string {
+	for _, r := range name {
+		if !(r == '_' || r == '+' || r == '-' || r == '$' ||
+			'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9') {
+			return "`" + strings.Replace(name, "`", "``", -1) + "`"
+		}
+	}
+	return name
+}
+
+// receiverType returns the base type name of a method receiver such as
+// "*List[T]".
+func receiverType(recv string) string {
+	recv = strings.TrimPrefix(recv, "*")
+	if i := strings.IndexByte(recv, '['); i >= 0 {
+		recv = recv[:i]
+	}
+	return recv
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/newhook/go-symbols/symbols"
+)
+
+var listenFlag = flag.String("listen", "localhost:7433", "`address` the serve and grpc commands listen on, or unix:path for a Unix socket")
+
+// A symbolServer holds the symbols of a workspace in memory and answers
+// queries against them.
+type symbolServer struct {
+	dir     string
+	watcher *fsnotify.Watcher // set by watch
+
+	loading sync.Mutex // held by load, so that loads do not overlap
+
+	mu       sync.RWMutex
+	files    map[string]*cachedFile // by file name
+	syms     []symbol               // of all files
+	trigrams trigramIndex           // of syms
+	errors   []scanError
+	loaded   time.Time     // when the last load completed
+	loadTime time.Duration // taken by it
+	updated  chan struct{} // closed by the next load
+}
+
+// newSymbolServer returns a server of the workspace of dir, a list of
+// directories, made absolute so that its paths and cache keys do not depend
+// on the working directory of the command serving it.
+func newSymbolServer(dir string) *symbolServer {
+	return &symbolServer{dir: absDirs(dir)}
+}
+
+// A serveRequest is a line sent by a client of the serve command.
+type serveRequest struct {
+	// Op is the operation requested: search (the default), register or
+	// unregister the workspace of Root, or list the workspaces. All but
+	// search are answered with the workspaces registered.
+	Op string `json:"op"`
+
+	// Root is the workspace searched, which may be omitted if only one
+	// is registered.
+	Root string `json:"root"`
+
+	Query string `json:"query"`
+	Kind  string `json:"kind"` // if set, only symbols of this kind, func or type
+
+	// Limit, if positive, is the most symbols to answer with. The
+	// continuation token of the answer requests the following ones.
+	Limit             int    `json:"limit"`
+	ContinuationToken string `json:"continuationToken"`
+}
+
+// A pageCursor is the symbol following which a page of results starts,
+// holding every field of the "path" order, so that symbols sharing a
+// position are neither repeated nor skipped across pages. It is sent to
+// clients as a continuation token, so that the server keeps no state
+// between requests.
+type pageCursor struct {
+	Path       string `json:"p"`
+	Line       int    `json:"l"`
+	Character  int    `json:"c"`
+	Name       string `json:"n"`
+	Receiver   string `json:"r,omitempty"`
+	Kind       string `json:"k,omitempty"`
+	Module     string `json:"m,omitempty"`
+	ImportPath string `json:"i,omitempty"`
+}
+
+// cursorAt returns the cursor following sym.
+func cursorAt(sym symbol) pageCursor {
+	return pageCursor{
+		Path:       sym.Path,
+		Line:       sym.Line,
+		Character:  sym.Character,
+		Name:       sym.Name,
+		Receiver:   sym.Receiver,
+		Kind:       sym.Kind,
+		Module:     sym.Module,
+		ImportPath: sym.ImportPath,
+	}
+}
+
+// symbol returns a symbol ordered as the one c follows.
+func (c pageCursor) symbol() symbol {
+	return symbol{
+		Path:       c.Path,
+		Line:       c.Line,
+		Character:  c.Character,
+		Name:       c.Name,
+		Receiver:   c.Receiver,
+		Kind:       c.Kind,
+		Module:     c.Module,
+		ImportPath: c.ImportPath,
+	}
+}
+
+func (c pageCursor) token() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func parseCursor(token string) (*pageCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid continuation token")
+	}
+	var c pageCursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("invalid continuation token")
+	}
+	return &c, nil
+}
+
+// runServe implements the serve command, which scans dir, if given, once
+// and then answers queries from clients, one JSON request per line, each
+// with an envelope on a single line. Clients may register further
+// workspaces. The symbols are updated as files change, and saved when the
+// server is stopped by SIGINT or SIGTERM, for the next to restore.
+func runServe(args []string) error {
+	*compactFlag = true // one response per line
+
+	// A second signal kills the server while it saves the symbols.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	ws := newWorkspaceSet()
+	load := func() {
+		defer ws.setReady()
+		if len(args) == 0 {
+			return
+		}
+		dir, _ := parseArgs(args)
+		start := time.Now()
+		srv := ws.register(dir)
+		if *statsFlag {
+			st := srv.status()
+			fmt.Fprintf(os.Stderr, "files: %d; errors: %d; elapsed: %.1fms\n", st.Files, st.Errors, ms(time.Since(start)))
+		}
+	}
+
+	if err := ws.watchConfig(commands["serve"], configRoot(args)); err != nil {
+		logger.Warn("not watching the configuration for changes", "err", err)
+	}
+
+	var err error
+	if *httpFlag != "" {
+		// Health probes are answered while the workspace is scanned,
+		// and /readyz once it is.
+		go load()
+		err = ws.serveHTTP(ctx, *httpFlag)
+	} else {
+		load()
+		err = ws.serveLines(ctx)
+	}
+	if ctx.Err() != nil {
+		ws.saveSnapshots()
+		return nil
+	}
+	return err
+}
+
+// serveLines answers the requests of clients of the line protocol, on the
+// address given by -listen, until ctx is done.
+func (ws *workspaceSet) serveLines(ctx context.Context) error {
+	l, err := listen()
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+	logger.Info("serving", "addr", l.Addr().String())
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go ws.serveConn(conn)
+	}
+}
+
+// listen listens on the address given by -listen.
+func listen() (net.Listener, error) {
+	network, addr := "tcp", *listenFlag
+	if strings.HasPrefix(addr, "unix:") {
+		network, addr = "unix", strings.TrimPrefix(addr, "unix:")
+		os.Remove(addr) // left behind by an earlier server
+	}
+	return net.Listen(network, addr)
+}
+
+// load scans the workspace, replacing the symbols held by s. Files that
+// have not changed since the last load are not parsed again.
+func (s *symbolServer) load() {
+	s.loadContext(context.Background())
+}
+
+// loadContext loads like load, scanning under ctx, which may carry a
+// function to give the progress of the scan to.
+func (s *symbolServer) loadContext(ctx context.Context) {
+	s.loading.Lock()
+	defer s.loading.Unlock()
+	flagsMu.RLock()
+	defer flagsMu.RUnlock()
+	start := time.Now()
+	s.mu.RLock()
+	cache := newFileSymbolCache(s.files)
+	s.mu.RUnlock()
+
+	sum := scanCached(ctx, s.dir, "", cache, func([]symbol) {})
+	observeLoad(cache)
+
+	var syms []symbol
+	for _, name := range sortedFiles(cache.seen) {
+		syms = append(syms, cache.seen[name].syms...)
+	}
+	if *withImplementsFlag {
+		errs := symbols.Implements(context.Background(), typeCheckOptions(s.dir), syms)
+		sum.errors = append(sum.errors, errs...)
+	}
+	trigrams := newTrigramIndex(len(syms), func(i int) string { return syms[i].Name })
+
+	s.mu.Lock()
+	s.files = cache.seen
+	s.syms = syms
+	s.trigrams = trigrams
+	s.errors = sum.errors
+	s.loaded = time.Now()
+	s.loadTime = s.loaded.Sub(start)
+	if s.updated != nil {
+		close(s.updated)
+	}
+	s.updated = make(chan struct{})
+	s.mu.Unlock()
+	logger.Debug("loaded symbols", "dir", s.dir, "files", len(cache.seen), "symbols", len(syms), "errors", len(sum.errors), "elapsed", s.loadTime)
+}
+
+// loadAndWatch loads under ctx like loadContext and then keeps the symbols
+// up to date as files change, warning if they cannot be watched.
+func (s *symbolServer) loadAndWatch(ctx context.Context) {
+	s.loadContext(ctx)
+	if err := s.watch(); err != nil {
+		// Still useful, but results go stale.
+		logger.Warn("not watching for changes", "dir", s.dir, "err", err)
+	}
+}
+
+// updates returns a channel closed once the symbols held by s are next
+// updated.
+func (s *symbolServer) updates() <-chan struct{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.updated
+}
+
+// A serverStatus describes the symbols held by a server.
+type serverStatus struct {
+	Dir      string    `json:"dir"`
+	Files    int       `json:"files"`
+	Symbols  int       `json:"symbols"`
+	Errors   int       `json:"errors"`
+	LoadedAt time.Time `json:"loadedAt"` // when the workspace was last scanned
+	LoadMs   float64   `json:"loadMs"`   // taken by that scan
+}
+
+// isLoaded reports whether s holds the symbols of a first load.
+func (s *symbolServer) isLoaded() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.files != nil
+}
+
+func (s *symbolServer) status() serverStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return serverStatus{
+		Dir:      s.dir,
+		Files:    len(s.files),
+		Symbols:  len(s.syms),
+		Errors:   len(s.errors),
+		LoadedAt: s.loaded.UTC(),
+		LoadMs:   ms(s.loadTime),
+	}
+}
+
+// source is a symbolSource answering from the symbols held by s. These
+// were filtered by -only-prefix and -pkg-name when loaded, so only the
+// query is matched, leaving the flags to change while it runs.
+func (s *symbolServer) source(ctx context.Context, dir, query string, found func([]symbol)) *scanSummary {
+	sum := new(scanSummary)
+	var syms []symbol
+	s.mu.RLock()
+	if candidates, ok := s.trigrams.candidates(query); ok {
+		for _, c := range candidates {
+			if sym := s.syms[c]; symbols.MatchQuery(&sym, query) {
+				syms = append(syms, sym)
+			}
+		}
+	} else {
+		for _, sym := range s.syms {
+			if symbols.MatchQuery(&sym, query) {
+				syms = append(syms, sym)
+			}
+		}
+	}
+	sum.errors = append([]scanError(nil), s.errors...)
+	s.mu.RUnlock()
+	found(syms)
+	return sum
+}
+
+// serveConn answers the requests read from conn until it is closed.
+func (ws *workspaceSet) serveConn(conn net.Conn) {
+	defer conn.Close()
+	// The line protocol has no authentication, so only local clients may
+	// have the server read other directories.
+	addr := conn.RemoteAddr()
+	local := addr.Network() == "unix" || isLoopback(addr.String())
+	r := bufio.NewScanner(conn)
+	w := bufio.NewWriter(conn)
+	for r.Scan() {
+		var req serveRequest
+		err := json.Unmarshal(r.Bytes(), &req)
+		if err == nil {
+			err = ws.handle(w, req, local)
+		}
+		if err != nil {
+			b, _ := json.Marshal(map[string]string{"error": err.Error()})
+			fmt.Fprintln(w, string(b))
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// handle writes the answer to req to w, on a line. Workspaces are only
+// registered and unregistered for local clients.
+func (ws *workspaceSet) handle(w io.Writer, req serveRequest, local bool) error {
+	if (req.Op == "register" || req.Op == "unregister") && !local {
+		return fmt.Errorf("%s is only accepted from the loopback interface or a Unix socket", req.Op)
+	}
+	switch req.Op {
+	case "", "search":
+		srv, err := ws.lookup(req.Root)
+		if err != nil {
+			return err
+		}
+		env, err := srv.answer(req)
+		if err != nil {
+			return err
+		}
+		return writeEnvelope(w, env)
+	case "register":
+		if req.Root == "" {
+			return fmt.Errorf("register needs a root")
+		}
+		ws.register(req.Root)
+	case "unregister":
+		if err := ws.unregister(req.Root); err != nil {
+			return err
+		}
+	case "workspaces":
+	default:
+		return fmt.Errorf("unknown op %q", req.Op)
+	}
+	b, err := json.Marshal(map[string]interface{}{"workspaces": ws.list()})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
+
+// answer returns the envelope of the symbols matching req, one page of them
+// if req has a limit.
+func (s *symbolServer) answer(req serveRequest) (envelope, error) {
+	start := time.Now()
+	defer func() { observeQuery(time.Since(start)) }()
+	less := symbolOrders["path"]
+	var after *symbol
+	if req.ContinuationToken != "" {
+		c, err := parseCursor(req.ContinuationToken)
+		if err != nil {
+			return envelope{}, err
+		}
+		sym := c.symbol()
+		after = &sym
+	}
+	query := strings.ToLower(req.Query)
+	syms := make([]symbol, 0)
+	sum := s.source(context.Background(), s.dir, query, func(found []symbol) {
+		for _, sym := range found {
+			if req.Kind != "" && sym.Kind != req.Kind {
+				continue
+			}
+			if after == nil || less(*after, sym) {
+				syms = append(syms, sym)
+			}
+		}
+	})
+	sort.Slice(syms, func(i, j int) bool { return less(syms[i], syms[j]) })
+
+	env := envelope{
+		Scope:  scope{Dir: s.dir, Query: query},
+		Errors: sum.errors,
+	}
+	if req.Limit > 0 && len(syms) > req.Limit {
+		syms = syms[:req.Limit]
+		last := syms[len(syms)-1] // before its path is rewritten
+		env.ContinuationToken = cursorAt(last).token()
+	}
+	rewritePaths(syms)
+	env.Symbols = syms
+	return env, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAnswerPagesAcrossTies(t *testing.T) {
+	// Promoted methods share the position of the method they promote, and
+	// a file reached as part of several modules repeats its symbols.
+	syms := []symbol{
+		{Name: "Close", Kind: "func", Path: "/w/a.go", Line: 3, Receiver: "File"},
+		{Name: "Close", Kind: "func", Path: "/w/a.go", Line: 3, Receiver: "Conn"},
+		{Name: "Close", Kind: "func", Path: "/w/a.go", Line: 3, Receiver: "Pipe"},
+		{Name: "Open", Kind: "func", Path: "/w/b.go", Line: 1, Module: "x@v1.0.0", ImportPath: "x"},
+		{Name: "Open", Kind: "func", Path: "/w/b.go", Line: 1, Module: "x@v1.1.0", ImportPath: "x"},
+		{Name: "Open", Kind: "func", Path: "/w/b.go", Line: 1, Module: "x@v1.1.0", ImportPath: "x/v1"},
+		{Name: "Open", Kind: "type", Path: "/w/b.go", Line: 1, Module: "x@v1.1.0", ImportPath: "x/v1"},
+	}
+	s := &symbolServer{dir: "/w", syms: syms}
+	all, err := s.answer(serveRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, limit := range []int{1, 2, 3} {
+		var got []symbol
+		req := serveRequest{Limit: limit}
+		for {
+			env, err := s.answer(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, env.Symbols.([]symbol)...)
+			if env.ContinuationToken == "" {
+				break
+			}
+			if len(got) > len(syms) {
+				t.Fatalf("limit %d: more than %d symbols paged", limit, len(syms))
+			}
+			req.ContinuationToken = env.ContinuationToken
+		}
+		if !reflect.DeepEqual(got, all.Symbols.([]symbol)) {
+			t.Errorf("limit %d: paged\n%v\nwant\n%v", limit, got, all.Symbols)
+		}
+	}
+}
+
+func TestParseCursorRejectsGarbage(t *testing.T) {
+	for _, token := range []string{"!!", "bm90IGpzb24"} {
+		if _, err := parseCursor(token); err == nil {
+			t.Errorf("parseCursor(%q) succeeded", token)
+		}
+	}
+}
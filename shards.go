@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// shardManifest is the name of the file listing the shards in an output
+// directory.
+const shardManifest = "manifest.json"
+
+// writeShards writes the symbols of each package to its own JSON file in
+// dir, named after a hash of the import path, and a manifest mapping import
+// paths to file names. Shards whose contents did not change are not
+// rewritten, so consumers can reload only the shards with a newer
+// modification time. Shards of packages that no longer have symbols are
+// removed.
+func writeShards(dir string, syms []symbol) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	byPkg := make(map[string][]symbol)
+	for _, s := range syms {
+		key := s.ImportPath
+		if key == "" {
+			key = s.Package
+		}
+		byPkg[key] = append(byPkg[key], s)
+	}
+
+	manifest := make(map[string]string)
+	for pkg, psyms := range byPkg {
+		name := shardName(pkg)
+		manifest[pkg] = name
+		b, err := marshalJSON(jsonSymbols(psyms))
+		if err != nil {
+			return err
+		}
+		if err := writeFileIfChanged(filepath.Join(dir, name), append(b, '\n')); err != nil {
+			return err
+		}
+	}
+
+	// Remove shards left over from earlier runs.
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	current := make(map[string]bool)
+	for _, name := range manifest {
+		current[name] = true
+	}
+	for _, fi := range entries {
+		name := fi.Name()
+		if isShardName(name) && !current[name] {
+			os.Remove(filepath.Join(dir, name))
+		}
+	}
+
+	b, err := marshalJSON(manifest)
+	if err != nil {
+		return err
+	}
+	return writeFileIfChanged(filepath.Join(dir, shardManifest), append(b, '\n'))
+}
+
+// shardName returns the file name of the shard for the import path.
+func shardName(importPath string) string {
+	sum := sha256.Sum256([]byte(importPath))
+	return hex.EncodeToString(sum[:8]) + ".json"
+}
+
+// isShardName reports whether name has the form of the names returned by
+// shardName, so that other files in the directory are left alone.
+func isShardName(name string) bool {
+	hash := strings.TrimSuffix(name, ".json")
+	if len(hash) != 16 || hash == name {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
+
+// writeFileIfChanged atomically replaces the file at path with data,
+// unless it already has that content.
+func writeFileIfChanged(path string, data []byte) error {
+	if old, err := ioutil.ReadFile(path); err == nil && bytes.Equal(old, data) {
+		return nil
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(0644)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"go/build"
+	"os"
+	"strconv"
+	"time"
+)
+
+// snapshotFile returns the path of the file holding the symbols of s once
+// the server stops, which depends on -fast besides the options keying the
+// parse cache.
+func (s *symbolServer) snapshotFile() (string, error) {
+	flagsMu.RLock()
+	defer flagsMu.RUnlock()
+	return cacheFile("serve", s.dir, append(parseCacheKey(), strconv.FormatBool(*fastFlag))...)
+}
+
+// saveSnapshot saves the files held by s and their symbols, for the next
+// server to restore.
+func (s *symbolServer) saveSnapshot() error {
+	path, err := s.snapshotFile()
+	if err != nil {
+		return err
+	}
+	flagsMu.RLock()
+	tags := build.Default.BuildTags
+	flagsMu.RUnlock()
+	idx := &symbolIndex{
+		Version: indexVersion,
+		Root:    s.dir,
+		Tags:    tags,
+		Created: time.Now(),
+	}
+	s.mu.RLock()
+	idx.setFiles(s.files)
+	s.mu.RUnlock()
+	return saveIndex(path, idx)
+}
+
+// restoreSnapshot sets the files held by s to those saved by an earlier
+// server, if any. They are not answered from: the next load reuses the
+// symbols of those whose size and modification time, or else contents,
+// are unchanged, and parses the others.
+func (s *symbolServer) restoreSnapshot() {
+	path, err := s.snapshotFile()
+	if err != nil {
+		return
+	}
+	idx, err := readIndex(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warn("not restoring the symbols saved", "dir", s.dir, "err", err)
+		}
+		return
+	}
+	files := idx.cachedFiles()
+	idx.close()
+	s.mu.Lock()
+	s.files = files
+	s.mu.Unlock()
+	logger.Debug("restored the symbols saved", "dir", s.dir, "files", len(files), "saved", idx.Created)
+}
+
+// saveSnapshots saves the symbols of each workspace of ws.
+func (ws *workspaceSet) saveSnapshots() {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+	for _, srv := range ws.servers {
+		if err := srv.saveSnapshot(); err != nil {
+			logger.Error("saving the symbols", "dir", srv.dir, "err", err)
+			continue
+		}
+		logger.Info("saved the symbols", "dir", srv.dir)
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestSnapshotFileKeyedByParseOptions(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	s := &symbolServer{dir: t.TempDir()}
+	for _, f := range []*bool{fieldsFlag, packageDocsFlag, withSource} {
+		before, err := s.snapshotFile()
+		if err != nil {
+			t.Fatal(err)
+		}
+		*f = !*f
+		after, err := s.snapshotFile()
+		*f = !*f
+		if err != nil {
+			t.Fatal(err)
+		}
+		if before == after {
+			t.Errorf("snapshot file %s does not depend on the option", before)
+		}
+	}
+}
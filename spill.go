@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"context"
+	"encoding/gob"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var maxMemory byteSize
+
+func init() {
+	flag.Var(&maxMemory, "max-memory", "spill collected symbols to temporary files beyond `size` bytes (with suffix K, M or G)")
+}
+
+// byteSize is a flag.Value holding a number of bytes.
+type byteSize int64
+
+func (b *byteSize) String() string { return strconv.FormatInt(int64(*b), 10) }
+
+func (b *byteSize) Set(s string) error {
+	if s == "" {
+		return fmt.Errorf("empty size")
+	}
+	mult := int64(1)
+	switch strings.ToUpper(s[len(s)-1:]) {
+	case "K":
+		mult = 1 << 10
+	case "M":
+		mult = 1 << 20
+	case "G":
+		mult = 1 << 30
+	}
+	if mult > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid size %q", s)
+	}
+	*b = byteSize(n * mult)
+	return nil
+}
+
+// symbolOverhead approximates the memory used by a symbol besides its
+// strings.
+const symbolOverhead = 200
+
+// spillBatch is the number of symbols handed to the formatter at a time
+// when reading them back.
+const spillBatch = 1000
+
+// A spiller collects symbols in memory up to a budget, beyond which it
+// writes them in sorted runs to temporary files.
+type spiller struct {
+	less  func(a, b symbol) bool // nil to keep them in the order found
+	limit int64
+
+	syms []symbol
+	size int64
+	runs []*os.File
+}
+
+func newSpiller(limit int64, less func(a, b symbol) bool) *spiller {
+	return &spiller{less: less, limit: limit}
+}
+
+// add collects syms, spilling them if they exceed the budget.
+func (s *spiller) add(syms []symbol) error {
+	for _, sym := range syms {
+		s.syms = append(s.syms, sym)
+		s.size += symbolOverhead + int64(len(sym.Name)+len(sym.Path)+len(sym.Receiver)+len(sym.Signature)+len(sym.Source)+len(sym.Doc)+len(sym.Summary))
+	}
+	if s.size > s.limit {
+		return s.spill()
+	}
+	return nil
+}
+
+// spill writes the collected symbols to a new run.
+func (s *spiller) spill() error {
+	s.sort()
+	f, err := ioutil.TempFile("", "gosymbols-spill-")
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, f)
+	w := bufio.NewWriter(f)
+	enc := gob.NewEncoder(w)
+	for _, sym := range s.syms {
+		if err := enc.Encode(newIndexEntry(sym)); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	s.syms, s.size = nil, 0
+	return nil
+}
+
+func (s *spiller) sort() {
+	if s.less != nil {
+		sort.Slice(s.syms, func(i, j int) bool { return s.less(s.syms[i], s.syms[j]) })
+	}
+}
+
+// each calls batch with all collected symbols, in order.
+func (s *spiller) each(batch func([]symbol) error) error {
+	s.sort()
+	if len(s.runs) == 0 {
+		return batch(s.syms)
+	}
+
+	// Merge the runs and the symbols still in memory, the latter as one
+	// more run.
+	h := &runHeap{less: s.less}
+	for _, f := range s.runs {
+		r := &spillRun{dec: gob.NewDecoder(bufio.NewReader(f))}
+		if err := r.advance(); err != nil {
+			return err
+		}
+		if !r.done {
+			h.runs = append(h.runs, r)
+		}
+	}
+	if len(s.syms) > 0 {
+		r := &spillRun{mem: s.syms}
+		r.advance()
+		h.runs = append(h.runs, r)
+	}
+	heap.Init(h)
+
+	out := make([]symbol, 0, spillBatch)
+	for h.Len() > 0 {
+		r := h.runs[0]
+		out = append(out, r.head)
+		if err := r.advance(); err != nil {
+			return err
+		}
+		if r.done {
+			heap.Pop(h)
+		} else {
+			heap.Fix(h, 0)
+		}
+		if len(out) == spillBatch {
+			if err := batch(out); err != nil {
+				return err
+			}
+			out = out[:0]
+		}
+	}
+	return batch(out)
+}
+
+// close removes the temporary files.
+func (s *spiller) close() {
+	for _, f := range s.runs {
+		f.Close()
+		os.Remove(f.Name())
+	}
+}
+
+// writeSpilled writes the symbols from source matching query to w in order,
+// spilling them to temporary files while they are collected if they
+// exceed -max-memory. The symbols passed through take.
+func writeSpilled(ctx context.Context, w io.Writer, source symbolSource, dir, query string, format formatter, less func(a, b symbol) bool, take func([]symbol) []symbol) error {
+	start := time.Now()
+	sp := newSpiller(int64(maxMemory), less)
+	defer sp.close()
+	var spillErr error
+	var count int
+	sum := source(ctx, dir, query, func(found []symbol) {
+		found = take(found)
+		count += len(found)
+		if spillErr == nil {
+			spillErr = sp.add(found)
+		}
+	})
+	if spillErr != nil {
+		return spillErr
+	}
+	sum.stats.Symbols = count
+	notePartial(ctx, sum)
+
+	outputStart := time.Now()
+	err := sp.each(func(syms []symbol) error {
+		rewritePaths(syms)
+		return format(w, syms)
+	})
+	finishStats(&sum.stats, start, outputStart)
+	printStats(&sum.stats)
+	return err
+}
+
+// A spillRun reads back the symbols of a run, from a file or memory.
+type spillRun struct {
+	dec  *gob.Decoder
+	mem  []symbol
+	head symbol
+	done bool
+}
+
+func (r *spillRun) advance() error {
+	if r.dec == nil {
+		if len(r.mem) == 0 {
+			r.done = true
+			return nil
+		}
+		r.head, r.mem = r.mem[0], r.mem[1:]
+		return nil
+	}
+	var e indexEntry
+	if err := r.dec.Decode(&e); err == io.EOF {
+		r.done = true
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("reading spilled symbols: %v", err)
+	}
+	r.head = e.symbol()
+	return nil
+}
+
+// runHeap orders runs by their next symbol. Without an order, runs are
+// read in no particular order.
+type runHeap struct {
+	less func(a, b symbol) bool
+	runs []*spillRun
+}
+
+func (h *runHeap) Len() int { return len(h.runs) }
+func (h *runHeap) Less(i, j int) bool {
+	if h.less == nil {
+		return false
+	}
+	return h.less(h.runs[i].head, h.runs[j].head)
+}
+func (h *runHeap) Swap(i, j int)      { h.runs[i], h.runs[j] = h.runs[j], h.runs[i] }
+func (h *runHeap) Push(x interface{}) { h.runs = append(h.runs, x.(*spillRun)) }
+func (h *runHeap) Pop() interface{} {
+	r := h.runs[len(h.runs)-1]
+	h.runs = h.runs[:len(h.runs)-1]
+	return r
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/newhook/go-symbols/symbols"
+)
+
+var slowFlag = flag.Int("slow-packages", 0, "report the `n` packages and files that took longest to read and parse")
+
+// finishStats fills in the timings of a scan that started at start and
+// whose output started at outputStart, if it is non-zero.
+func finishStats(s *scanStats, start, outputStart time.Time) {
+	now := time.Now()
+	s.ElapsedMs = ms(now.Sub(start))
+	s.WalkMs = ms(s.Walk)
+	s.ParseMs = ms(s.Parse)
+	if !outputStart.IsZero() {
+		s.OutputMs = ms(now.Sub(outputStart))
+	}
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// printStats writes s to standard error if -stats or -slow-packages was
+// given and the statistics were not already part of the output.
+func printStats(s *scanStats) {
+	if *statsFlag && *envelopeFlag {
+		return
+	}
+	if *statsFlag {
+		fmt.Fprintf(os.Stderr, "packages: %d, files: %d, symbols: %d, errors: %d\n", s.Packages, s.Files, s.Symbols, s.Errors)
+		fmt.Fprintf(os.Stderr, "elapsed: %.1fms (walk %.1fms, parse %.1fms summed over workers, output %.1fms)\n",
+			s.ElapsedMs, s.WalkMs, s.ParseMs, s.OutputMs)
+	}
+	for _, list := range []struct {
+		what  string
+		times []symbols.Timing
+	}{{"packages", s.SlowPackages}, {"files", s.SlowFiles}} {
+		if len(list.times) > 0 {
+			fmt.Fprintf(os.Stderr, "slowest %s:\n", list.what)
+		}
+		for _, t := range list.times {
+			fmt.Fprintf(os.Stderr, "%10.1fms  %s\n", t.Ms, t.Name)
+		}
+	}
+}
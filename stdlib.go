@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	stdlibFlag = flag.Bool("stdlib", false, "also report symbols of the standard library, from an index shared by all workspaces")
+	gorootFlag = flag.String("goroot", "", "with -stdlib, report the standard library of the Go installation in `dir`, or of the go command of that name")
+)
+
+// stdlibRoot returns the Go installation whose standard library -stdlib
+// reports: that of -goroot if it is a directory, or else of the go
+// command it names, such as go1.21.0 installed by golang.org/dl.
+func stdlibRoot() (string, error) {
+	if *gorootFlag == "" {
+		return build.Default.GOROOT, nil
+	}
+	if fi, err := os.Stat(*gorootFlag); err == nil && fi.IsDir() {
+		return filepath.Abs(*gorootFlag)
+	}
+	out, err := exec.Command(*gorootFlag, "env", "GOROOT").Output()
+	if err != nil {
+		return "", fmt.Errorf("-goroot %s is neither a directory nor a go command: %v", *gorootFlag, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// goVersion returns the version of the Go installation at goroot.
+func goVersion(goroot string) string {
+	if b, err := ioutil.ReadFile(filepath.Join(goroot, "VERSION")); err == nil {
+		if i := bytes.IndexByte(b, '\n'); i >= 0 {
+			b = b[:i]
+		}
+		return string(bytes.TrimSpace(b))
+	}
+	if goroot != build.Default.GOROOT {
+		// A development build, which only its go command knows.
+		if out, err := exec.Command(filepath.Join(goroot, "bin", "go"), "env", "GOVERSION").Output(); err == nil {
+			return strings.TrimSpace(string(out))
+		}
+	}
+	return runtime.Version()
+}
+
+// loadStdlib returns the index of the standard library in goroot,
+// building it on first use. As the standard library only changes with the
+// Go version, the index is keyed by it rather than checked for changed
+// files. If corrupted is not nil, the index is rebuilt from it, parsing
+// only its corrupted files again.
+func loadStdlib(ctx context.Context, goroot string, corrupted *symbolIndex) (*symbolIndex, error) {
+	path, err := cacheFile("stdlib", goroot, goVersion(goroot), strconv.FormatBool(parseDocs), strconv.FormatBool(*withSource), strconv.FormatBool(*packageDocsFlag))
+	if err != nil {
+		return nil, err
+	}
+	var old map[string]*cachedFile
+	if corrupted != nil {
+		old = corrupted.cachedFiles()
+	} else if idx, err := readIndex(path); err == nil {
+		return idx, nil
+	}
+
+	// The whole library is indexed, whatever the -only-prefix.
+	prefixes := onlyPrefix
+	onlyPrefix = nil
+	cache := newFileSymbolCache(old)
+	sum := scanCached(ctx, goroot, "", cache, func([]symbol) {})
+	idx := &symbolIndex{
+		Version: indexVersion,
+		Root:    goroot,
+		Tags:    build.Default.BuildTags,
+		Created: time.Now(),
+		Errors:  sum.errors,
+	}
+	idx.setFiles(cache.seen)
+	onlyPrefix = prefixes
+	if ctx.Err() != nil {
+		return idx, nil // incomplete, so not saved
+	}
+	return idx, saveIndex(path, idx)
+}
+
+// withStdlib returns a symbolSource adding the symbols of the standard
+// library in goroot matching the query to those of source.
+func withStdlib(goroot string, source symbolSource) symbolSource {
+	return func(ctx context.Context, dir, query string, found func([]symbol)) *scanSummary {
+		idx, err := loadStdlib(ctx, goroot, nil)
+		var syms []symbol
+		if idx != nil {
+			syms = idx.matching(query)
+			if bad := idx.corrupted(); len(bad) > 0 {
+				logger.Warn("rebuilding the corrupted files of the standard library index", "goroot", goroot, "files", len(bad))
+				corrupted := idx
+				if idx, err = loadStdlib(ctx, goroot, corrupted); idx != nil {
+					syms = idx.matching(query)
+				}
+				corrupted.close()
+			}
+			if idx != nil {
+				idx.close()
+			}
+		}
+		if len(syms) > 0 {
+			found(syms)
+		}
+		sum := source(ctx, dir, query, found)
+		if err != nil {
+			sum.errors = append(sum.errors, scanError{Dir: goroot, Phase: "index", Message: "indexing the standard library: " + err.Error()})
+			sum.stats.Errors++
+		}
+		return sum
+	}
+}
@@ -0,0 +1,245 @@
+package symbols
+
+import (
+	"context"
+	"fmt"
+	"go/constant"
+	"go/types"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// API returns the exported API of the packages of the tree opts.Dir as
+// sorted lines in the format of the api files of the Go distribution, one
+// per feature, such as
+//
+//	pkg net/http, func ListenAndServe(string, Handler) error
+//	pkg net/http, method (*Server) Close() error
+//	pkg net/http, type Server struct, Addr string
+//
+// Other packages are qualified by name. Main, internal and vendored
+// packages have no API. The packages are type-checked with the go command
+// or the packages driver; the errors of those that cannot be are returned.
+func API(ctx context.Context, opts Options) ([]string, []Error) {
+	mode := packages.NeedName | packages.NeedFiles | packages.NeedSyntax | packages.NeedTypes | packages.NeedTypesInfo
+	pkgs, errs := loadTyped(ctx, opts, packageDirs(ctx, opts), mode)
+	features := make(map[string]bool)
+	for _, p := range pkgs {
+		if p.Types == nil || p.Name == "main" || strings.Contains(p.ID, " [") || strings.HasSuffix(p.PkgPath, "_test") || !publicPath(p.PkgPath) {
+			continue
+		}
+		w := &apiWriter{pkg: p.Types, features: features}
+		scope := p.Types.Scope()
+		for _, name := range scope.Names() {
+			if obj := scope.Lookup(name); obj.Exported() {
+				w.object(obj)
+			}
+		}
+	}
+	lines := make([]string, 0, len(features))
+	for f := range features {
+		lines = append(lines, f)
+	}
+	sort.Strings(lines)
+	return lines, errs
+}
+
+// publicPath reports whether packages outside the module may import the
+// package with import path path.
+func publicPath(path string) bool {
+	for _, elem := range strings.Split(path, "/") {
+		if elem == "internal" || elem == "vendor" {
+			return false
+		}
+	}
+	return true
+}
+
+// An apiWriter records the features of the API of pkg.
+type apiWriter struct {
+	pkg      *types.Package
+	features map[string]bool
+}
+
+func (w *apiWriter) emit(format string, args ...interface{}) {
+	for i, arg := range args {
+		if t, ok := arg.(types.Type); ok {
+			args[i] = w.typeString(t)
+		}
+	}
+	w.features["pkg "+w.pkg.Path()+", "+fmt.Sprintf(format, args...)] = true
+}
+
+// typeString writes t with the types of other packages qualified by the
+// names of their packages.
+func (w *apiWriter) typeString(t types.Type) string {
+	return types.TypeString(t, func(p *types.Package) string {
+		if p == w.pkg {
+			return ""
+		}
+		return p.Name()
+	})
+}
+
+func (w *apiWriter) object(obj types.Object) {
+	switch obj := obj.(type) {
+	case *types.Const:
+		w.emit("const %s %s", obj.Name(), w.constType(obj))
+		w.emit("const %s = %s", obj.Name(), obj.Val().ExactString())
+	case *types.Var:
+		w.emit("var %s %s", obj.Name(), obj.Type())
+	case *types.Func:
+		w.emit("func %s%s", obj.Name(), w.signature(obj.Type().(*types.Signature)))
+	case *types.TypeName:
+		w.typeName(obj)
+	}
+}
+
+// constType describes the type of an untyped constant as the api tool
+// does, such as ideal-int.
+func (w *apiWriter) constType(obj *types.Const) string {
+	basic, ok := obj.Type().(*types.Basic)
+	if !ok || basic.Info()&types.IsUntyped == 0 {
+		return w.typeString(obj.Type())
+	}
+	switch obj.Val().Kind() {
+	case constant.Bool:
+		return "ideal-bool"
+	case constant.String:
+		return "ideal-string"
+	case constant.Int:
+		if basic.Kind() == types.UntypedRune {
+			return "ideal-char"
+		}
+		return "ideal-int"
+	case constant.Float:
+		return "ideal-float"
+	case constant.Complex:
+		return "ideal-complex"
+	}
+	return basic.String()
+}
+
+func (w *apiWriter) typeName(obj *types.TypeName) {
+	name := obj.Name()
+	if obj.IsAlias() {
+		// The alias itself would print as its own name.
+		rhs := types.Unalias(obj.Type())
+		if alias, ok := obj.Type().(*types.Alias); ok {
+			rhs = alias.Rhs()
+		}
+		w.emit("type %s = %s", name, rhs)
+		return
+	}
+	named := obj.Type().(*types.Named)
+	if tparams := named.TypeParams(); tparams.Len() > 0 {
+		name += w.typeParams(tparams)
+	}
+	switch u := named.Underlying().(type) {
+	case *types.Struct:
+		w.emit("type %s struct", name)
+		for i := 0; i < u.NumFields(); i++ {
+			f := u.Field(i)
+			if !f.Exported() {
+				continue
+			}
+			if f.Embedded() {
+				w.emit("type %s struct, embedded %s", name, f.Type())
+			} else {
+				w.emit("type %s struct, %s %s", name, f.Name(), f.Type())
+			}
+		}
+	case *types.Interface:
+		var methods []string
+		unexported := false
+		for i := 0; i < u.NumMethods(); i++ {
+			m := u.Method(i)
+			if !m.Exported() {
+				unexported = true
+				continue
+			}
+			methods = append(methods, m.Name())
+			w.emit("type %s interface, %s%s", name, m.Name(), w.signature(m.Type().(*types.Signature)))
+		}
+		// Embedded interfaces and unions of terms such as ~int | ~float64
+		// constrain the type set beyond the methods.
+		for i := 0; i < u.NumEmbeddeds(); i++ {
+			w.emit("type %s interface, embedded %s", name, u.EmbeddedType(i))
+		}
+		sort.Strings(methods)
+		if unexported {
+			methods = append(methods, "unexported methods")
+		}
+		w.emit("type %s interface { %s }", name, strings.Join(methods, ", "))
+	default:
+		w.emit("type %s %s", name, u)
+	}
+	for i := 0; i < named.NumMethods(); i++ {
+		m := named.Method(i)
+		if !m.Exported() {
+			continue
+		}
+		sig := m.Type().(*types.Signature)
+		recv := obj.Name()
+		if _, ok := sig.Recv().Type().(*types.Pointer); ok {
+			recv = "*" + recv
+		}
+		if rtparams := sig.RecvTypeParams(); rtparams.Len() > 0 {
+			var names []string
+			for j := 0; j < rtparams.Len(); j++ {
+				names = append(names, rtparams.At(j).Obj().Name())
+			}
+			recv += "[" + strings.Join(names, ", ") + "]"
+		}
+		w.emit("method (%s) %s%s", recv, m.Name(), w.signature(sig))
+	}
+}
+
+// signature writes sig without parameter names, as in func(string) error.
+func (w *apiWriter) signature(sig *types.Signature) string {
+	var b strings.Builder
+	if tparams := sig.TypeParams(); tparams.Len() > 0 {
+		b.WriteString(w.typeParams(tparams))
+	}
+	b.WriteString("(")
+	params := sig.Params()
+	for i := 0; i < params.Len(); i++ {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		t := params.At(i).Type()
+		if sig.Variadic() && i == params.Len()-1 {
+			b.WriteString("..." + w.typeString(t.(*types.Slice).Elem()))
+		} else {
+			b.WriteString(w.typeString(t))
+		}
+	}
+	b.WriteString(")")
+	results := sig.Results()
+	switch results.Len() {
+	case 0:
+	case 1:
+		b.WriteString(" " + w.typeString(results.At(0).Type()))
+	default:
+		b.WriteString(" (")
+		for i := 0; i < results.Len(); i++ {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(w.typeString(results.At(i).Type()))
+		}
+		b.WriteString(")")
+	}
+	return b.String()
+}
+
+func (w *apiWriter) typeParams(tparams *types.TypeParamList) string {
+	var list []string
+	for i := 0; i < tparams.Len(); i++ {
+		tp := tparams.At(i)
+		list = append(list, tp.Obj().Name()+" "+w.typeString(tp.Constraint()))
+	}
+	return "[" + strings.Join(list, ", ") + "]"
+}
@@ -0,0 +1,106 @@
+package symbols
+
+import (
+	"bytes"
+	"go/build/constraint"
+	"strings"
+)
+
+// knownOS and knownArch are the GOOS and GOARCH values a file name can be
+// constrained to, as in go/build.
+var (
+	knownOS = stringSet("aix", "android", "darwin", "dragonfly", "freebsd", "hurd", "illumos", "ios", "js",
+		"linux", "nacl", "netbsd", "openbsd", "plan9", "solaris", "wasip1", "windows", "zos")
+	knownArch = stringSet("386", "amd64", "amd64p32", "arm", "armbe", "arm64", "arm64be", "loong64",
+		"mips", "mipsle", "mips64", "mips64le", "mips64p32", "mips64p32le", "ppc", "ppc64", "ppc64le",
+		"riscv", "riscv64", "s390", "s390x", "sparc", "sparc64", "wasm")
+)
+
+func stringSet(list ...string) map[string]bool {
+	m := make(map[string]bool, len(list))
+	for _, s := range list {
+		m[s] = true
+	}
+	return m
+}
+
+// fileConstraint returns the build constraint of the Go file named name,
+// whose contents are src: that of its //go:build line, or of its // +build
+// lines, and the GOOS and GOARCH its name ends with, such as
+// "linux && amd64" for foo_linux_amd64.go. It is empty for files built
+// everywhere.
+func fileConstraint(name string, src []byte) string {
+	var expr constraint.Expr
+	var plusBuild []constraint.Expr
+	for len(src) > 0 {
+		line := src
+		if i := bytes.IndexByte(src, '\n'); i >= 0 {
+			line, src = src[:i], src[i+1:]
+		} else {
+			src = nil
+		}
+		text := strings.TrimSpace(string(line))
+		if text == "" {
+			continue
+		}
+		if !strings.HasPrefix(text, "//") {
+			break // the package clause, or a block comment
+		}
+		if constraint.IsGoBuild(text) {
+			if x, err := constraint.Parse(text); err == nil {
+				expr = x
+			}
+		} else if constraint.IsPlusBuild(text) {
+			if x, err := constraint.Parse(text); err == nil {
+				plusBuild = append(plusBuild, x)
+			}
+		}
+	}
+	if expr == nil {
+		// Without a //go:build line, all // +build lines must hold.
+		for _, x := range plusBuild {
+			expr = andExpr(expr, x)
+		}
+	}
+
+	name = strings.TrimSuffix(strings.TrimSuffix(name, ".go"), "_test")
+	if i := strings.IndexByte(name, '_'); i >= 0 {
+		elems := strings.Split(name[i:], "_")
+		n := len(elems)
+		var tags []string
+		switch {
+		case n >= 3 && knownOS[elems[n-2]] && knownArch[elems[n-1]]:
+			tags = elems[n-2:]
+		case knownOS[elems[n-1]] || knownArch[elems[n-1]]:
+			tags = elems[n-1:]
+		}
+		for _, t := range tags {
+			if !requires(expr, t) {
+				expr = andExpr(expr, &constraint.TagExpr{Tag: t})
+			}
+		}
+	}
+	if expr == nil {
+		return ""
+	}
+	return expr.String()
+}
+
+func andExpr(x, y constraint.Expr) constraint.Expr {
+	if x == nil {
+		return y
+	}
+	return &constraint.AndExpr{X: x, Y: y}
+}
+
+// requires reports whether x, a conjunction, has the tag name as one of
+// its terms.
+func requires(x constraint.Expr, name string) bool {
+	switch x := x.(type) {
+	case *constraint.AndExpr:
+		return requires(x.X, name) || requires(x.Y, name)
+	case *constraint.TagExpr:
+		return x.Tag == name
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package symbols
+
+import (
+	"context"
+	"go/types"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// ErrorDecls returns the named types of the tree opts.Dir that implement
+// error, themselves or through pointers to them, and its package-level
+// variables named Err... holding errors, the sentinel errors callers
+// compare with. Types are symbols of kind "type" implementing "error";
+// variables are of kind "var" with their type as Signature. Both carry the
+// Object, Type and DefinedIn set by Typecheck. Every package of the tree is
+// type-checked to find them, with the go command or the packages driver;
+// the errors of those that cannot be are returned. Generic types and the
+// declarations of test files are left out.
+func ErrorDecls(ctx context.Context, opts Options) ([]Symbol, []Error) {
+	mode := packages.NeedName | packages.NeedFiles | packages.NeedSyntax | packages.NeedTypes | packages.NeedTypesInfo
+	pkgs, errs := loadTyped(ctx, opts, packageDirs(ctx, opts), mode)
+	errorType := types.Universe.Lookup("error").Type().Underlying().(*types.Interface)
+
+	// Test variants of packages declare their types again.
+	seen := make(map[string]bool)
+	var syms []Symbol
+	for _, p := range pkgs {
+		if p.Types == nil {
+			continue
+		}
+		scope := p.Types.Scope()
+		for _, name := range scope.Names() {
+			obj := scope.Lookup(name)
+			key := obj.Pkg().Path() + "." + name
+			if seen[key] || !obj.Pos().IsValid() {
+				continue
+			}
+			var s Symbol
+			switch obj := obj.(type) {
+			case *types.TypeName:
+				named, ok := obj.Type().(*types.Named)
+				if !ok || obj.IsAlias() || named.TypeParams().Len() > 0 {
+					continue
+				}
+				if !types.Implements(named, errorType) && !types.Implements(types.NewPointer(named), errorType) {
+					continue
+				}
+				s = Symbol{Kind: "type", Implements: []string{"error"}}
+				switch named.Underlying().(type) {
+				case *types.Struct:
+					s.TypeKind = "struct"
+				case *types.Interface:
+					s.TypeKind = "interface"
+				}
+			case *types.Var:
+				if !strings.HasPrefix(name, "Err") || !types.Implements(obj.Type(), errorType) {
+					continue
+				}
+				s = Symbol{Kind: "var", Signature: types.TypeString(obj.Type(), types.RelativeTo(obj.Pkg()))}
+			default:
+				continue
+			}
+			pos := positionOf(p.Fset, obj.Pos(), opts)
+			if strings.HasSuffix(pos.path, "_test.go") {
+				continue
+			}
+			seen[key] = true
+			s.Name = name
+			s.Package = p.Types.Name()
+			s.ImportPath, s.Vendored = unvendoredPath(p.PkgPath)
+			s.Path, s.Line, s.Character = pos.path, pos.line, pos.character
+			setTypeInfo(&s, obj)
+			syms = append(syms, s)
+		}
+	}
+	return syms, errs
+}
@@ -0,0 +1,63 @@
+package symbols
+
+import (
+	"go/ast"
+	"go/token"
+)
+
+// An Extractor finds symbols other than the declarations of package-level
+// functions, methods and types, such as the routes a web framework
+// registers, the providers of a dependency injection tool or go:generate
+// directives. Extractors are given in Options.Extractors.
+type Extractor interface {
+	// Extract returns the symbols of a parsed file, positioned with
+	// SetPosition. Their Package, ImportPath and Module are filled in if
+	// unset, and their positions mapped through //line directives unless
+	// Options.IgnoreLineDirectives is set.
+	// It may be called concurrently for different files.
+	Extract(fset *token.FileSet, file *ast.File, src []byte) []Symbol
+}
+
+// SetPosition sets the Path, Line, Character and Offset of s to those of
+// pos in the file holding it.
+func (s *Symbol) SetPosition(fset *token.FileSet, pos token.Pos) {
+	p := fset.PositionFor(pos, false)
+	s.Path, s.Line, s.Character, s.Offset = p.Filename, p.Line-1, p.Column-1, p.Offset
+}
+
+// positionFor returns the position of pos, mapped through //line
+// directives if lineDirectives is set. Those giving no column place pos at
+// the start of its line.
+func positionFor(fset *token.FileSet, pos token.Pos, lineDirectives bool) token.Position {
+	p := fset.PositionFor(pos, lineDirectives)
+	if p.Column == 0 && p.Line > 0 {
+		p.Column = 1
+	}
+	return p
+}
+
+// extract appends the symbols that extractors find in f, of the package
+// with the given import path and module, to syms. With lineDirectives,
+// the positions in f are mapped through its //line directives.
+func extract(extractors []Extractor, fset *token.FileSet, f *ast.File, src []byte, importPath, module string, lineDirectives bool, syms []Symbol) []Symbol {
+	file := fset.File(f.Pos())
+	for _, e := range extractors {
+		for _, s := range e.Extract(fset, f, src) {
+			if lineDirectives && file != nil && s.Path == file.Name() && s.Offset <= file.Size() {
+				p := positionFor(fset, file.Pos(s.Offset), true)
+				s.Path, s.Line, s.Character = p.Filename, p.Line-1, p.Column-1
+			}
+			if s.Package == "" {
+				s.Package = f.Name.Name
+			}
+			if s.ImportPath == "" {
+				s.ImportPath = importPath
+			}
+			if s.Module == "" {
+				s.Module = module
+			}
+			syms = append(syms, s)
+		}
+	}
+	return syms
+}
@@ -0,0 +1,293 @@
+package symbols
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"go/types"
+	"strings"
+)
+
+// A declScanner finds the top-level declarations of a file from its
+// tokens, skipping function bodies and initializers without building an
+// AST for them. Only the headers of declarations are parsed.
+type declScanner struct {
+	s    scanner.Scanner
+	file *token.File
+	src  []byte
+	errs scanner.ErrorList
+
+	pos token.Pos
+	tok token.Token
+	lit string
+
+	comments   []*ast.Comment // comment group being scanned
+	commentEnd int            // line the comment group ends on
+	doc        []*ast.Comment // comment group ending on the line before the current token
+	tokenLine  int            // line of the last token other than a comment
+}
+
+// fastSymbols returns the package name and the symbols declared at the top
+// level of the file named filename with contents src, in the package with
+// the given import path and module, with their doc comments and source
+// lines if requested. With syntax errors past the package clause, it
+// returns them along with the symbols found anyway.
+func fastSymbols(fset *token.FileSet, importPath, module, filename string, src []byte, docs, withSource, lineDirectives bool) (string, []Symbol, error) {
+	d := &declScanner{
+		file: fset.AddFile(filename, -1, len(src)),
+		src:  src,
+	}
+	d.s.Init(d.file, src, func(pos token.Position, msg string) {
+		d.errs.Add(pos, msg)
+	}, scanner.ScanComments)
+	d.next()
+
+	if d.tok != token.PACKAGE {
+		return "", nil, fmt.Errorf("%s: expected 'package', found %s", fset.Position(d.pos), d.tok)
+	}
+	d.next()
+	if d.tok != token.IDENT {
+		return "", nil, fmt.Errorf("%s: expected package name, found %s", fset.Position(d.pos), d.tok)
+	}
+	pkgName := d.lit
+	d.skipDecl()
+
+	var syms []Symbol
+	add := func(name string, pos, declPos token.Pos, kind string, doc []*ast.Comment) *Symbol {
+		p := positionFor(fset, pos, lineDirectives)
+		s := Symbol{
+			Package:   pkgName,
+			Path:      p.Filename,
+			Name:      name,
+			Kind:      kind,
+			Line:      p.Line - 1,
+			Character: p.Column - 1,
+			Module:    module,
+
+			ImportPath: importPath,
+			Offset:     p.Offset,
+		}
+		if docs && doc != nil {
+			s.Doc = synopsis(&ast.CommentGroup{List: doc})
+		}
+		if withSource {
+			s.Source = d.sourceLine(declPos)
+		}
+		syms = append(syms, s)
+		return &syms[len(syms)-1]
+	}
+
+	for d.tok != token.EOF {
+		switch d.tok {
+		case token.FUNC:
+			if err := d.funcDecl(add); err != nil {
+				return "", nil, err
+			}
+		case token.TYPE:
+			doc := d.doc
+			d.next()
+			if d.tok != token.LPAREN {
+				if err := d.typeSpec(add, doc); err != nil {
+					return "", nil, err
+				}
+				continue
+			}
+			d.next()
+			for d.tok != token.RPAREN && d.tok != token.EOF {
+				if err := d.typeSpec(add, d.doc); err != nil {
+					return "", nil, err
+				}
+			}
+			d.next()
+			d.skipDecl()
+		default:
+			pos := d.pos
+			d.skipDecl()
+			if d.pos == pos && d.tok != token.EOF {
+				// A stray closing token, which the parser would
+				// reject.
+				d.errs.Add(d.file.Position(pos), "unexpected "+d.tok.String())
+				d.next()
+			}
+		}
+	}
+	return pkgName, syms, d.errs.Err()
+}
+
+// next advances to the next token, collecting the comments before it.
+func (d *declScanner) next() {
+	d.doc = nil
+	for {
+		pos, tok, lit := d.s.Scan()
+		if tok == token.COMMENT {
+			line := d.file.Line(pos)
+			if len(d.comments) == 0 && line == d.tokenLine {
+				continue // a comment trailing a line of code
+			}
+			if len(d.comments) > 0 && line > d.commentEnd+1 {
+				d.comments = nil // not adjacent to the previous comment
+			}
+			d.comments = append(d.comments, &ast.Comment{Slash: pos, Text: lit})
+			d.commentEnd = d.file.Line(pos + token.Pos(len(lit)) - 1)
+			continue
+		}
+		if tok == token.SEMICOLON && lit == "\n" {
+			// Inserted semicolons don't separate a doc comment from
+			// its declaration.
+			d.pos, d.tok, d.lit = pos, tok, lit
+			return
+		}
+		d.tokenLine = d.file.Line(pos)
+		if len(d.comments) > 0 && d.commentEnd == d.tokenLine-1 {
+			d.doc = d.comments
+		}
+		d.comments = nil
+		d.pos, d.tok, d.lit = pos, tok, lit
+		return
+	}
+}
+
+// skipDecl skips to the token following the end of the current
+// declaration or spec, which is not consumed if it closes a group.
+func (d *declScanner) skipDecl() {
+	depth := 0
+	for d.tok != token.EOF {
+		switch d.tok {
+		case token.LPAREN, token.LBRACE, token.LBRACK:
+			depth++
+		case token.RPAREN, token.RBRACE, token.RBRACK:
+			if depth == 0 {
+				return // end of a group
+			}
+			depth--
+		case token.SEMICOLON:
+			if depth == 0 {
+				d.next()
+				return
+			}
+		}
+		d.next()
+	}
+	if depth > 0 {
+		d.errs.Add(d.file.Position(d.pos), "unexpected EOF")
+	}
+}
+
+// skipBalanced skips from an opening token past its closing token.
+func (d *declScanner) skipBalanced() {
+	depth := 0
+	for d.tok != token.EOF {
+		switch d.tok {
+		case token.LPAREN, token.LBRACE, token.LBRACK:
+			depth++
+		case token.RPAREN, token.RBRACE, token.RBRACK:
+			depth--
+		}
+		d.next()
+		if depth == 0 {
+			return
+		}
+	}
+	d.errs.Add(d.file.Position(d.pos), "unexpected EOF")
+}
+
+func (d *declScanner) offset(pos token.Pos) int {
+	return d.file.Offset(pos)
+}
+
+// funcDecl reads a function declaration, parsing only its header.
+func (d *declScanner) funcDecl(add func(name string, pos, declPos token.Pos, kind string, doc []*ast.Comment) *Symbol) error {
+	doc, declPos := d.doc, d.pos
+	d.next()
+	var recv string
+	if d.tok == token.LPAREN {
+		start := d.offset(d.pos)
+		d.skipBalanced()
+		recv = string(d.src[start:d.offset(d.pos)])
+	}
+	if d.tok != token.IDENT {
+		d.skipDecl()
+		return nil
+	}
+	name, pos := d.lit, d.pos
+	d.next()
+
+	// The signature ends at the body, or the end of the declaration for
+	// functions implemented elsewhere. Braces of struct and interface
+	// types in the signature don't start the body.
+	start := d.offset(d.pos)
+	depth := 0
+	literal := false
+	for d.tok != token.EOF {
+		if depth == 0 && (d.tok == token.SEMICOLON || d.tok == token.LBRACE && !literal) {
+			break
+		}
+		switch d.tok {
+		case token.STRUCT, token.INTERFACE:
+			literal = true
+		case token.LPAREN, token.LBRACE, token.LBRACK:
+			depth++
+		case token.RPAREN, token.RBRACE, token.RBRACK:
+			depth--
+			if depth == 0 {
+				literal = false
+			}
+		}
+		d.next()
+	}
+	sig := string(d.src[start:d.offset(d.pos)])
+	if d.tok == token.LBRACE {
+		d.skipBalanced()
+	}
+	d.skipDecl()
+
+	header := "package p\nfunc " + recv + " _" + sig
+	f, err := parser.ParseFile(token.NewFileSet(), "", header, 0)
+	if err != nil {
+		return fmt.Errorf("%s: %v", d.file.Position(pos), err)
+	}
+	fn := f.Decls[0].(*ast.FuncDecl)
+	s := add(name, pos, declPos, "func", doc)
+	if fn.Recv != nil && len(fn.Recv.List) > 0 {
+		s.Receiver = types.ExprString(fn.Recv.List[0].Type)
+	}
+	s.Signature = strings.TrimPrefix(types.ExprString(fn.Type), "func")
+	return nil
+}
+
+// typeSpec reads a type spec, parsing only its type.
+func (d *declScanner) typeSpec(add func(name string, pos, declPos token.Pos, kind string, doc []*ast.Comment) *Symbol, doc []*ast.Comment) error {
+	if d.tok != token.IDENT {
+		d.skipDecl()
+		return nil
+	}
+	name, pos := d.lit, d.pos
+	d.next()
+	start := d.offset(d.pos)
+	d.skipDecl()
+	end := d.offset(d.pos)
+	if d.tok == token.EOF {
+		end = len(d.src)
+	}
+	spec := bytes.TrimRight(d.src[start:end], "; \t\r\n")
+
+	f, err := parser.ParseFile(token.NewFileSet(), "", "package p\ntype _ "+string(spec), 0)
+	if err != nil {
+		return fmt.Errorf("%s: %v", d.file.Position(pos), err)
+	}
+	s := add(name, pos, pos, "type", doc)
+	s.TypeKind, s.Embeds = typeInfo(f.Decls[0].(*ast.GenDecl).Specs[0].(*ast.TypeSpec).Type)
+	return nil
+}
+
+// sourceLine returns the trimmed text of the line containing pos.
+func (d *declScanner) sourceLine(pos token.Pos) string {
+	line := d.src[d.offset(d.file.LineStart(d.file.Line(pos))):]
+	if i := bytes.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	return string(bytes.TrimSpace(line))
+}
@@ -0,0 +1,123 @@
+package symbols
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strconv"
+	"strings"
+)
+
+// StructFields is an Extractor finding the fields of the struct types
+// declared at package level, as symbols of kind "field" with the name of
+// the struct type as Receiver, the field type as Signature and the keys of
+// the field's tag, such as json, db or yaml, in Tags. Embedded fields are
+// named after their type.
+type StructFields struct{}
+
+func (StructFields) Extract(fset *token.FileSet, file *ast.File, src []byte) []Symbol {
+	var syms []Symbol
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			ts := spec.(*ast.TypeSpec)
+			st, ok := ts.Type.(*ast.StructType)
+			if !ok {
+				continue
+			}
+			for _, field := range st.Fields.List {
+				typ := types.ExprString(field.Type)
+				var tags map[string]string
+				if field.Tag != nil {
+					if tag, err := strconv.Unquote(field.Tag.Value); err == nil {
+						tags = parseTag(tag)
+					}
+				}
+				names := field.Names
+				if len(names) == 0 {
+					names = []*ast.Ident{embeddedName(field.Type)}
+				}
+				for _, id := range names {
+					if id == nil {
+						continue
+					}
+					s := Symbol{
+						Name:      id.Name,
+						Kind:      "field",
+						Receiver:  ts.Name.Name,
+						Signature: typ,
+						Tags:      tags,
+					}
+					s.SetPosition(fset, id.Pos())
+					syms = append(syms, s)
+				}
+			}
+		}
+	}
+	return syms
+}
+
+// embeddedName returns the identifier naming an embedded field of type
+// typ, or nil if it is not a valid one.
+func embeddedName(typ ast.Expr) *ast.Ident {
+	for {
+		switch t := typ.(type) {
+		case *ast.Ident:
+			return t
+		case *ast.StarExpr:
+			typ = t.X
+		case *ast.SelectorExpr:
+			return t.Sel
+		case *ast.IndexExpr:
+			typ = t.X
+		case *ast.IndexListExpr:
+			typ = t.X
+		default:
+			return nil
+		}
+	}
+}
+
+// parseTag returns the values of the keys of a struct tag in the
+// conventional format `key:"value" key:"value"`, as reflect.StructTag.Get
+// reads them. It stops at the first malformed pair.
+func parseTag(tag string) map[string]string {
+	var keys map[string]string
+	for tag != "" {
+		tag = strings.TrimLeft(tag, " ")
+		i := 0
+		for i < len(tag) && tag[i] > ' ' && tag[i] != ':' && tag[i] != '"' && tag[i] != 0x7f {
+			i++
+		}
+		if i == 0 || i+1 >= len(tag) || tag[i] != ':' || tag[i+1] != '"' {
+			break
+		}
+		key := tag[:i]
+		tag = tag[i+1:]
+
+		// The value is a quoted string, which may hold escaped quotes.
+		i = 1
+		for i < len(tag) && tag[i] != '"' {
+			if tag[i] == '\\' {
+				i++
+			}
+			i++
+		}
+		if i >= len(tag) {
+			break
+		}
+		value, err := strconv.Unquote(tag[:i+1])
+		if err != nil {
+			break
+		}
+		tag = tag[i+1:]
+		if keys == nil {
+			keys = make(map[string]string)
+		}
+		keys[key] = value
+	}
+	return keys
+}
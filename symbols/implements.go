@@ -0,0 +1,101 @@
+package symbols
+
+import (
+	"context"
+	"go/types"
+	"sort"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// Implements sets the Implements of the named types among syms, found by
+// scanning the tree opts.Dir, to the interfaces of the tree that they or
+// pointers to them implement, and the ImplementedBy of its interfaces to
+// the types implementing them. Both are lists of names qualified by import
+// path, such as example.com/foo.Handler. Every package of the tree is
+// type-checked to find them, with the go command or the packages driver;
+// the errors of those that cannot be are returned. Generic types and empty
+// interfaces have no relations.
+func Implements(ctx context.Context, opts Options, syms []Symbol) []Error {
+	for i := range syms {
+		syms[i].Implements, syms[i].ImplementedBy = nil, nil
+	}
+	mode := packages.NeedName | packages.NeedFiles | packages.NeedSyntax | packages.NeedTypes | packages.NeedTypesInfo
+	pkgs, errs := loadTyped(ctx, opts, packageDirs(ctx, opts), mode)
+
+	// Test variants of packages declare their types again; relations are
+	// recorded by name, so each is found once.
+	var concrete, ifaces []*types.TypeName
+	byPos := symbolPositions(syms)
+	bySym := make(map[string][]*Symbol)
+	for _, p := range pkgs {
+		if p.Types == nil {
+			continue
+		}
+		scope := p.Types.Scope()
+		for _, name := range scope.Names() {
+			obj, ok := scope.Lookup(name).(*types.TypeName)
+			if !ok || obj.IsAlias() {
+				continue
+			}
+			named, ok := obj.Type().(*types.Named)
+			if !ok || named.TypeParams().Len() > 0 {
+				continue
+			}
+			if s := byPos[positionOf(p.Fset, obj.Pos(), opts)]; s != nil {
+				bySym[qualifiedType(obj)] = append(bySym[qualifiedType(obj)], s)
+			}
+			if iface, ok := named.Underlying().(*types.Interface); ok {
+				if iface.NumMethods() > 0 && iface.IsMethodSet() {
+					ifaces = append(ifaces, obj)
+				}
+			} else {
+				concrete = append(concrete, obj)
+			}
+		}
+	}
+
+	implements := make(map[string]map[string]bool)
+	implementedBy := make(map[string]map[string]bool)
+	add := func(m map[string]map[string]bool, from, to string) {
+		if m[from] == nil {
+			m[from] = make(map[string]bool)
+		}
+		m[from][to] = true
+	}
+	for _, t := range concrete {
+		for _, i := range ifaces {
+			if ctx.Err() != nil {
+				return errs
+			}
+			iface := i.Type().Underlying().(*types.Interface)
+			if types.Implements(t.Type(), iface) || types.Implements(types.NewPointer(t.Type()), iface) {
+				add(implements, qualifiedType(t), qualifiedType(i))
+				add(implementedBy, qualifiedType(i), qualifiedType(t))
+			}
+		}
+	}
+	for name, syms := range bySym {
+		for _, s := range syms {
+			s.Implements = sortedKeys(implements[name])
+			s.ImplementedBy = sortedKeys(implementedBy[name])
+		}
+	}
+	return errs
+}
+
+func qualifiedType(obj *types.TypeName) string {
+	return obj.Pkg().Path() + "." + obj.Name()
+}
+
+func sortedKeys(set map[string]bool) []string {
+	if len(set) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,78 @@
+package symbols
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// SetImportable sets the Importable of syms, symbols of the tree dir, to
+// whether the packages at the root of the tree may refer to them: that of
+// dir within its module, those of the modules of its go.work workspace, or
+// that of dir within a GOPATH. Symbols of main packages and test files
+// never are, nor are those of internal packages outside the tree rooted at
+// the parent of their internal directory. Vendored packages are taken by
+// their canonical import paths, by which the code of the tree imports them.
+// A GOPATH itself, or a tree in none of these, has no package at its root,
+// so only the symbols of packages that are not internal are importable.
+func SetImportable(dir string, syms []Symbol) {
+	roots := rootImportPaths(filepath.SplitList(dir)[0])
+	for i := range syms {
+		s := &syms[i]
+		ok := s.Package != "main" && !strings.HasSuffix(s.Path, "_test.go") && importableFrom(s.ImportPath, roots)
+		s.Importable = &ok
+	}
+}
+
+// rootImportPaths returns the import paths of the packages at the root of
+// the tree dir.
+func rootImportPaths(dir string) []string {
+	if w := findWorkspace(dir); w != nil {
+		var paths []string
+		for _, m := range w.modules {
+			paths = append(paths, m.importPath(m.dir))
+		}
+		return paths
+	}
+	if m := findModule(dir); m != nil {
+		return []string{m.importPath(dir)}
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil
+	}
+	if src := srcDir(abs); src != "" {
+		if rel, err := filepath.Rel(src, abs); err == nil {
+			return []string{filepath.ToSlash(rel)}
+		}
+	}
+	return nil
+}
+
+// importableFrom reports whether a package with import path path may be
+// imported by one of the packages with import paths roots, or their
+// subpackages, under the rules for internal and vendor directories.
+func importableFrom(path string, roots []string) bool {
+	elems := strings.Split(path, "/")
+	last := -1
+	for i, elem := range elems {
+		if elem == "internal" || elem == "vendor" {
+			last = i
+		}
+	}
+	if last < 0 {
+		return true
+	}
+	parent := strings.Join(elems[:last], "/")
+	for _, root := range roots {
+		if parent == "" {
+			// A top-level internal directory, as in the standard
+			// library, is for the paths without a domain name.
+			if !strings.Contains(strings.SplitN(root, "/", 2)[0], ".") {
+				return true
+			}
+		} else if root == parent || strings.HasPrefix(root, parent+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,7 @@
+//go:build !windows
+
+package symbols
+
+// longPath returns the name by which path is opened, which only differs
+// from path on Windows.
+func longPath(path string) string { return path }
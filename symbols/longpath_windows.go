@@ -0,0 +1,29 @@
+package symbols
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// maxPath is the length beyond which Windows rejects paths not in the
+// extended-length form. Directories must leave room for an 8.3 file name.
+const maxPath = 260 - 12
+
+// longPath returns the name by which path is opened: for paths too long
+// for Windows otherwise, the extended-length form \\?\C:\dir or
+// \\?\UNC\server\share\dir. The os package only does so for absolute
+// paths without . or .. elements, which relative paths in deep vendor
+// and module trees are not. Paths in results stay as scanned.
+func longPath(path string) string {
+	if len(path) < maxPath || strings.HasPrefix(path, `\\?\`) {
+		return path
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		return `\\?\UNC\` + abs[2:]
+	}
+	return `\\?\` + abs
+}
@@ -0,0 +1,244 @@
+package symbols
+
+import (
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// A goModule is a module defined by a go.mod file.
+type goModule struct {
+	path string // module path
+	dir  string // holding go.mod
+	file *modfile.File
+}
+
+// findModule returns the module containing dir, or nil if there is none.
+func findModule(dir string) *goModule {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil
+	}
+	for {
+		if m := readModule(dir); m != nil {
+			return m
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil
+		}
+		dir = parent
+	}
+}
+
+// readModule returns the module defined by the go.mod file in dir, or nil
+// if it has none or it cannot be parsed.
+func readModule(dir string) *goModule {
+	gomod := filepath.Join(dir, "go.mod")
+	data, err := ioutil.ReadFile(longPath(gomod))
+	if err != nil {
+		return nil
+	}
+	f, err := modfile.ParseLax(gomod, data, nil)
+	if err != nil || f.Module == nil {
+		return nil
+	}
+	return &goModule{path: f.Module.Mod.Path, dir: dir, file: f}
+}
+
+// importPath returns the import path of the package in dir, a directory
+// within m.
+func (m *goModule) importPath(dir string) string {
+	prefix := m.path
+	if prefix == "std" {
+		prefix = "" // the standard library, whose paths have no prefix
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return prefix
+	}
+	rel, err := filepath.Rel(m.dir, abs)
+	if err != nil || rel == "." {
+		return prefix
+	}
+	return path.Join(prefix, filepath.ToSlash(rel))
+}
+
+// A goWorkspace is a multi-module workspace defined by a go.work file.
+type goWorkspace struct {
+	dir     string // holding go.work
+	file    *modfile.WorkFile
+	modules []*goModule // used by the workspace
+}
+
+// findWorkspace returns the workspace containing dir, or nil if there is
+// none or GOWORK is off.
+func findWorkspace(dir string) *goWorkspace {
+	if os.Getenv("GOWORK") == "off" {
+		return nil
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil
+	}
+	for {
+		gowork := filepath.Join(dir, "go.work")
+		if data, err := ioutil.ReadFile(gowork); err == nil {
+			f, err := modfile.ParseWork(gowork, data, nil)
+			if err != nil {
+				return nil
+			}
+			w := &goWorkspace{dir: dir, file: f}
+			for _, u := range f.Use {
+				if m := readModule(localDir(dir, u.Path)); m != nil {
+					w.modules = append(w.modules, m)
+				}
+			}
+			return w
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil
+		}
+		dir = parent
+	}
+}
+
+// A replacement is a replace directive of the go.mod or go.work file in
+// dir.
+type replacement struct {
+	*modfile.Replace
+	dir string
+}
+
+// requirements returns the roots of the modules required by mods, at the
+// highest version any of them requires, in the module cache or the local
+// directories replacing them, and errors for those missing from the cache.
+// Replacements listed first take precedence.
+func requirements(mods []*goModule, replace []replacement) ([]walkRoot, []error) {
+	versions := make(map[string]string)
+	var paths []string
+	for _, m := range mods {
+		versions[m.path] = "" // scanned already
+	}
+	for _, m := range mods {
+		for _, r := range m.file.Require {
+			v, ok := versions[r.Mod.Path]
+			if ok && v == "" {
+				continue
+			}
+			if !ok {
+				paths = append(paths, r.Mod.Path)
+			}
+			if !ok || semver.Compare(r.Mod.Version, v) > 0 {
+				versions[r.Mod.Path] = r.Mod.Version
+			}
+		}
+		for _, rep := range m.file.Replace {
+			replace = append(replace, replacement{rep, m.dir})
+		}
+	}
+
+	var roots []walkRoot
+	var errs []error
+	for _, p := range paths {
+		mod := module.Version{Path: p, Version: versions[p]}
+		dir := ""
+		for _, rep := range replace {
+			if rep.Old.Path != mod.Path || rep.Old.Version != "" && rep.Old.Version != mod.Version {
+				continue
+			}
+			if rep.New.Version == "" {
+				dir = localDir(rep.dir, rep.New.Path)
+			} else {
+				mod = rep.New
+			}
+			break
+		}
+		id := "" // of a module in the cache
+		if dir == "" {
+			var err error
+			if dir, err = moduleCacheDir(mod); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			id = mod.String()
+		}
+		if _, err := os.Stat(dir); err != nil {
+			errs = append(errs, fmt.Errorf("module %s is not in the module cache; run go mod download", mod))
+			continue
+		}
+		roots = append(roots, walkRoot{dir: dir, importPath: p, modules: true, skipNested: true, module: id})
+	}
+	return roots, errs
+}
+
+// localDir returns the directory named by path in a file in dir.
+func localDir(dir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// moduleCacheRoot returns the directory of the module cache.
+func moduleCacheRoot() string {
+	if cache := os.Getenv("GOMODCACHE"); cache != "" {
+		return cache
+	}
+	gopath := os.Getenv("GOPATH")
+	if gopath == "" {
+		gopath = build.Default.GOPATH
+	}
+	return filepath.Join(filepath.SplitList(gopath)[0], "pkg", "mod")
+}
+
+// isModuleCache reports whether dir is the module cache, or a copy of one
+// with its download cache.
+func isModuleCache(dir string) bool {
+	if CanonicalDir(dir) == CanonicalDir(moduleCacheRoot()) {
+		return true
+	}
+	fi, err := os.Stat(filepath.Join(dir, "cache", "download"))
+	return err == nil && fi.IsDir()
+}
+
+// cachedModule returns the module whose directory in the module cache is
+// rel, relative to the cache, if it is the directory of a module.
+func cachedModule(rel string) (module.Version, bool) {
+	i := strings.LastIndex(rel, "@")
+	if i < 0 {
+		return module.Version{}, false
+	}
+	p, err := module.UnescapePath(filepath.ToSlash(rel[:i]))
+	if err != nil {
+		return module.Version{}, false
+	}
+	v, err := module.UnescapeVersion(rel[i+1:])
+	if err != nil {
+		return module.Version{}, false
+	}
+	return module.Version{Path: p, Version: v}, true
+}
+
+// moduleCacheDir returns the directory of mod in the module cache.
+func moduleCacheDir(mod module.Version) (string, error) {
+	cache := moduleCacheRoot()
+	p, err := module.EscapePath(mod.Path)
+	if err != nil {
+		return "", err
+	}
+	v, err := module.EscapeVersion(mod.Version)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cache, p+"@"+v), nil
+}
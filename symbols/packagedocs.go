@@ -0,0 +1,69 @@
+package symbols
+
+import (
+	"go/ast"
+	"go/token"
+	"strings"
+	"unicode"
+)
+
+// PackageDocs is an Extractor finding package comments, usually those of
+// doc.go files, as symbols of kind "package" named after the package, with
+// the first sentence of the comment as Summary. Queries match them by the
+// words of their summary too, as MatchQuery tells.
+type PackageDocs struct{}
+
+func (PackageDocs) Extract(fset *token.FileSet, file *ast.File, src []byte) []Symbol {
+	summary := synopsis(file.Doc)
+	if summary == "" {
+		return nil
+	}
+	s := Symbol{Name: file.Name.Name, Kind: "package", Summary: summary}
+	s.SetPosition(fset, file.Name.Pos())
+	return []Symbol{s}
+}
+
+// MatchQuery reports whether s matches query, in lower case: whether its
+// name contains it or, for package symbols, whether every word of the
+// query, or its stem, starts a word of the summary, so that "png decoding"
+// finds the package documented as a "PNG image decoder".
+func MatchQuery(s *Symbol, query string) bool {
+	if strings.Contains(strings.ToLower(s.Name), query) {
+		return true
+	}
+	if s.Kind != "package" || s.Summary == "" {
+		return false
+	}
+	words := strings.FieldsFunc(strings.ToLower(s.Summary), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	terms := strings.Fields(query)
+	for _, term := range terms {
+		if !startsWord(words, term) {
+			return false
+		}
+	}
+	return len(terms) > 0
+}
+
+// stemSuffixes are the endings dropped from a query word to match other
+// forms of it, longest first.
+var stemSuffixes = []string{"ing", "ion", "ers", "er", "ed", "es", "s"}
+
+// startsWord reports whether term, or its stem if that has at least four
+// letters, starts one of words.
+func startsWord(words []string, term string) bool {
+	stem := term
+	for _, suffix := range stemSuffixes {
+		if t := strings.TrimSuffix(term, suffix); t != term && len(t) >= 4 {
+			stem = t
+			break
+		}
+	}
+	for _, w := range words {
+		if strings.HasPrefix(w, stem) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,190 @@
+package symbols
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// listPackages calls found with the import path, directory and files of
+// each package that the go command lists and opts.OnlyPrefix selects: those
+// of the tree opts.Dir and of the other modules of its workspace, and with
+// opts.Deps those of the modules they require. A tree with a src directory
+// is a GOPATH, whose first entry is listed in GOPATH mode. With a driver, the
+// packages of the tree are those it lists.
+func listPackages(ctx context.Context, opts *Options, haveSrcDir bool, found func(importPath, dir, module string, files []string, err error)) {
+	cfg := &packages.Config{
+		Context: ctx,
+		Mode:    packages.NeedName | packages.NeedFiles,
+		Dir:     filepath.SplitList(opts.Dir)[0],
+		Tests:   true,
+		Overlay: opts.Overlay,
+	}
+	patterns := []string{"./..."}
+	var mods []*goModule
+	driver := packagesDriver() != ""
+	if haveSrcDir && !driver {
+		cfg.Dir = filepath.Join(filepath.SplitList(opts.Dir)[0], "src")
+		cfg.Env = append(os.Environ(), "GOPATH="+opts.Dir, "GO111MODULE=off")
+	}
+	if m := findModule(opts.Dir); m != nil && !haveSrcDir && !driver {
+		mods = []*goModule{m}
+	}
+	if w := findWorkspace(opts.Dir); w != nil && !haveSrcDir && !driver {
+		if mods == nil {
+			patterns = nil // the go command rejects ./... outside the modules
+		}
+		mods = w.modules
+		for _, m := range w.modules {
+			patterns = append(patterns, m.path+"/...")
+		}
+	}
+	if opts.Deps {
+		for _, m := range mods {
+			for _, r := range m.file.Require {
+				patterns = append(patterns, r.Mod.Path+"/...")
+			}
+		}
+	}
+	outside := !haveSrcDir && len(mods) == 0 && !driver
+	if outside {
+		// Outside GOPATH and modules, the go command lists packages in
+		// GOPATH mode with import paths of "_" and their directory, which
+		// are made relative to the tree as when walking it.
+		cfg.Env = append(os.Environ(), "GO111MODULE=off")
+	}
+	root, _ := filepath.Abs(cfg.Dir)
+	pkgs, err := packages.Load(cfg, patterns...)
+	if err != nil {
+		found("", opts.Dir, "", nil, err)
+		return
+	}
+
+	// Test variants repeat the files of the packages they test, and
+	// external test packages share their directory, so files are merged
+	// by directory.
+	type dirFiles struct {
+		importPath string
+		files      map[string]bool
+	}
+	dirs := make(map[string]*dirFiles)
+	reported := make(map[string]bool)
+	for _, p := range pkgs {
+		if strings.HasSuffix(p.ID, ".test") {
+			continue // a generated test main package
+		}
+		if len(p.GoFiles) == 0 {
+			for _, e := range p.Errors {
+				if !reported[e.Msg] {
+					reported[e.Msg] = true
+					found(p.PkgPath, "", "", nil, errors.New(e.Msg))
+				}
+			}
+			continue
+		}
+		for _, f := range p.GoFiles {
+			dir := filepath.Dir(f)
+			d, ok := dirs[dir]
+			if !ok {
+				d = &dirFiles{importPath: strings.TrimSuffix(p.PkgPath, "_test"), files: make(map[string]bool)}
+				if rel, err := filepath.Rel(root, dir); outside && err == nil && rel != "." {
+					d.importPath = filepath.ToSlash(rel)
+				}
+				dirs[dir] = d
+			}
+			d.files[f] = true
+		}
+	}
+
+	names := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		names = append(names, dir)
+	}
+	sort.Strings(names)
+	for _, dir := range names {
+		d := dirs[dir]
+		if allowed, _ := PrefixAllowed(d.importPath, opts.OnlyPrefix); !allowed {
+			continue
+		}
+		files := make([]string, 0, len(d.files))
+		for f := range d.files {
+			files = append(files, f)
+		}
+		sort.Strings(files)
+		found(d.importPath, dir, "", files, nil)
+	}
+}
+
+// noGoFiles returns why the package directory listing list is skipped
+// when it has other sources, such as C or assembly files, but no Go
+// files, or "" if it is not.
+func noGoFiles(list []os.FileInfo) string {
+	var exts []string
+	seen := make(map[string]bool)
+	for _, fi := range list {
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		ext := filepath.Ext(fi.Name())
+		if ext == ".go" {
+			return ""
+		}
+		if otherSourceExts[ext] && !seen[ext] {
+			seen[ext] = true
+			exts = append(exts, ext)
+		}
+	}
+	if len(exts) == 0 {
+		return "" // not a package
+	}
+	sort.Strings(exts)
+	return "no Go files, only " + strings.Join(exts, ", ") + " files"
+}
+
+// otherSourceExts are the extensions of the non-Go source files the go
+// command builds into packages.
+var otherSourceExts = map[string]bool{
+	".c": true, ".h": true, ".cc": true, ".cpp": true, ".cxx": true, ".hh": true, ".hpp": true, ".hxx": true,
+	".m": true, ".f": true, ".F": true, ".for": true, ".f90": true, ".s": true, ".S": true, ".sx": true,
+	".swig": true, ".swigcxx": true, ".syso": true,
+}
+
+// packagesDriver returns the external driver that go/packages asks for
+// packages instead of the go command, found as it does: GOPACKAGESDRIVER,
+// unless it is "off", or else gopackagesdriver on the PATH. Build systems
+// such as Bazel provide one for trees that follow neither GOPATH nor module
+// conventions.
+func packagesDriver() string {
+	driver := os.Getenv("GOPACKAGESDRIVER")
+	switch driver {
+	case "off":
+		return ""
+	case "":
+		driver, _ = exec.LookPath("gopackagesdriver")
+	}
+	return driver
+}
+
+// readPackageDir returns the files of the package in dir: the named files
+// if there are any, and otherwise everything in the directory.
+func readPackageDir(dir string, files []string) ([]os.FileInfo, error) {
+	if files == nil {
+		return ioutil.ReadDir(longPath(dir))
+	}
+	list := make([]os.FileInfo, 0, len(files))
+	for _, f := range files {
+		fi, err := os.Stat(longPath(f))
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, fi)
+	}
+	return list, nil
+}
@@ -0,0 +1,222 @@
+package symbols
+
+import (
+	"bytes"
+	"go/ast"
+	"go/doc"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"strings"
+	"unicode/utf8"
+)
+
+// A visitor collects the symbols of the files of a package matching the
+// query.
+type visitor struct {
+	pkgName     string
+	importPath  string
+	vendored    bool
+	build       string // constraint of the file being visited
+	module      string
+	fset        *token.FileSet
+	query       string
+	packageName string // of the packages selected, if not all
+	withSource  bool
+	syms        []Symbol
+
+	lineDirectives bool // map positions through //line directives
+
+	sources map[string][]byte // file contents, read with withSource
+	genDecl *ast.GenDecl      // enclosing declaration of the specs being visited
+	broken  bool              // the file has syntax errors, and blank names stand for missing ones
+}
+
+func (v *visitor) Visit(node ast.Node) bool {
+	descend := true
+
+	var ident *ast.Ident
+	var decl ast.Node
+	var kind, recv, sig, typeKind string
+	var embeds []string
+	var docs *ast.CommentGroup
+	switch t := node.(type) {
+	case *ast.GenDecl:
+		v.genDecl = t
+
+	case *ast.FuncDecl:
+		kind = "func"
+		ident = t.Name
+		decl = t
+		docs = t.Doc
+		descend = false
+		if t.Recv != nil && len(t.Recv.List) > 0 {
+			recv = types.ExprString(t.Recv.List[0].Type)
+		}
+		sig = strings.TrimPrefix(types.ExprString(t.Type), "func")
+
+	case *ast.TypeSpec:
+		kind = "type"
+		ident = t.Name
+		decl = t
+		docs = t.Doc
+		if docs == nil && v.genDecl != nil && len(v.genDecl.Specs) == 1 {
+			docs = v.genDecl.Doc
+		}
+		descend = false
+		typeKind, embeds = typeInfo(t.Type)
+	}
+
+	if ident != nil && v.broken && ident.Name == "_" {
+		ident = nil
+	}
+	if ident != nil && strings.Contains(strings.ToLower(ident.Name), v.query) {
+		pos := positionFor(v.fset, ident.Pos(), v.lineDirectives)
+		v.syms = append(v.syms, Symbol{
+			Package:   v.pkgName,
+			Path:      pos.Filename,
+			Name:      ident.Name,
+			Kind:      kind,
+			Line:      pos.Line - 1,
+			Character: pos.Column - 1,
+			Receiver:  recv,
+			Signature: sig,
+
+			Module: v.module,
+
+			ImportPath: v.importPath,
+			Vendored:   v.vendored,
+			Build:      v.build,
+			TypeKind:   typeKind,
+			Offset:     pos.Offset,
+			Embeds:     embeds,
+			Doc:        synopsis(docs),
+		})
+		if v.withSource {
+			v.syms[len(v.syms)-1].Source = v.sourceLine(decl.Pos())
+		}
+	}
+
+	return descend
+}
+
+// sanitize returns src with the bytes that are not valid UTF-8 replaced by
+// question marks and the byte order marks past its start by spaces, which
+// the parser rejects, keeping the offsets of everything else. It returns
+// src itself, and false, if it has none. Ported and generated code has
+// them.
+func sanitize(src []byte) ([]byte, bool) {
+	if utf8.Valid(src) && bytes.IndexRune(bytes.TrimPrefix(src, bom), '\uFEFF') < 0 {
+		return src, false
+	}
+	clean := make([]byte, 0, len(src))
+	for i := 0; i < len(src); {
+		r, size := utf8.DecodeRune(src[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			clean = append(clean, '?')
+		case r == '\uFEFF' && i > 0:
+			clean = append(clean, "   "...)
+		default:
+			clean = append(clean, src[i:i+size]...)
+		}
+		i += size
+	}
+	return clean, true
+}
+
+// bom is the UTF-8 byte order mark, which may start a file.
+var bom = []byte("\uFEFF")
+
+// containsFold reports whether src contains the lower-cased query,
+// ignoring case.
+func containsFold(src []byte, query string) bool {
+	return bytes.Contains(bytes.ToLower(src), []byte(query))
+}
+
+// typeInfo returns "struct" or "interface" if typ is such a type, and the
+// types it embeds.
+func typeInfo(typ ast.Expr) (typeKind string, embeds []string) {
+	var fields *ast.FieldList
+	switch t := typ.(type) {
+	case *ast.StructType:
+		typeKind = "struct"
+		fields = t.Fields
+	case *ast.InterfaceType:
+		typeKind = "interface"
+		fields = t.Methods
+	}
+	if fields != nil {
+		for _, field := range fields.List {
+			if len(field.Names) == 0 {
+				embeds = append(embeds, strings.TrimPrefix(types.ExprString(field.Type), "*"))
+			}
+		}
+	}
+	return typeKind, embeds
+}
+
+// reuse adds the symbols from a file that has not changed since it was
+// last parsed that match the query and package name filter.
+func (v *visitor) reuse(syms []Symbol) {
+	for _, s := range syms {
+		if MatchQuery(&s, v.query) && (v.packageName == "" || s.Package == v.packageName) {
+			v.syms = append(v.syms, s)
+		}
+	}
+}
+
+// recoverDecls returns the symbols declared at the top level of src, a
+// file with syntax errors, that are not among parsed, those found by
+// parsing it. After some errors, such as an incomplete statement, the
+// parser takes the declarations that follow to be part of the function
+// body, but tokenizing the file as with Options.Fast still finds them.
+func recoverDecls(importPath, module, filename string, src []byte, docs, withSource, lineDirectives bool, parsed []Symbol) []Symbol {
+	type key struct {
+		name string
+		line int
+	}
+	seen := make(map[key]bool, len(parsed))
+	for _, s := range parsed {
+		seen[key{s.Name, s.Line}] = true
+	}
+	_, syms, _ := fastSymbols(token.NewFileSet(), importPath, module, filename, src, docs, withSource, lineDirectives)
+	var recovered []Symbol
+	for _, s := range syms {
+		if !seen[key{s.Name, s.Line}] {
+			recovered = append(recovered, s)
+		}
+	}
+	return recovered
+}
+
+// synopsis returns the first sentence of the comment group, which is only
+// present if comments were parsed.
+func synopsis(docs *ast.CommentGroup) string {
+	if docs == nil {
+		return ""
+	}
+	return new(doc.Package).Synopsis(docs.Text())
+}
+
+// sourceLine returns the trimmed text of the line containing pos.
+func (v *visitor) sourceLine(pos token.Pos) string {
+	f := v.fset.File(pos)
+	src, ok := v.sources[f.Name()]
+	if !ok {
+		src, _ = ioutil.ReadFile(longPath(f.Name()))
+		if v.sources == nil {
+			v.sources = make(map[string][]byte)
+		}
+		v.sources[f.Name()] = src
+	}
+	if f.Size() != len(src) {
+		return "" // changed since it was parsed
+	}
+	start := f.Offset(f.LineStart(f.Line(pos)))
+	line := src[start:]
+	if i := bytes.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	return string(bytes.TrimSpace(line))
+}
@@ -0,0 +1,66 @@
+package symbols
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+// adaptInterval is how often a workerPool reconsiders its limit.
+const adaptInterval = 100 * time.Millisecond
+
+// A workerPool limits the number of packages parsed at once, to a fixed
+// number if one is given. Otherwise it adapts to where the workers spend their
+// time: it narrows while they mostly wait for the disk, as on a cold cache
+// or a network file system, where more readers only queue up, and widens
+// while they mostly parse, up to twice the number of CPUs.
+type workerPool struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	limit    int
+	max      int
+	active   int
+	adaptive bool
+
+	io, cpu time.Duration // spent by workers since the limit was last set
+	last    time.Time
+}
+
+func newWorkerPool(jobs int) *workerPool {
+	p := &workerPool{limit: jobs, max: jobs, last: time.Now()}
+	if jobs <= 0 {
+		p.limit, p.max, p.adaptive = runtime.NumCPU(), 2*runtime.NumCPU(), true
+	}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+// acquire waits for a worker to be available.
+func (p *workerPool) acquire() {
+	p.mu.Lock()
+	for p.active >= p.limit {
+		p.cond.Wait()
+	}
+	p.active++
+	p.mu.Unlock()
+}
+
+// release releases a worker, which spent io of its time reading files and
+// cpu processing them.
+func (p *workerPool) release(io, cpu time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.active--
+	p.io += io
+	p.cpu += cpu
+	if p.adaptive && time.Since(p.last) >= adaptInterval && p.io+p.cpu > 0 {
+		switch share := float64(p.io) / float64(p.io+p.cpu); {
+		case share > 0.5 && p.limit > 1:
+			p.limit--
+		case share < 0.2 && p.limit < p.max:
+			p.limit++
+		}
+		p.io, p.cpu, p.last = 0, 0, time.Now()
+	}
+	p.cond.Broadcast()
+}
@@ -0,0 +1,83 @@
+package symbols
+
+import (
+	"context"
+	"go/types"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// PromotedMethods returns, for each named non-interface type declared in
+// the tree opts.Dir, the methods it gains by embedding other types, as
+// symbols with the receiver of the outer type and the package of its
+// declaration, but the position of the method declared by the embedded
+// type. They are marked Promoted. Every package of the tree is type-checked
+// to find them, with the go command or the packages driver; the errors of
+// those that cannot be are returned. Generic types are skipped.
+func PromotedMethods(ctx context.Context, opts Options) ([]Symbol, []Error) {
+	mode := packages.NeedName | packages.NeedFiles | packages.NeedSyntax | packages.NeedTypes | packages.NeedTypesInfo
+	pkgs, errs := loadTyped(ctx, opts, packageDirs(ctx, opts), mode)
+
+	// Test variants of packages declare their types again.
+	seen := make(map[string]bool)
+	var syms []Symbol
+	for _, p := range pkgs {
+		if p.Types == nil || strings.HasSuffix(p.PkgPath, "_test") {
+			continue
+		}
+		importPath, vendored := unvendoredPath(p.PkgPath)
+		scope := p.Types.Scope()
+		for _, name := range scope.Names() {
+			obj, ok := scope.Lookup(name).(*types.TypeName)
+			if !ok || obj.IsAlias() || seen[qualifiedType(obj)] {
+				continue
+			}
+			named, ok := obj.Type().(*types.Named)
+			if !ok || named.TypeParams().Len() > 0 || types.IsInterface(named) {
+				continue
+			}
+			seen[qualifiedType(obj)] = true
+			values := types.NewMethodSet(named)
+			pointers := types.NewMethodSet(types.NewPointer(named))
+			for i := 0; i < pointers.Len(); i++ {
+				sel := pointers.At(i)
+				if len(sel.Index()) < 2 {
+					continue // declared by the type itself
+				}
+				m := sel.Obj().(*types.Func)
+				if !m.Exported() && m.Pkg() != obj.Pkg() {
+					continue // not accessible through the outer type
+				}
+				recv := "*" + obj.Name()
+				if values.Lookup(m.Pkg(), m.Name()) != nil {
+					recv = obj.Name()
+				}
+				if !m.Pos().IsValid() {
+					continue
+				}
+				pos := positionOf(p.Fset, m.Pos(), opts)
+				sig := types.TypeString(m.Type(), func(q *types.Package) string {
+					if q == m.Pkg() {
+						return ""
+					}
+					return q.Name()
+				})
+				syms = append(syms, Symbol{
+					Name:       m.Name(),
+					Kind:       "func",
+					Package:    p.Types.Name(),
+					Path:       pos.path,
+					Line:       pos.line,
+					Character:  pos.character,
+					Receiver:   recv,
+					Signature:  strings.TrimPrefix(sig, "func"),
+					Promoted:   true,
+					ImportPath: importPath,
+					Vendored:   vendored,
+				})
+			}
+		}
+	}
+	return syms, errs
+}
@@ -0,0 +1,133 @@
+package symbols
+
+import (
+	"context"
+	"go/ast"
+	"go/build"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// CountReferences sets the References of the exported symbols among syms,
+// found by scanning the tree opts.Dir, to the number of identifiers
+// referring to them in the packages of the tree, tests included. Every
+// package of the tree is type-checked to find them, with the go command or
+// the packages driver; the errors of those that cannot be are returned.
+func CountReferences(ctx context.Context, opts Options, syms []Symbol) []Error {
+	var exported []*Symbol
+	for i := range syms {
+		if s := &syms[i]; ast.IsExported(s.Name) {
+			s.References = new(int)
+			exported = append(exported, s)
+		}
+	}
+	if len(exported) == 0 {
+		return nil
+	}
+	return forEachReference(ctx, opts, syms, func(s *Symbol, from *packages.Package, pos token.Position) {
+		if s.References != nil {
+			*s.References++
+		}
+	})
+}
+
+// UnusedExports returns the exported funcs and types among syms, found by
+// scanning the tree opts.Dir, that no identifier outside the directory of
+// their package refers to in the packages of the tree, with references
+// from test files ignored unless tests is set. Methods, which may be called
+// through interfaces, and the symbols of main packages and test files are
+// never reported.
+func UnusedExports(ctx context.Context, opts Options, syms []Symbol, tests bool) ([]Symbol, []Error) {
+	used := make(map[*Symbol]bool)
+	errs := forEachReference(ctx, opts, syms, func(s *Symbol, from *packages.Package, pos token.Position) {
+		if !tests && strings.HasSuffix(pos.Filename, "_test.go") {
+			return
+		}
+		if path, err := filepath.Abs(s.Path); err == nil && filepath.Dir(pos.Filename) != filepath.Dir(path) {
+			used[s] = true
+		}
+	})
+	var unused []Symbol
+	for i := range syms {
+		s := &syms[i]
+		if !ast.IsExported(s.Name) || s.Receiver != "" || s.Package == "main" || strings.HasSuffix(s.Path, "_test.go") {
+			continue
+		}
+		if !used[s] {
+			unused = append(unused, *s)
+		}
+	}
+	return unused, errs
+}
+
+// forEachReference calls found with each identifier of the packages of the
+// tree opts.Dir that refers to one of syms, the package holding it and its
+// position. Each identifier is reported once, although test variants of
+// packages repeat their files.
+func forEachReference(ctx context.Context, opts Options, syms []Symbol, found func(s *Symbol, from *packages.Package, pos token.Position)) []Error {
+	byPos := symbolPositions(syms)
+	mode := packages.NeedName | packages.NeedFiles | packages.NeedSyntax | packages.NeedTypes | packages.NeedTypesInfo
+	pkgs, errs := loadTyped(ctx, opts, packageDirs(ctx, opts), mode)
+	seen := make(map[token.Position]bool)
+	for _, p := range pkgs {
+		if p.TypesInfo == nil {
+			continue
+		}
+		for id, obj := range p.TypesInfo.Uses {
+			if obj.Pkg() == nil {
+				continue // universe
+			}
+			s := byPos[positionOf(p.Fset, obj.Pos(), opts)]
+			if s == nil {
+				continue
+			}
+			pos := p.Fset.Position(id.Pos())
+			if seen[pos] {
+				continue
+			}
+			seen[pos] = true
+			found(s, p, pos)
+		}
+	}
+	return errs
+}
+
+// packageDirs returns the directories holding the Go packages of the tree
+// opts.Dir, found as Scan finds them.
+func packageDirs(ctx context.Context, opts Options) []string {
+	ctxt := build.Default  // copy
+	ctxt.GOPATH = opts.Dir // disable GOPATH
+	ctxt.GOROOT = ""
+	_, err := os.Stat(filepath.Join(filepath.SplitList(opts.Dir)[0], "src"))
+	haveSrcDir := err == nil
+
+	var dirs []string
+	seen := make(map[string]bool)
+	add := func(path, dir string, err error) {
+		if err != nil || path == "" || seen[dir] {
+			return
+		}
+		seen[dir] = true
+		if abs, err := filepath.Abs(dir); err == nil {
+			if files, _ := filepath.Glob(filepath.Join(abs, "*.go")); len(files) > 0 {
+				dirs = append(dirs, abs)
+			}
+		}
+	}
+	if opts.Packages || packagesDriver() != "" {
+		listPackages(ctx, &opts, haveSrcDir, func(path, dir, _ string, _ []string, err error) {
+			add(path, dir, err)
+		})
+	} else {
+		forEachPackage(ctx, &ctxt, haveSrcDir, opts.Deps, opts.OnlyPrefix, func(path, dir, _ string, err error) {
+			add(path, dir, err)
+		})
+	}
+	sort.Strings(dirs)
+	return dirs
+}
@@ -0,0 +1,816 @@
+// Package symbols finds the package-level functions, methods and types
+// declared in a Go source tree, as the gosymbols command does.
+package symbols
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Symbol is a function, method or type declaration. Its JSON encoding is
+// the one written by the gosymbols command.
+type Symbol struct {
+	Name       string `json:"name"`
+	Kind       string `json:"kind"` // "func" or "type"
+	Package    string `json:"package"`
+	ImportPath string `json:"importPath,omitempty"` // as found by the scan; empty for files read on their own
+	Vendored   bool   `json:"vendored,omitempty"`   // found in a vendor directory, with the canonical ImportPath
+	Path       string `json:"path"`
+	Line       int    `json:"line"`      // 0-based
+	Character  int    `json:"character"` // 0-based, in bytes
+	Receiver   string `json:"receiver,omitempty"`
+	Signature  string `json:"signature,omitempty"`
+	Summary    string `json:"summary,omitempty"` // of package symbols, set by PackageDocs
+	Source     string `json:"source,omitempty"`
+	Module     string `json:"module,omitempty"` // module@version of packages in the module cache
+	Build      string `json:"build,omitempty"`  // build constraint of the file, from its //go:build line and name
+
+	// Set by Typecheck.
+	Object    string `json:"object,omitempty"`    // "func", "method", "type", "alias" or "var"
+	Type      string `json:"type,omitempty"`      // signature of funcs, underlying or aliased type of types
+	DefinedIn string `json:"definedIn,omitempty"` // import path of the package as type-checked
+	Aliased   string `json:"aliased,omitempty"`   // of aliases of named types, the type's name qualified by import path
+
+	// Of generic funcs and types, and methods of generic types, set by
+	// Typecheck.
+	TypeParams []TypeParam `json:"typeParams,omitempty"`
+
+	// Set by SetImportable.
+	Importable *bool `json:"importable,omitempty"` // whether the packages at the root of the tree may refer to it
+
+	// Set by CountReferences for exported symbols.
+	References *int `json:"references,omitempty"`
+
+	// Set by Implements for named types.
+	Implements    []string `json:"implements,omitempty"`    // interfaces implemented by the type or its pointer
+	ImplementedBy []string `json:"implementedBy,omitempty"` // types implementing the interface
+
+	// Keys of the tags of fields, set by StructFields.
+	Tags map[string]string `json:"tags,omitempty"`
+
+	// Set by PromotedMethods, whose symbols share the position of the
+	// method of the embedded type.
+	Promoted bool `json:"promoted,omitempty"`
+
+	TypeKind string   `json:"-"` // "struct" or "interface" for such type declarations
+	Offset   int      `json:"-"` // byte offset of the name in the file
+	Embeds   []string `json:"-"` // types embedded in a struct or interface type
+	Doc      string   `json:"-"` // first sentence of the doc comment, with Options.Docs
+}
+
+// A TypeParam is a type parameter of a generic declaration, such as T in
+// [T Numeric].
+type TypeParam struct {
+	Name        string   `json:"name"`
+	Constraint  string   `json:"constraint"`            // qualified by import path
+	Constraints []string `json:"constraints,omitempty"` // named types the constraint refers to, such as example.com/foo.Numeric
+}
+
+// Options control a scan.
+type Options struct {
+	// Dir is the source tree to scan. If it has a src directory, it is
+	// a GOPATH, possibly a list of them, and only that is scanned.
+	Dir string
+
+	// Query selects the symbols whose names contain it, ignoring case.
+	// All symbols are found if it is empty.
+	Query string
+
+	// PackageName, if set, selects the symbols of packages declared with
+	// that name.
+	PackageName string
+
+	// OnlyPrefix, if set, selects the packages whose import paths start
+	// with one of its elements.
+	OnlyPrefix []string
+
+	WithSource bool // set Symbol.Source
+	Docs       bool // parse doc comments, setting Symbol.Doc
+
+	// IgnoreLineDirectives reports the positions of symbols as they are
+	// in the files scanned, rather than as //line directives map them to
+	// the sources generated files were produced from.
+	IgnoreLineDirectives bool
+
+	// Fast finds declarations by tokenizing files, parsing only their
+	// headers, rather than parsing whole files. It does not apply with
+	// Extractors, which need parsed files.
+	Fast bool
+
+	// Packages lists the packages to scan with the go command, through
+	// golang.org/x/tools/go/packages, rather than walking the tree. This
+	// follows modules, build constraints and vendoring, reading only the
+	// files built, but needs a go command. It is implied when a
+	// GOPACKAGESDRIVER, such as that of Bazel, lists the packages instead.
+	Packages bool
+
+	// Deps also scans the modules required by the module of Dir, from
+	// the module cache.
+	Deps bool
+
+	// Jobs is the most packages parsed in parallel. If zero, it adapts to
+	// whether the scan waits on the disk or the CPU.
+	Jobs int
+
+	// Cache, if set, is consulted before parsing each file and given all
+	// symbols of every file parsed, whatever the query.
+	Cache Cache
+
+	// Slowest is the number of packages and files that took longest to
+	// read and parse to report in Stats.
+	Slowest int
+
+	// Extractors find more symbols in each file parsed, which is then
+	// parsed with its comments. Symbols they find are stored in the Cache
+	// along with the others.
+	Extractors []Extractor
+
+	// Overlay maps file names to contents that replace those on disk,
+	// such as the unsaved buffers of an editor. Overlaid files bypass the
+	// Cache.
+	Overlay map[string][]byte
+
+	// Candidates, if not nil, holds the absolute names of the only files
+	// that can contain Query, found beforehand such as by ripgrep. Others
+	// are skipped without being read. It does not apply with Extractors,
+	// whose symbols may match without their names appearing in the file.
+	Candidates map[string]bool
+
+	// PackageTimeout, if positive, bounds the time spent reading and
+	// parsing each package, so that a pathological one such as a huge
+	// generated table cannot stall the scan. A package taking longer is
+	// abandoned and reported skipped, with none of its symbols, while its
+	// file being parsed is finished in the background.
+	PackageTimeout time.Duration
+
+	// Progress, if set, is called with the progress of the scan about
+	// twice a second if it changed, and last once the scan completes.
+	// Calls are made by the goroutine calling Scan, between those to
+	// found.
+	Progress func(Progress)
+}
+
+// Progress tells how far a scan has got. Until Listed, packages are still
+// being found, so Done/Packages underestimates the work left.
+type Progress struct {
+	Packages int  `json:"packages"` // found so far
+	Done     int  `json:"done"`     // packages scanned
+	Files    int  `json:"files"`    // files scanned
+	Symbols  int  `json:"symbols"`  // found so far
+	Listed   bool `json:"listed"`   // whether all packages have been found
+}
+
+// progressInterval is how often Scan reports its progress.
+const progressInterval = 500 * time.Millisecond
+
+// A Cache holds the symbols of files parsed before.
+type Cache interface {
+	// Lookup returns all symbols of the named file if it is unchanged
+	// since they were stored: if src is nil, judging by its size and
+	// modification time, otherwise by its contents.
+	Lookup(filename string, fi os.FileInfo, src []byte) ([]Symbol, bool)
+
+	// Store records all symbols of a file that was parsed.
+	Store(filename string, fi os.FileInfo, src []byte, syms []Symbol)
+}
+
+// An Error records a package that could not be read or parsed. The
+// symbols of the files that could be are still reported.
+type Error struct {
+	ImportPath string `json:"importPath"`
+	Dir        string `json:"dir"`
+	Phase      string `json:"phase,omitempty"` // in which the error occurred, such as PhaseParse
+	Message    string `json:"message"`
+}
+
+// A Skip records a package directory whose files were not scanned, and
+// why, so that users can tell why a package has no symbols.
+type Skip struct {
+	ImportPath string `json:"importPath"`
+	Dir        string `json:"dir"`
+	Reason     string `json:"reason"`
+	TimedOut   bool   `json:"timedOut,omitempty"`   // taking longer than Options.PackageTimeout
+	ShadowedBy string `json:"shadowedBy,omitempty"` // the copy in an earlier GOPATH entry, used instead
+}
+
+// skipped is the error with which packages are reported to be skipped,
+// for the given reason.
+type skipped string
+
+func (s skipped) Error() string { return string(s) }
+
+// A packageScan is what scanning a package found, merged into the summary
+// of the scan once it completes in time.
+type packageScan struct {
+	visited   bool // whether its files were read, so that it counts
+	syms      []Symbol
+	errs      []Error
+	skips     []Skip
+	files     int
+	parse     time.Duration
+	fileTimes []Timing
+}
+
+// A packageCache is the Cache used to scan a package with a timeout. Once
+// the package is abandoned it is no longer used, as the scan may have
+// returned.
+type packageCache struct {
+	cache     Cache
+	mu        sync.Mutex
+	abandoned bool
+}
+
+func (c *packageCache) Lookup(filename string, fi os.FileInfo, src []byte) ([]Symbol, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.abandoned {
+		return nil, false
+	}
+	return c.cache.Lookup(filename, fi, src)
+}
+
+func (c *packageCache) Store(filename string, fi os.FileInfo, src []byte, syms []Symbol) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.abandoned {
+		c.cache.Store(filename, fi, src, syms)
+	}
+}
+
+func (c *packageCache) abandon() {
+	c.mu.Lock()
+	c.abandoned = true
+	c.mu.Unlock()
+}
+
+// The phases of a scan in which errors occur.
+const (
+	PhaseList      = "list"      // finding the packages of the tree
+	PhaseRead      = "read"      // reading a package directory or file
+	PhaseParse     = "parse"     // parsing a file
+	PhaseTypeCheck = "typecheck" // loading and type-checking packages
+)
+
+// Stats counts the work done by a scan. Times are in milliseconds; Scan
+// sets those of walking and parsing.
+type Stats struct {
+	Packages int `json:"packages"`
+	Files    int `json:"files"`
+	Symbols  int `json:"symbols"`
+	Errors   int `json:"errors"`
+
+	ElapsedMs float64 `json:"elapsedMs"`
+	WalkMs    float64 `json:"walkMs"`             // until all packages were found
+	ParseMs   float64 `json:"parseMs"`            // summed over all workers
+	OutputMs  float64 `json:"outputMs,omitempty"` // writing the results
+
+	// With Options.Slowest, the packages and files that took longest.
+	SlowPackages []Timing `json:"slowPackages,omitempty"`
+	SlowFiles    []Timing `json:"slowFiles,omitempty"`
+
+	Walk, Parse time.Duration `json:"-"`
+}
+
+// A Timing is the time taken to read and parse a package or file.
+type Timing struct {
+	Name string  `json:"name"` // import path or file name
+	Ms   float64 `json:"ms"`
+
+	d time.Duration
+}
+
+// A Summary describes a completed scan.
+type Summary struct {
+	Errors  []Error
+	Skipped []Skip
+	Stats   Stats
+}
+
+// Search returns the symbols found by Scan, in no particular order. Its
+// error is that of ctx if it is done before the scan completes; packages
+// that could not be read or parsed are only reported by Scan.
+func Search(ctx context.Context, opts Options) ([]Symbol, error) {
+	var syms []Symbol
+	Scan(ctx, opts, func(found []Symbol) {
+		syms = append(syms, found...)
+	})
+	return syms, ctx.Err()
+}
+
+// SearchStream calls fn with each symbol found by Scan, as the packages
+// holding them are scanned. If fn returns an error, the scan stops, fn is
+// not called again and SearchStream returns that error. Otherwise its
+// error is that of ctx if it is done before the scan completes. The scan
+// waits while fn runs, so a slow consumer slows it down rather than
+// symbols piling up in memory.
+func SearchStream(ctx context.Context, opts Options, fn func(Symbol) error) error {
+	scanCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var err error
+	Scan(scanCtx, opts, func(found []Symbol) {
+		for _, s := range found {
+			if err != nil {
+				return
+			}
+			if err = fn(s); err != nil {
+				cancel()
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// Scan walks the source tree opts.Dir and calls found with the symbols
+// selected by opts in each package. Calls to found are serialized. Errors
+// reading or parsing packages do not stop the scan; they are recorded in
+// the returned summary. Once ctx is canceled, no more files are parsed.
+func Scan(ctx context.Context, opts Options, found func([]Symbol)) *Summary {
+	var mutex sync.Mutex
+	var errs []Error
+	var skips []Skip
+	sum := new(Summary)
+	start := time.Now()
+	query := strings.ToLower(opts.Query)
+
+	// Overlay files are looked up by absolute name.
+	overlay := make(map[string][]byte, len(opts.Overlay))
+	for name, src := range opts.Overlay {
+		if abs, err := filepath.Abs(name); err == nil {
+			overlay[abs] = src
+		}
+	}
+	opts.Overlay = overlay
+
+	ctxt := build.Default  // copy
+	ctxt.GOPATH = opts.Dir // disable GOPATH
+	ctxt.GOROOT = ""
+
+	pool := newWorkerPool(opts.Jobs)
+	var wg sync.WaitGroup
+
+	// Workers send the symbols of each package to the caller's goroutine,
+	// which hands them to found as they arrive.
+	results := make(chan []Symbol, pool.max)
+
+	_, err := os.Stat(filepath.Join(filepath.SplitList(opts.Dir)[0], "src"))
+	haveSrcDir := err == nil
+
+	// Here we can't use buildutil.ForEachPackage here since it only considers
+	// src dirs and this tool should be able to run against a golang source dir.
+	// The same package may be reachable from several GOPATH entries or
+	// through symlinks, so only the first occurrence of each resolved
+	// directory and import path is scanned.
+	seenDirs := make(map[string]bool)
+	seenPaths := make(map[string]bool)
+	names := newDirNames()
+
+	// As for the go command, a package is read from the first GOPATH
+	// entry holding it, whatever the order the entries are walked in.
+	var srcDirs []string
+	if haveSrcDir {
+		srcDirs = ctxt.SrcDirs()
+	}
+	var prog Progress // guarded by mutex
+	go func() {
+		// visit scans the package in pkgDir, reading goFiles or, if nil,
+		// all Go files in the directory.
+		visit := func(path, pkgDir, module string, goFiles []string, err error) {
+			if err != nil {
+				mutex.Lock()
+				if reason, ok := err.(skipped); ok {
+					skips = append(skips, Skip{ImportPath: path, Dir: pkgDir, Reason: string(reason)})
+				} else {
+					errs = append(errs, Error{path, pkgDir, PhaseList, err.Error()})
+				}
+				mutex.Unlock()
+				return
+			}
+			if path == "" || ctx.Err() != nil {
+				return
+			}
+			if len(srcDirs) > 1 {
+				if dir := shadowingDir(srcDirs, path, pkgDir, names); dir != "" {
+					mutex.Lock()
+					skips = append(skips, Skip{ImportPath: path, Dir: pkgDir, Reason: "shadowed by " + dir, ShadowedBy: dir})
+					mutex.Unlock()
+					return
+				}
+			}
+			// The module cache holds several versions of the same
+			// packages.
+			canon, key := canonicalDir(pkgDir, names), path+"@"+module
+			if seenDirs[canon] || seenPaths[key] {
+				return
+			}
+			seenDirs[canon] = true
+			seenPaths[key] = true
+			mutex.Lock()
+			prog.Packages++
+			mutex.Unlock()
+
+			// visitPackage visits the package in ctx, recording what it finds
+			// in ps and using pkgCache as the Cache. It closes started once
+			// it gets a worker, and done once it returns.
+			visitPackage := func(ctx context.Context, ps *packageScan, pkgCache Cache, started, done chan struct{}) {
+				defer close(done)
+				// A panic visiting a malformed package is recorded as
+				// its error, and the scan goes on.
+				defer func() {
+					if r := recover(); r != nil {
+						ps.errs = append(ps.errs, Error{path, pkgDir, PhaseParse, fmt.Sprintf("panic: %v", r)})
+					}
+				}()
+
+				pool.acquire()
+				close(started)
+				var ioTime time.Duration // reading the directory and files
+				workStart := time.Now()
+				defer func() {
+					pool.release(ioTime, time.Since(workStart)-ioTime)
+				}()
+				if ctx.Err() != nil {
+					return
+				}
+
+				// Each package gets its own FileSet, dropped with its
+				// ASTs once visited, since symbols record their
+				// positions as they are found.
+				fset := token.NewFileSet()
+
+				// Symbols of vendored packages get the import paths
+				// code refers to them by.
+				importPath, vendored := unvendoredPath(path)
+				label := func(syms []Symbol, build string) []Symbol {
+					for i := range syms {
+						syms[i].Vendored, syms[i].Build = vendored, build
+					}
+					return syms
+				}
+				v := &visitor{
+					importPath:     importPath,
+					vendored:       vendored,
+					module:         module,
+					fset:           fset,
+					query:          query,
+					packageName:    opts.PackageName,
+					withSource:     opts.WithSource,
+					lineDirectives: !opts.IgnoreLineDirectives,
+				}
+				parseStart := time.Now()
+				var files int
+				var fileTimes []Timing // with opts.Slowest
+				defer func() {
+					ps.visited = true
+					ps.syms, ps.files, ps.parse, ps.fileTimes = v.syms, files, time.Since(parseStart), fileTimes
+				}()
+
+				// Errors don't prevent searching the other files, so they
+				// are only recorded.
+				fail := func(phase string, err error) {
+					ps.errs = append(ps.errs, Error{path, pkgDir, phase, err.Error()})
+				}
+				readStart := time.Now()
+				list, err := readPackageDir(pkgDir, goFiles)
+				ioTime += time.Since(readStart)
+				if err != nil {
+					fail(PhaseRead, err)
+					return
+				}
+				if reason := noGoFiles(list); reason != "" {
+					ps.skips = append(ps.skips, Skip{ImportPath: path, Dir: pkgDir, Reason: reason})
+					return
+				}
+				mode := parser.AllErrors
+				if opts.Docs || len(opts.Extractors) > 0 {
+					mode |= parser.ParseComments
+				}
+				// With opts.Slowest, each file is timed until the next
+				// one starts.
+				var current string
+				var fileStart time.Time
+				endFile := func() {
+					if current != "" {
+						fileTimes = append(fileTimes, Timing{Name: current, d: time.Since(fileStart)})
+						current = ""
+					}
+				}
+				defer endFile()
+				for _, fi := range list {
+					if ctx.Err() != nil {
+						break
+					}
+					if !fi.Mode().IsRegular() || !strings.HasSuffix(fi.Name(), ".go") {
+						continue
+					}
+					filename := filepath.Join(pkgDir, fi.Name())
+					endFile()
+					if opts.Slowest > 0 {
+						current, fileStart = filename, time.Now()
+					}
+					var src []byte
+					cache := pkgCache
+					if len(overlay) > 0 {
+						if abs, err := filepath.Abs(filename); err == nil {
+							if s, ok := overlay[abs]; ok {
+								// Its symbols are not those of the file on disk.
+								src, cache = s, nil
+							}
+						}
+					}
+					if opts.Candidates != nil && src == nil && query != "" && len(opts.Extractors) == 0 {
+						if abs, err := filepath.Abs(filename); err == nil && !opts.Candidates[abs] {
+							// No identifier in the file can match.
+							files++
+							continue
+						}
+					}
+					if cache != nil {
+						if cached, ok := cache.Lookup(filename, fi, nil); ok {
+							v.reuse(cached)
+							files++
+							continue
+						}
+					}
+					if src == nil {
+						readStart := time.Now()
+						var err error
+						src, err = ioutil.ReadFile(longPath(filename))
+						ioTime += time.Since(readStart)
+						if err != nil {
+							fail(PhaseRead, err)
+							continue
+						}
+					}
+					if cache != nil {
+						if cached, ok := cache.Lookup(filename, fi, src); ok {
+							v.reuse(cached)
+							files++
+							continue
+						}
+					} else if query != "" && len(opts.Extractors) == 0 && !containsFold(src, query) {
+						// No identifier in the file can match.
+						files++
+						continue
+					}
+					if clean, ok := sanitize(src); ok {
+						// Not cached, so that later scans report it too.
+						fail(PhaseParse, fmt.Errorf("%s: replaced invalid UTF-8 or misplaced byte order marks", filename))
+						src, cache = clean, nil
+					}
+					build := fileConstraint(fi.Name(), src)
+					if opts.Fast && len(opts.Extractors) == 0 {
+						_, syms, err := fastSymbols(fset, importPath, module, filename, src, opts.Docs, opts.WithSource, !opts.IgnoreLineDirectives)
+						syms = label(syms, build)
+						if err != nil {
+							fail(PhaseParse, err)
+							cache = nil // so that later scans report them too
+						}
+						files++
+						v.reuse(syms)
+						if cache != nil {
+							cache.Store(filename, fi, src, syms)
+						}
+						continue
+					}
+					f, err := parser.ParseFile(fset, filename, src, mode)
+					if err != nil {
+						// The declarations that could be parsed are still
+						// reported. The file is not cached, so that its
+						// errors are reported by later scans too.
+						fail(PhaseParse, err)
+						if f == nil || f.Name == nil {
+							continue
+						}
+						cache = nil
+					}
+					if opts.WithSource {
+						// Only the current file's contents are kept.
+						v.sources = map[string][]byte{filename: src}
+					}
+					files++
+					if cache == nil {
+						if opts.PackageName == "" || f.Name.Name == opts.PackageName {
+							v.pkgName = f.Name.Name
+							v.build = build
+							v.broken = err != nil
+							n := len(v.syms)
+							ast.Inspect(f, v.Visit)
+							if err != nil {
+								v.reuse(label(recoverDecls(importPath, module, filename, src, opts.Docs, opts.WithSource, !opts.IgnoreLineDirectives, v.syms[n:]), build))
+							}
+							v.reuse(label(extract(opts.Extractors, fset, f, src, importPath, module, !opts.IgnoreLineDirectives, nil), build))
+						}
+						continue
+					}
+					// The cache records every symbol of the file, which
+					// are filtered afterwards.
+					all := &visitor{
+						pkgName:        f.Name.Name,
+						importPath:     importPath,
+						vendored:       vendored,
+						build:          build,
+						module:         module,
+						fset:           fset,
+						withSource:     opts.WithSource,
+						lineDirectives: !opts.IgnoreLineDirectives,
+						sources:        v.sources,
+					}
+					ast.Inspect(f, all.Visit)
+					all.syms = label(extract(opts.Extractors, fset, f, src, importPath, module, !opts.IgnoreLineDirectives, all.syms), build)
+					cache.Store(filename, fi, src, all.syms)
+					v.reuse(all.syms)
+				}
+			}
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() {
+					mutex.Lock()
+					prog.Done++
+					mutex.Unlock()
+				}()
+
+				ps := new(packageScan)
+				started, done := make(chan struct{}), make(chan struct{})
+				if opts.PackageTimeout <= 0 {
+					visitPackage(ctx, ps, opts.Cache, started, done)
+				} else {
+					// The package is visited in its own goroutine, so
+					// that it can be abandoned once it takes too long.
+					pkgCtx, cancel := context.WithCancel(ctx)
+					defer cancel()
+					var guarded *packageCache
+					var cache Cache
+					if opts.Cache != nil {
+						guarded = &packageCache{cache: opts.Cache}
+						cache = guarded
+					}
+					go visitPackage(pkgCtx, ps, cache, started, done)
+					select {
+					case <-started:
+					case <-done:
+					}
+					timer := time.NewTimer(opts.PackageTimeout)
+					defer timer.Stop()
+					select {
+					case <-done:
+					case <-timer.C:
+						cancel()
+						if guarded != nil {
+							guarded.abandon()
+						}
+						mutex.Lock()
+						skips = append(skips, Skip{ImportPath: path, Dir: pkgDir, Reason: fmt.Sprintf("timed out after %v", opts.PackageTimeout), TimedOut: true})
+						mutex.Unlock()
+						return
+					}
+				}
+
+				mutex.Lock()
+				errs = append(errs, ps.errs...)
+				skips = append(skips, ps.skips...)
+				if ps.visited {
+					sum.Stats.Packages++
+					sum.Stats.Files += ps.files
+					sum.Stats.Parse += ps.parse
+					if opts.Slowest > 0 {
+						sum.Stats.SlowPackages = append(sum.Stats.SlowPackages, Timing{Name: path, d: ps.parse})
+						sum.Stats.SlowFiles = append(sum.Stats.SlowFiles, ps.fileTimes...)
+					}
+				}
+				mutex.Unlock()
+				if ps.visited {
+					results <- ps.syms
+				}
+			}()
+		}
+		if opts.Packages || packagesDriver() != "" {
+			listPackages(ctx, &opts, haveSrcDir, visit)
+		} else {
+			forEachPackage(ctx, &ctxt, haveSrcDir, opts.Deps, opts.OnlyPrefix, func(path, pkgDir, module string, err error) {
+				visit(path, pkgDir, module, nil, err)
+			})
+		}
+		mutex.Lock()
+		sum.Stats.Walk = time.Since(start)
+		prog.Listed = true
+		mutex.Unlock()
+		wg.Wait()
+		close(results)
+	}()
+
+	var symbolCount int
+	var last Progress
+	report := func() {
+		mutex.Lock()
+		p := prog
+		p.Files = sum.Stats.Files
+		mutex.Unlock()
+		p.Symbols = symbolCount
+		if p != last {
+			last = p
+			opts.Progress(p)
+		}
+	}
+	var tick <-chan time.Time
+	if opts.Progress != nil {
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+loop:
+	for {
+		select {
+		case syms, ok := <-results:
+			if !ok {
+				break loop
+			}
+			symbolCount += len(syms)
+			found(syms)
+		case <-tick:
+			report()
+		}
+	}
+	if opts.Progress != nil {
+		report()
+	}
+
+	sum.Errors = errs
+	sum.Skipped = skips
+	sum.Stats.Errors = len(errs)
+	sum.Stats.SlowPackages = slowest(sum.Stats.SlowPackages, opts.Slowest)
+	sum.Stats.SlowFiles = slowest(sum.Stats.SlowFiles, opts.Slowest)
+	return sum
+}
+
+// File returns the symbols declared in the named Go file, whose contents
+// are src or, if src is nil, read from the file. Only the WithSource, Docs,
+// IgnoreLineDirectives, Fast and Extractors options apply; the symbols have no import path. If
+// the file has syntax errors, File returns them along with the symbols of
+// the declarations that could be parsed.
+func File(filename string, src []byte, opts Options) ([]Symbol, error) {
+	if src == nil {
+		var err error
+		if src, err = ioutil.ReadFile(longPath(filename)); err != nil {
+			return nil, err
+		}
+	}
+	src, _ = sanitize(src)
+	fset := token.NewFileSet()
+	build := fileConstraint(filepath.Base(filename), src)
+	if opts.Fast && len(opts.Extractors) == 0 {
+		_, syms, err := fastSymbols(fset, "", "", filename, src, opts.Docs, opts.WithSource, !opts.IgnoreLineDirectives)
+		for i := range syms {
+			syms[i].Build = build
+		}
+		return syms, err
+	}
+	mode := parser.AllErrors
+	if opts.Docs || len(opts.Extractors) > 0 {
+		mode |= parser.ParseComments
+	}
+	f, err := parser.ParseFile(fset, filename, src, mode)
+	if f == nil || f.Name == nil {
+		return nil, err
+	}
+	v := &visitor{pkgName: f.Name.Name, fset: fset, withSource: opts.WithSource, lineDirectives: !opts.IgnoreLineDirectives, broken: err != nil}
+	if opts.WithSource {
+		v.sources = map[string][]byte{filename: src}
+	}
+	ast.Inspect(f, v.Visit)
+	if err != nil {
+		v.syms = append(v.syms, recoverDecls("", "", filename, src, opts.Docs, opts.WithSource, !opts.IgnoreLineDirectives, v.syms)...)
+	}
+	syms := extract(opts.Extractors, fset, f, src, "", "", !opts.IgnoreLineDirectives, v.syms)
+	for i := range syms {
+		syms[i].Build = build
+	}
+	return syms, err
+}
+
+// slowest sorts times by decreasing duration, keeping the first n.
+func slowest(times []Timing, n int) []Timing {
+	sort.Slice(times, func(i, j int) bool { return times[i].d > times[j].d })
+	if len(times) > n {
+		times = times[:n]
+	}
+	for i := range times {
+		times[i].Ms = float64(times[i].d) / float64(time.Millisecond)
+	}
+	return times
+}
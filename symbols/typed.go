@@ -0,0 +1,307 @@
+package symbols
+
+import (
+	"context"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// Typecheck sets the Object, Type, DefinedIn, Aliased and TypeParams of
+// syms, symbols found by scanning the tree opts.Dir, by type-checking the
+// packages holding them with the go command or the packages driver. Symbols
+// of packages that cannot be loaded are left as they are, and the errors
+// returned; those of packages with type errors get what the type checker
+// could resolve. The Embeds of struct and interface types are replaced by
+// the embedded types qualified by import path, as resolved by the type
+// checker, even through dot imports. Importable is set as by SetImportable.
+func Typecheck(ctx context.Context, opts Options, syms []Symbol) []Error {
+	SetImportable(opts.Dir, syms)
+	byPos := symbolPositions(syms)
+	var dirs []string
+	seen := make(map[string]bool)
+	for pos := range byPos {
+		if dir := filepath.Dir(pos.path); !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Strings(dirs)
+
+	mode := packages.NeedName | packages.NeedFiles | packages.NeedSyntax | packages.NeedTypes | packages.NeedTypesInfo
+	pkgs, errs := loadTyped(ctx, opts, dirs, mode)
+	for _, p := range pkgs {
+		if p.TypesInfo == nil {
+			continue
+		}
+		for id, obj := range p.TypesInfo.Defs {
+			if obj == nil || obj.Pkg() == nil {
+				continue
+			}
+			if s := byPos[positionOf(p.Fset, id.Pos(), opts)]; s != nil {
+				setTypeInfo(s, obj)
+			}
+		}
+	}
+	return errs
+}
+
+// A position locates the name of a symbol, in an absolute file name.
+type position struct {
+	path            string
+	line, character int // 0-based, as in Symbol
+}
+
+// positionOf returns the position of pos as scans with opts report it. The
+// type checker reads the files cgo generates in place of the authored files
+// of packages using cgo; their //line directives, mapping declarations back
+// to the authored files, are followed whatever opts say.
+func positionOf(fset *token.FileSet, pos token.Pos, opts Options) position {
+	lineDirectives := !opts.IgnoreLineDirectives || cgoGenerated(fset.PositionFor(pos, false).Filename)
+	p := positionFor(fset, pos, lineDirectives)
+	return position{p.Filename, p.Line - 1, p.Column - 1}
+}
+
+// cgoGenerated reports whether filename is one of the files cgo generates
+// for a package. The go command hands them over from its build cache,
+// under names without the .go extension.
+func cgoGenerated(filename string) bool {
+	base := filepath.Base(filename)
+	return !strings.HasSuffix(base, ".go") || strings.HasSuffix(base, ".cgo1.go") || strings.HasPrefix(base, "_cgo_")
+}
+
+// cgoType matches the names cgo gives C types, qualified by the import path
+// of the package using them, such as example.com/foo._Ctype_int.
+var cgoType = regexp.MustCompile(`[\w.~+/-]*\._Ctype_`)
+
+// typeString returns t qualified by import paths, with C types written as
+// they are in the authored files, such as C.int.
+func typeString(t types.Type) string {
+	s := types.TypeString(t, nil)
+	if strings.Contains(s, "._Ctype_") {
+		s = cgoType.ReplaceAllString(s, "C.")
+	}
+	return s
+}
+
+// symbolPositions maps the positions of syms to them. Promoted methods are
+// left out, as they share the position of the method they stand for.
+func symbolPositions(syms []Symbol) map[position]*Symbol {
+	byPos := make(map[position]*Symbol, len(syms))
+	for i := range syms {
+		s := &syms[i]
+		if s.Promoted {
+			continue
+		}
+		if path, err := filepath.Abs(s.Path); err == nil {
+			byPos[position{path, s.Line, s.Character}] = s
+		}
+	}
+	return byPos
+}
+
+func setTypeInfo(s *Symbol, obj types.Object) {
+	switch obj := obj.(type) {
+	case *types.Func:
+		sig := obj.Type().(*types.Signature)
+		s.Object = "func"
+		if sig.Recv() != nil {
+			s.Object = "method"
+		}
+		s.Type = typeString(sig)
+		if sig.RecvTypeParams().Len() > 0 {
+			s.TypeParams = typeParams(sig.RecvTypeParams())
+		} else {
+			s.TypeParams = typeParams(sig.TypeParams())
+		}
+	case *types.TypeName:
+		s.Object = "type"
+		if obj.IsAlias() {
+			// Aliases of aliases are followed to the type they denote.
+			t := types.Unalias(obj.Type())
+			s.Object = "alias"
+			s.Type = typeString(t)
+			if named, ok := t.(*types.Named); ok && named.Obj().Pkg() != nil {
+				s.Aliased = qualifiedType(named.Origin().Obj())
+			}
+		} else {
+			s.Type = typeString(obj.Type().Underlying())
+			if named, ok := obj.Type().(*types.Named); ok {
+				s.TypeParams = typeParams(named.TypeParams())
+			}
+			if s.TypeKind != "" {
+				s.Embeds = embeddedTypes(obj.Type().Underlying())
+			}
+		}
+	case *types.Var:
+		s.Object = "var"
+		s.Type = typeString(obj.Type())
+	default:
+		return
+	}
+	s.DefinedIn = obj.Pkg().Path()
+}
+
+// typeParams returns the type parameters of list with their constraints.
+func typeParams(list *types.TypeParamList) []TypeParam {
+	var tparams []TypeParam
+	for i := 0; i < list.Len(); i++ {
+		tp := list.At(i)
+		tparams = append(tparams, TypeParam{
+			Name:        tp.Obj().Name(),
+			Constraint:  typeString(tp.Constraint()),
+			Constraints: constraintTypes(tp.Constraint(), nil),
+		})
+	}
+	return tparams
+}
+
+// constraintTypes appends to names the named types constraint t refers
+// to, qualified by import path: t itself if it is named, or those it
+// embeds or lists as terms of a union if it is an interface literal.
+// Predeclared ones, such as comparable, are left out.
+func constraintTypes(t types.Type, names []string) []string {
+	switch t := types.Unalias(t).(type) {
+	case *types.Named:
+		if t.Obj().Pkg() != nil {
+			names = append(names, qualifiedType(t.Origin().Obj()))
+		}
+	case *types.Interface:
+		for i := 0; i < t.NumEmbeddeds(); i++ {
+			names = constraintTypes(t.EmbeddedType(i), names)
+		}
+	case *types.Union:
+		for i := 0; i < t.Len(); i++ {
+			names = constraintTypes(t.Term(i).Type(), names)
+		}
+	}
+	return names
+}
+
+// embeddedTypes returns the named types a struct or interface type embeds,
+// qualified by import path.
+func embeddedTypes(t types.Type) []string {
+	var embeds []types.Type
+	switch t := t.(type) {
+	case *types.Struct:
+		for i := 0; i < t.NumFields(); i++ {
+			if f := t.Field(i); f.Embedded() {
+				embeds = append(embeds, f.Type())
+			}
+		}
+	case *types.Interface:
+		for i := 0; i < t.NumEmbeddeds(); i++ {
+			embeds = append(embeds, t.EmbeddedType(i))
+		}
+	}
+	var names []string
+	for _, e := range embeds {
+		if p, ok := e.(*types.Pointer); ok {
+			e = p.Elem()
+		}
+		if named, ok := types.Unalias(e).(*types.Named); ok && named.Obj().Pkg() != nil {
+			names = append(names, qualifiedType(named.Origin().Obj()))
+		}
+	}
+	return names
+}
+
+// loadTyped loads the packages in dirs, directories of the tree opts.Dir,
+// and their tests as mode requests. The go command is run once for each
+// module holding some of them, or once for the tree if it is a GOPATH.
+// Directories in neither are loaded in GOPATH mode, from the GOPATH whose
+// src directory holds them if there is one. Packages of modules are
+// type-checked for the language version of the go directive of their
+// go.mod, which loading their module tells the type checker.
+func loadTyped(ctx context.Context, opts Options, dirs []string, mode packages.LoadMode) ([]*packages.Package, []Error) {
+	root := filepath.SplitList(opts.Dir)[0]
+	_, err := os.Stat(filepath.Join(root, "src"))
+	gopath := err == nil && packagesDriver() == ""
+
+	// Directories are grouped by the one the go command runs in.
+	groups := make(map[string][]string)
+	for _, dir := range dirs {
+		key := dir
+		if gopath {
+			key = filepath.Join(root, "src")
+		} else if m := findModule(dir); m != nil {
+			key = m.dir
+		} else if src := srcDir(dir); src != "" {
+			key = src
+		}
+		groups[key] = append(groups[key], dir)
+	}
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var pkgs []*packages.Package
+	var errs []Error
+	reported := make(map[string]bool) // test variants repeat the errors of their package
+	for _, key := range keys {
+		if ctx.Err() != nil {
+			break
+		}
+		cfg := &packages.Config{
+			Context: ctx,
+			Mode:    mode | packages.NeedModule,
+			Dir:     key,
+			Tests:   true,
+			Overlay: opts.Overlay,
+		}
+		if gopath {
+			cfg.Env = append(os.Environ(), "GOPATH="+opts.Dir, "GO111MODULE=off")
+		} else if findModule(key) == nil && packagesDriver() == "" {
+			cfg.Env = append(os.Environ(), "GO111MODULE=off")
+			if filepath.Base(key) == "src" {
+				cfg.Env = append(cfg.Env, "GOPATH="+filepath.Dir(key))
+			}
+		}
+		loaded, err := packages.Load(cfg, groups[key]...)
+		if err != nil {
+			errs = append(errs, Error{Dir: key, Phase: PhaseTypeCheck, Message: "type-checking: " + err.Error()})
+			continue
+		}
+		for _, p := range loaded {
+			if strings.HasSuffix(p.ID, ".test") {
+				continue // a generated test main package
+			}
+			for _, e := range p.Errors {
+				if reported[e.Msg] {
+					continue
+				}
+				reported[e.Msg] = true
+				dir := ""
+				if len(p.GoFiles) > 0 {
+					dir = filepath.Dir(p.GoFiles[0])
+				}
+				errs = append(errs, Error{ImportPath: p.PkgPath, Dir: dir, Phase: PhaseTypeCheck, Message: "type-checking: " + e.Msg})
+			}
+			pkgs = append(pkgs, p)
+		}
+	}
+	return pkgs, errs
+}
+
+// srcDir returns the closest src directory holding dir, or "" if there is
+// none.
+func srcDir(dir string) string {
+	for {
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		if filepath.Base(parent) == "src" {
+			return parent
+		}
+		dir = parent
+	}
+}
@@ -0,0 +1,385 @@
+package symbols
+
+import (
+	"context"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"golang.org/x/mod/module"
+)
+
+// forEachPackage calls found with the import path and directory of each
+// package below the source directories of ctxt, or its GOPATH entries
+// themselves if they have no src directory, that is selected by prefixes.
+// Entries within a module have import paths following their go.mod files,
+// and with deps the modules they require are walked too. The module
+// cache is walked as the modules it holds, whose module@version is passed
+// to found.
+func forEachPackage(ctx context.Context, ctxt *build.Context, haveSrcDir, deps bool, prefixes []string, found func(importPath, dir, module string, err error)) {
+	// We use a counting semaphore to limit
+	// the number of parallel calls to ReadDir.
+	sema := make(chan bool, 20)
+
+	ch := make(chan item)
+
+	// Entries that are missing are reported once each rather than walked,
+	// and empty ones are ignored, as by the go command.
+	var entries []string
+	for _, dir := range filepath.SplitList(ctxt.GOPATH) {
+		if dir == "" {
+			continue
+		}
+		if fi, err := os.Stat(dir); err != nil {
+			found("", dir, "", err)
+			continue
+		} else if !fi.IsDir() {
+			found("", dir, "", fmt.Errorf("%s is not a directory", dir))
+			continue
+		}
+		entries = append(entries, dir)
+	}
+
+	var roots []walkRoot
+	if haveSrcDir {
+		for _, dir := range ctxt.SrcDirs() {
+			roots = append(roots, walkRoot{dir: dir})
+		}
+	} else {
+		for _, dir := range entries {
+			if isModuleCache(dir) {
+				roots = append(roots, walkRoot{dir: dir, moduleCache: true})
+				continue
+			}
+			root := walkRoot{dir: dir}
+			var mods []*goModule
+			var replace []replacement
+			if m := findModule(dir); m != nil {
+				root.importPath, root.modules = m.importPath(dir), true
+				mods = []*goModule{m}
+			}
+			if w := findWorkspace(dir); w != nil {
+				// All modules of the workspace are scanned.
+				root.modules = true
+				mods = w.modules
+				for _, m := range w.modules {
+					roots = append(roots, walkRoot{dir: m.dir, importPath: m.importPath(m.dir), modules: true})
+				}
+				for _, rep := range w.file.Replace {
+					replace = append(replace, replacement{rep, w.dir})
+				}
+			}
+			roots = append(roots, root)
+			if deps && len(mods) > 0 {
+				reqs, errs := requirements(mods, replace)
+				roots = append(roots, reqs...)
+				for _, err := range errs {
+					found("", dir, "", err)
+				}
+			}
+		}
+	}
+
+	// Roots may nest or resolve to the same directory, so each directory
+	// is walked from only one of them.
+	visited := &dirSet{m: make(map[string]bool)}
+
+	var wg sync.WaitGroup
+	for _, root := range roots {
+		root := root
+		wg.Add(1)
+		go func() {
+			allPackages(ctx, ctxt, sema, root, prefixes, visited, ch)
+			wg.Done()
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(ch)
+	}()
+
+	// All calls to found occur in the caller's goroutine.
+	for i := range ch {
+		found(i.importPath, i.dir, i.module, i.err)
+	}
+}
+
+// A walkRoot is a directory walked for packages.
+type walkRoot struct {
+	dir        string
+	importPath string // of dir
+	modules    bool   // import paths below follow go.mod files
+	skipNested bool   // don't walk nested modules
+
+	module      string // module@version of a module in the module cache
+	moduleCache bool   // dir is the module cache, holding modules below
+}
+
+type item struct {
+	importPath string
+	dir        string
+	module     string
+	err        error // (optional)
+}
+
+// A dirSet records the directories walked, by their canonical names.
+type dirSet struct {
+	mu sync.Mutex
+	m  map[string]bool
+}
+
+// add adds dir to the set, reporting whether it was not already there.
+func (s *dirSet) add(dir string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.m[dir] {
+		return false
+	}
+	s.m[dir] = true
+	return true
+}
+
+func allPackages(ctx context.Context, ctxt *build.Context, sema chan bool, r walkRoot, prefixes []string, visited *dirSet, ch chan<- item) {
+	root := filepath.Clean(r.dir) + string(os.PathSeparator)
+
+	// Only the root can be a symbolic link, since ReadDir doesn't report
+	// links as directories, so the directories below it are canonical
+	// once it is.
+	canonRoot := CanonicalDir(root)
+
+	var wg sync.WaitGroup
+
+	var walkDir func(dir, pkg string)
+	walkDir = func(dir, pkg string) {
+		// Avoid .foo, _foo, and testdata directory trees below the root,
+		// which may be named "." or "..".
+		base := filepath.Base(dir)
+		if dir != root && (base == "" || base[0] == '.' || base[0] == '_' || base == "testdata") {
+			return
+		}
+
+		// Prune search if we encounter any of these import paths.
+		switch pkg {
+		case "builtin":
+			return
+		}
+
+		// In the module cache, modules are in directories named after
+		// their escaped path and version, which are walked as roots.
+		// Downloaded archives are kept in cache.
+		if r.moduleCache && dir != root {
+			if dir == root+"cache" {
+				return
+			}
+			if mod, ok := cachedModule(strings.TrimPrefix(dir, root)); ok {
+				allPackages(ctx, ctxt, sema, walkRoot{dir: dir, importPath: mod.Path, modules: true, skipNested: true, module: mod.String()}, prefixes, visited, ch)
+				return
+			}
+		}
+
+		allowed, descend := PrefixAllowed(pkg, prefixes)
+		if r.moduleCache {
+			// Above the modules, pkg is an escaped path.
+			unescaped, _ := module.UnescapePath(pkg)
+			_, descend = PrefixAllowed(unescaped, prefixes)
+		}
+		if !allowed && !descend || ctx.Err() != nil {
+			return
+		}
+		if !visited.add(filepath.Join(canonRoot, strings.TrimPrefix(dir, root))) {
+			return
+		}
+
+		sema <- true
+		files, err := ioutil.ReadDir(longPath(dir))
+		<-sema
+		if r.modules && dir != root {
+			for _, fi := range files {
+				if fi.Name() != "go.mod" {
+					continue
+				}
+				if r.skipNested {
+					return
+				}
+				if m := readModule(dir); m != nil {
+					pkg = m.importPath(dir)
+				}
+			}
+		}
+		if (pkg != "" || err != nil) && allowed && !r.moduleCache {
+			ch <- item{pkg, dir, r.module, err}
+		}
+		for _, fi := range files {
+			fi := fi
+			if fi.IsDir() {
+				wg.Add(1)
+				go func() {
+					walkDir(filepath.Join(dir, fi.Name()), path.Join(pkg, fi.Name()))
+					wg.Done()
+				}()
+			}
+		}
+	}
+
+	walkDir(root, r.importPath)
+	wg.Wait()
+}
+
+// shadowingDir returns the directory of the package with the given import
+// path in the first of the GOPATH source directories srcDirs holding Go
+// files for it, if that is not pkgDir, its directory in another: the go
+// command only reads the first. It returns "" if pkgDir is that first.
+func shadowingDir(srcDirs []string, importPath, pkgDir string, names *dirNames) string {
+	canon := canonicalDir(pkgDir, names)
+	for _, src := range srcDirs {
+		dir := filepath.Join(src, filepath.FromSlash(importPath))
+		if canonicalDir(dir, names) == canon {
+			return ""
+		}
+		if list, err := readPackageDir(dir, nil); err == nil && hasGoFiles(list) {
+			return dir
+		}
+	}
+	return ""
+}
+
+// hasGoFiles reports whether the directory listing list holds Go files.
+func hasGoFiles(list []os.FileInfo) bool {
+	for _, fi := range list {
+		if fi.Mode().IsRegular() && strings.HasSuffix(fi.Name(), ".go") {
+			return true
+		}
+	}
+	return false
+}
+
+// unvendoredPath returns the import path by which code refers to a
+// vendored package with import path path, such as github.com/pkg/errors for
+// example.com/foo/vendor/github.com/pkg/errors, and whether it is one.
+func unvendoredPath(path string) (string, bool) {
+	if i := strings.LastIndex(path, "/vendor/"); i >= 0 {
+		return path[i+len("/vendor/"):], true
+	}
+	if strings.HasPrefix(path, "vendor/") {
+		return path[len("vendor/"):], true
+	}
+	return path, false
+}
+
+// PrefixAllowed reports whether the package with the given import path is
+// selected by prefixes, as by Options.OnlyPrefix, and whether its directory
+// must still be descended into to reach a selected package further down.
+func PrefixAllowed(pkg string, prefixes []string) (allowed, descend bool) {
+	if len(prefixes) == 0 {
+		return true, true
+	}
+	if pkg == "" {
+		return false, true
+	}
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(pkg+"/", prefix) {
+			return true, true
+		}
+		if strings.HasPrefix(prefix, pkg+"/") {
+			descend = true
+		}
+	}
+	return false, descend
+}
+
+// CanonicalDir returns the absolute, symlink-free form of dir, or the
+// cleaned dir itself if it cannot be resolved. Where file systems usually
+// ignore case, its elements are spelled as in their directories, so that
+// directories reached with different casing compare equal.
+func CanonicalDir(dir string) string {
+	return canonicalDir(dir, nil)
+}
+
+// canonicalDir returns CanonicalDir(dir), reading the entries of the
+// directories it spells from names, if not nil.
+func canonicalDir(dir string, names *dirNames) string {
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = resolved
+	}
+	dir = filepath.Clean(dir)
+	if foldsCase {
+		dir = trueCase(dir, names)
+	}
+	return dir
+}
+
+// foldsCase is set on the systems whose file systems ignore the case of
+// names by default.
+var foldsCase = runtime.GOOS == "darwin" || runtime.GOOS == "windows"
+
+// dirNames caches the entries of the directories read by trueCase, by
+// directory. A scan holds one for its duration only, so that directories
+// renamed since are spelled anew by the next.
+type dirNames struct {
+	mu sync.Mutex
+	m  map[string][]string
+}
+
+func newDirNames() *dirNames {
+	return &dirNames{m: make(map[string][]string)}
+}
+
+// list returns the names of the entries of dir, read once if c is not nil.
+func (c *dirNames) list(dir string) []string {
+	if c == nil {
+		names, _ := readDirNames(dir)
+		return names
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	names, ok := c.m[dir]
+	if !ok {
+		names, _ = readDirNames(dir)
+		c.m[dir] = names
+	}
+	return names
+}
+
+// trueCase returns the absolute, clean path with each element spelled as
+// its directory lists it, read from names. Elements that cannot be found
+// are left as they are.
+func trueCase(path string, names *dirNames) string {
+	vol := filepath.VolumeName(path)
+	dir := strings.ToUpper(vol) + string(filepath.Separator)
+	for _, elem := range strings.Split(path[len(vol):], string(filepath.Separator)) {
+		if elem == "" {
+			continue
+		}
+		spelled := elem
+		for _, name := range names.list(dir) {
+			if name == elem {
+				spelled = elem
+				break
+			}
+			if strings.EqualFold(name, elem) {
+				spelled = name
+			}
+		}
+		dir = filepath.Join(dir, spelled)
+	}
+	return dir
+}
+
+// readDirNames returns the names of the entries of dir.
+func readDirNames(dir string) ([]string, error) {
+	f, err := os.Open(longPath(dir))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Readdirnames(-1)
+}
@@ -0,0 +1,30 @@
+package symbols
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTrueCaseAfterRename(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "Foo"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	names := newDirNames()
+	if got, want := trueCase(filepath.Join(root, "foo"), names), filepath.Join(root, "Foo"); got != want {
+		t.Fatalf("trueCase = %s, want %s", got, want)
+	}
+
+	// A case-only rename is seen by the next scan, holding its own
+	// names.
+	if err := os.Rename(filepath.Join(root, "Foo"), filepath.Join(root, "FOO")); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := trueCase(filepath.Join(root, "foo"), newDirNames()), filepath.Join(root, "FOO"); got != want {
+		t.Errorf("trueCase after renaming = %s, want %s", got, want)
+	}
+	if got, want := trueCase(filepath.Join(root, "foo"), nil), filepath.Join(root, "FOO"); got != want {
+		t.Errorf("trueCase uncached = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// A trigramIndex maps each trigram of the lower-cased names of a list of
+// symbols to the positions in the list of the symbols containing it, in
+// increasing order. A query then only needs to look at the symbols holding
+// all of its trigrams.
+type trigramIndex map[uint32][]uint32
+
+func trigram(s string, i int) uint32 {
+	return uint32(s[i])<<16 | uint32(s[i+1])<<8 | uint32(s[i+2])
+}
+
+// newTrigramIndex indexes the n symbols whose names are returned by name.
+func newTrigramIndex(n int, name func(i int) string) trigramIndex {
+	t := make(trigramIndex)
+	for i := 0; i < n; i++ {
+		s := strings.ToLower(name(i))
+		for j := 0; j+3 <= len(s); j++ {
+			g := trigram(s, j)
+			if list := t[g]; len(list) == 0 || list[len(list)-1] != uint32(i) {
+				t[g] = append(list, uint32(i))
+			}
+		}
+	}
+	return t
+}
+
+// candidates returns the positions of the symbols that may match the
+// lower-cased query, or false if the query is too short to narrow them.
+func (t trigramIndex) candidates(query string) ([]uint32, bool) {
+	if t == nil {
+		return nil, false
+	}
+	return trigramCandidates(query, func(g uint32) []uint32 { return t[g] })
+}
+
+// trigramCandidates returns the positions of the symbols that may match the
+// lower-cased query, given the positions of those holding each trigram.
+func trigramCandidates(query string, postings func(g uint32) []uint32) ([]uint32, bool) {
+	if len(query) < 3 {
+		return nil, false
+	}
+	var lists [][]uint32
+	for j := 0; j+3 <= len(query); j++ {
+		list := postings(trigram(query, j))
+		if len(list) == 0 {
+			return nil, true
+		}
+		lists = append(lists, list)
+	}
+	sort.Slice(lists, func(i, j int) bool { return len(lists[i]) < len(lists[j]) })
+	result := lists[0]
+	for _, list := range lists[1:] {
+		result = intersect(result, list)
+		if len(result) == 0 {
+			break
+		}
+	}
+	return result, true
+}
+
+// intersect returns the elements common to the sorted lists a and b.
+func intersect(a, b []uint32) []uint32 {
+	var out []uint32
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			out = append(out, a[i])
+			i++
+			j++
+		}
+	}
+	return out
+}
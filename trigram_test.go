@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// testNames are symbol names of mixed case, including some whose bytes
+// change as their case is lowered.
+var testNames = []string{"NewReader", "ReadAll", "readerAt", "HTTPServer", "ServeHTTP", "x", "ab", "ÜberZeit", "ȺȺȺFoo", "Read"}
+
+// testQueries returns the lower-cased substrings of names, up to six bytes
+// long, and some queries matching none of them.
+func testQueries(names []string) []string {
+	queries := []string{"", "zzz", "qxj", "readerx", "servehttpz"}
+	for _, name := range names {
+		s := strings.ToLower(name)
+		for i := 0; i < len(s); i++ {
+			for j := i + 1; j <= len(s) && j-i <= 6; j++ {
+				queries = append(queries, s[i:j])
+			}
+		}
+	}
+	return queries
+}
+
+func TestTrigramCandidatesSuperset(t *testing.T) {
+	idx := newTrigramIndex(len(testNames), func(i int) string { return testNames[i] })
+	for _, query := range testQueries(testNames) {
+		candidates, ok := idx.candidates(query)
+		if !ok {
+			if len(query) >= 3 {
+				t.Errorf("candidates(%q) not found by trigrams", query)
+			}
+			continue
+		}
+		in := make(map[uint32]bool)
+		for _, c := range candidates {
+			in[c] = true
+		}
+		for i, name := range testNames {
+			if strings.Contains(strings.ToLower(name), query) && !in[uint32(i)] {
+				t.Errorf("candidates(%q) = %v lack %s", query, candidates, name)
+			}
+		}
+	}
+}
+
+func TestBloomFilterSuperset(t *testing.T) {
+	b := newBloomFilter(testNames)
+	for _, query := range testQueries(testNames) {
+		for _, name := range testNames {
+			if strings.Contains(strings.ToLower(name), query) && !b.mayContain(query) {
+				t.Errorf("mayContain(%q) = false, but %s contains it", query, name)
+			}
+		}
+	}
+	empty := newBloomFilter(nil)
+	if empty.mayContain("read") {
+		t.Errorf("an empty filter may contain read")
+	}
+	if !empty.mayContain("") {
+		t.Errorf("an empty filter may not contain the empty query")
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"flag"
+
+	"github.com/newhook/go-symbols/symbols"
+)
+
+var (
+	typedFlag          = flag.Bool("typed", false, "type-check the packages holding the results, reporting the object kind, type and defining package of each symbol")
+	refsFlag           = flag.Bool("refs", false, "count the references to each exported symbol from the packages of the tree, type-checking them all")
+	withImplementsFlag = flag.Bool("with-implements", false, "report the interfaces of the tree each type implements and the types implementing each interface")
+	promotedFlag       = flag.Bool("promoted", false, "also report the methods types gain by embedding, under the embedding type, type-checking the packages of the tree")
+)
+
+// typeChecked returns a source holding back the symbols of source until it
+// finishes, then passing them to check, which type-checks packages to add
+// to them. Its errors are reported with the others.
+func typeChecked(source symbolSource, check func(context.Context, symbols.Options, []symbol) []scanError) symbolSource {
+	return func(ctx context.Context, dir, query string, found func([]symbol)) *scanSummary {
+		var syms []symbol
+		sum := source(ctx, dir, query, func(batch []symbol) {
+			syms = append(syms, batch...)
+		})
+		if ctx.Err() == nil && len(syms) > 0 {
+			errs := check(ctx, typeCheckOptions(dir), syms)
+			sum.errors = append(sum.errors, errs...)
+			sum.stats.Errors += len(errs)
+		}
+		if len(syms) > 0 {
+			found(syms)
+		}
+		return sum
+	}
+}
+
+// withPromoted returns a source adding to the symbols of source the
+// promoted methods of the types of the tree matching the query, once
+// source finishes.
+func withPromoted(source symbolSource) symbolSource {
+	return func(ctx context.Context, dir, query string, found func([]symbol)) *scanSummary {
+		sum := source(ctx, dir, query, found)
+		if ctx.Err() != nil {
+			return sum
+		}
+		promoted, errs := symbols.PromotedMethods(ctx, typeCheckOptions(dir))
+		sum.errors = append(sum.errors, errs...)
+		sum.stats.Errors += len(errs)
+		var matched []symbol
+		filter := currentFilter()
+		for _, s := range promoted {
+			if filter.match(s, query) {
+				matched = append(matched, s)
+			}
+		}
+		if len(matched) > 0 {
+			found(matched)
+		}
+		return sum
+	}
+}
+
+// typeCheckOptions returns the options selecting the packages of dir to
+// type-check, as those scanned.
+func typeCheckOptions(dir string) symbols.Options {
+	return symbols.Options{
+		Dir:        dir,
+		OnlyPrefix: onlyPrefix,
+		Packages:   *packagesFlag,
+		Deps:       *depsFlag,
+		Overlay:    overlay,
+
+		IgnoreLineDirectives: *ignoreLinesFlag,
+	}
+}
+
+// setImplements sets the implements relations of the symbols of files,
+// those of the tree dir, returning the errors of type-checking it.
+func setImplements(ctx context.Context, dir string, files map[string]*cachedFile) []scanError {
+	names := sortedFiles(files)
+	var syms []symbol
+	for _, name := range names {
+		syms = append(syms, files[name].syms...)
+	}
+	errs := symbols.Implements(ctx, typeCheckOptions(dir), syms)
+	for _, name := range names {
+		n := copy(files[name].syms, syms)
+		syms = syms[n:]
+	}
+	return errs
+}
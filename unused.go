@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"sort"
+
+	"github.com/newhook/go-symbols/symbols"
+)
+
+var ignoreTestsFlag = flag.Bool("ignore-tests", false, "with unused-exports, ignore references from test files")
+
+// runUnusedExports implements the unused-exports command, which writes the
+// exported funcs and types of the tree that are not referred to outside
+// their package.
+func runUnusedExports(args []string) error {
+	dir, _ := parseArgs(args)
+	format, err := outputFormat()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	var syms []symbol
+	sum := scan(ctx, dir, "", func(found []symbol) {
+		syms = append(syms, found...)
+	})
+	unused, errs := symbols.UnusedExports(ctx, typeCheckOptions(dir), syms, !*ignoreTestsFlag)
+	for _, e := range append(sum.errors, errs...) {
+		// Their symbols may seem unused, or hide uses of others.
+		logger.Warn("could not read package", "dir", e.Dir, "err", e.Message)
+		partial = true
+	}
+	if unused == nil {
+		unused = []symbol{}
+	}
+	sort.Slice(unused, func(i, j int) bool { return symbolOrders["path"](unused[i], unused[j]) })
+	rewritePaths(unused)
+	return format(os.Stdout, unused)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+)
+
+var verifyFlag = flag.Bool("verify", false, "with index, check the index of dir for corruption rather than build it")
+
+// verifyIndex implements index -verify, which decodes the whole index at
+// path and reports the files in it that are corrupted, if any. It exits as
+// partial if the index cannot be used as it is.
+func verifyIndex(path string) error {
+	idx, err := readIndex(path)
+	if os.IsNotExist(err) {
+		return err
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stdout, err)
+		partial = true
+		return nil
+	}
+	defer idx.close()
+	bad := idx.verify()
+	for _, name := range bad {
+		fmt.Fprintf(os.Stdout, "%s: corrupted\n", name)
+	}
+	if len(bad) > 0 {
+		fmt.Fprintf(os.Stdout, "index %s: %d of %d files corrupted\n", path, len(bad), len(idx.Files))
+		partial = true
+		return nil
+	}
+	fmt.Fprintf(os.Stdout, "index %s: version %d, %d files, ok\n", path, idx.Version, len(idx.Files))
+	return nil
+}
+
+// verify decodes all files of idx and returns the paths of those that are
+// corrupted.
+func (idx *symbolIndex) verify() []string {
+	for i := range idx.Files {
+		idx.Files[i].entries()
+	}
+	return idx.corrupted()
+}
+
+// corrupted returns the paths of the files of idx found corrupted when
+// their entries were decoded.
+func (idx *symbolIndex) corrupted() []string {
+	var bad []string
+	for i := range idx.Files {
+		if idx.Files[i].corrupt {
+			bad = append(bad, idx.Files[i].Path)
+		}
+	}
+	return bad
+}
+
+// repairIndex rebuilds the index of dir and saves it, parsing again only
+// the files of idx that are corrupted, or all files if idx is nil. It is
+// built as the index command built idx, whatever the flags of the command
+// repairing it.
+func repairIndex(ctx context.Context, dir string, idx *symbolIndex) (*symbolIndex, error) {
+	path, err := indexFile(dir)
+	if err != nil {
+		return nil, err
+	}
+	var old map[string]*cachedFile
+	fields, packageDocs, implements := *fieldsFlag, *packageDocsFlag, *withImplementsFlag
+	if idx != nil {
+		old = idx.cachedFiles()
+		fields, packageDocs, implements = idx.Fields, idx.PackageDocs, idx.Implements
+	}
+
+	// The flags the index command does not take are cleared meanwhile.
+	pkgName, source, docs := *pkgNameFlag, *withSource, parseDocs
+	wasFields, wasPackageDocs, wasImplements := *fieldsFlag, *packageDocsFlag, *withImplementsFlag
+	*pkgNameFlag, *withSource = "", false
+	*fieldsFlag, *packageDocsFlag, *withImplementsFlag = fields, packageDocs, implements
+	defer func() {
+		*pkgNameFlag, *withSource, parseDocs = pkgName, source, docs
+		*fieldsFlag, *packageDocsFlag, *withImplementsFlag = wasFields, wasPackageDocs, wasImplements
+	}()
+
+	rebuilt, _, _ := buildIndex(ctx, dir, newFileSymbolCache(old))
+	if ctx.Err() != nil {
+		return rebuilt, nil // incomplete, so not saved
+	}
+	return rebuilt, saveIndex(path, rebuilt)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+)
+
+// A vimError is the result sent to Vim in place of an envelope when a
+// request cannot be answered.
+type vimError struct {
+	Error string `json:"error"`
+}
+
+// runVim implements the vim command, which speaks the JSON protocol of Vim
+// and Neovim channels on standard input and output. Each line read is a
+// request [id, params], where params are the fields of a serve request, and
+// is answered with [id, envelope] on a line of its own, or [id, {"error":
+// message}] if it fails. Lines that are not requests are answered with id 0,
+// which Vim passes to the channel callback. It holds symbols like serve.
+func runVim(args []string) error {
+	dir, _ := parseArgs(args)
+	srv := newSymbolServer(dir)
+	srv.loadAndWatch(context.Background())
+
+	r := bufio.NewScanner(os.Stdin)
+	w := bufio.NewWriter(os.Stdout)
+	for r.Scan() {
+		if len(r.Bytes()) == 0 {
+			continue
+		}
+		id, result := srv.vimCall(r.Bytes())
+		if err := writeRPCMessage(w, []interface{}{id, result}); err != nil {
+			return err
+		}
+	}
+	return r.Err()
+}
+
+// vimCall returns the id of the Vim channel message line and its result.
+func (s *symbolServer) vimCall(line []byte) (json.Number, interface{}) {
+	var msg []json.RawMessage
+	if err := json.Unmarshal(line, &msg); err != nil {
+		return "0", vimError{err.Error()}
+	}
+	var id json.Number
+	if len(msg) != 2 || json.Unmarshal(msg[0], &id) != nil {
+		return "0", vimError{"not a request [id, params]"}
+	}
+	var req serveRequest
+	if err := json.Unmarshal(msg[1], &req); err != nil {
+		return id, vimError{err.Error()}
+	}
+	env, err := s.answer(req)
+	if err != nil {
+		return id, vimError{err.Error()}
+	}
+	completeEnvelope(&env)
+	return id, env
+}
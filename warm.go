@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"os/exec"
+)
+
+var warmWait = flag.Bool("wait", false, "with warm, index in the foreground rather than in a background process")
+
+// warmJobs is the parallelism of warm unless -j is given, low so that
+// indexing doesn't compete with the editor that started it.
+const warmJobs = 2
+
+// runWarm implements the warm command, which refreshes the index of a tree
+// in a background process at low priority, so that editors can run it when
+// a workspace is opened and the first search is already fast.
+func runWarm(args []string) error {
+	dir, _ := parseArgs(args)
+	if !*warmWait {
+		return startWarm(args)
+	}
+
+	if !flagSet("j") {
+		*jobsFlag = warmJobs
+	}
+	if err := lowerPriority(); err != nil {
+		logger.Warn("not lowering priority", "err", err)
+	}
+	return runIndex([]string{dir})
+}
+
+// startWarm starts this command again with -wait, without waiting for it.
+func startWarm(warmArgs []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	args := []string{"warm", "-wait"}
+	flag.VisitAll(func(f *flag.Flag) {
+		if flagSet(f.Name) {
+			args = append(args, "-"+f.Name+"="+f.Value.String())
+		}
+	})
+	cmd := exec.Command(exe, append(args, warmArgs...)...)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	return cmd.Process.Release()
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDelay is how long the server waits after a change for further
+// changes before updating, so that a checkout or a save touching many files
+// causes a single update.
+const watchDelay = 200 * time.Millisecond
+
+// watch keeps the symbols held by s up to date with the Go files of the
+// workspace as they are created, modified or deleted. It returns once the
+// workspace is being watched.
+func (s *symbolServer) watch() error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	s.watcher = w
+	for _, root := range filepath.SplitList(s.dir) {
+		if err := watchTree(w, root); err != nil {
+			w.Close()
+			return err
+		}
+	}
+	go func() {
+		var timer <-chan time.Time
+		for {
+			select {
+			case ev, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				observeWatchEvent(ev)
+				if ev.Has(fsnotify.Create) {
+					if fi, err := os.Stat(ev.Name); err == nil && fi.IsDir() {
+						// New directories must be watched too, and may
+						// already hold files.
+						watchTree(w, ev.Name)
+						timer = time.After(watchDelay)
+						continue
+					}
+				}
+				if strings.HasSuffix(ev.Name, ".go") && !ev.Has(fsnotify.Chmod) {
+					timer = time.After(watchDelay)
+				}
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				logger.Error("watching for changes", "dir", s.dir, "err", err)
+			case <-timer:
+				timer = nil
+				s.load()
+			}
+		}
+	}()
+	return nil
+}
+
+// close stops watching the workspace of s.
+func (s *symbolServer) close() {
+	if s.watcher != nil {
+		s.watcher.Close()
+	}
+}
+
+// runWatch implements the watch command, which writes the symbols matching
+// the query and then, each time files change, writes them again if they
+// changed.
+func runWatch(args []string) error {
+	dir, query := parseArgs(args)
+	format, err := outputFormat()
+	if err != nil {
+		return err
+	}
+	srv := &symbolServer{dir: dir}
+	srv.load()
+	if err := srv.watch(); err != nil {
+		return err
+	}
+	less := symbolOrders["path"]
+	var last []symbol
+	for {
+		updated := srv.updates()
+		syms := make([]symbol, 0)
+		srv.source(context.Background(), dir, query, func(found []symbol) {
+			syms = append(syms, found...)
+		})
+		sort.Slice(syms, func(i, j int) bool { return less(syms[i], syms[j]) })
+		if last == nil || !reflect.DeepEqual(syms, last) {
+			last = syms
+			out := append([]symbol(nil), syms...)
+			rewritePaths(out)
+			if err := format(os.Stdout, out); err != nil {
+				return err
+			}
+		}
+		<-updated
+	}
+}
+
+// watchTree adds the directories that may hold packages under root to w.
+// Removed directories are dropped by fsnotify itself.
+func watchTree(w *fsnotify.Watcher, root string) error {
+	return forEachWatched(root, w.Add)
+}
+
+// forEachWatched calls fn with each directory under root that watchTree
+// watches.
+func forEachWatched(root string, fn func(dir string) error) error {
+	return filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.IsDir() {
+			return nil
+		}
+		// Avoid .foo, _foo, and testdata directory trees, like the scan.
+		if base := fi.Name(); path != root && (base[0] == '.' || base[0] == '_' || base == "testdata") {
+			return filepath.SkipDir
+		}
+		return fn(path)
+	})
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// websocketGUID is appended to the key of a WebSocket handshake to accept
+// it, as RFC 6455 specifies.
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// maxWebSocketMessage bounds the size of messages read from clients, which
+// are queries.
+const maxWebSocketMessage = 1 << 16
+
+// WebSocket opcodes.
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+// streamBatch is the number of symbols in each message answering a query
+// over /symbols/stream, so that the best matches are shown first.
+const streamBatch = 50
+
+// allowedOrigins holds the origins of web pages, besides the server's own,
+// that may open /symbols/stream.
+var allowedOrigins listFlag
+
+func init() {
+	flag.Var(&allowedOrigins, "allow-origin", "comma-separated `origins`, such as https://example.com, whose pages may open /symbols/stream besides the server's own; * allows any")
+}
+
+// A wsConn is the server side of a WebSocket connection, spoken directly
+// over a hijacked HTTP/1.1 connection.
+type wsConn struct {
+	conn net.Conn
+	rw   *bufio.ReadWriter
+	mu   sync.Mutex // held while writing frames
+}
+
+// isWebSocket reports whether r asks to be upgraded to a WebSocket.
+func isWebSocket(r *http.Request) bool {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return false
+	}
+	for _, v := range strings.Split(r.Header.Get("Connection"), ",") {
+		if strings.EqualFold(strings.TrimSpace(v), "upgrade") {
+			return true
+		}
+	}
+	return false
+}
+
+// originAllowed reports whether the page that opened the WebSocket request
+// r, if any, may read from it: browsers let any page open WebSockets to any
+// server, sending its origin, so that a page visited could otherwise read
+// the symbols of a server on localhost.
+func originAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true // not sent by a browser
+	}
+	for _, o := range allowedOrigins {
+		if o == "*" || strings.EqualFold(strings.TrimSuffix(o, "/"), origin) {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// upgradeWebSocket completes the handshake of the WebSocket request r,
+// answering it with an error if it is not one.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet || !isWebSocket(r) || key == "" {
+		err := fmt.Errorf("WebSocket requests only")
+		writeHTTPError(w, err)
+		return nil, err
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		err := fmt.Errorf("unsupported WebSocket version %q", r.Header.Get("Sec-WebSocket-Version"))
+		writeHTTPJSON(w, http.StatusUpgradeRequired, map[string]string{"error": err.Error()})
+		return nil, err
+	}
+	if !originAllowed(r) {
+		err := fmt.Errorf("origin %q not allowed; see -allow-origin", r.Header.Get("Origin"))
+		writeHTTPJSON(w, http.StatusForbidden, map[string]string{"error": err.Error()})
+		return nil, err
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		err := fmt.Errorf("WebSocket needs HTTP/1.1")
+		writeHTTPError(w, err)
+		return nil, err
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", base64.StdEncoding.EncodeToString(sum[:]))
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, rw: rw}, nil
+}
+
+// readMessage returns the next message sent by the client, answering pings
+// meanwhile. It returns io.EOF once the client closes the connection.
+func (c *wsConn) readMessage() ([]byte, error) {
+	var msg []byte
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case wsPing:
+			if err := c.writeFrame(wsPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			if len(payload) > 2 {
+				payload = payload[:2] // the status code
+			}
+			c.writeFrame(wsClose, payload)
+			return nil, io.EOF
+		}
+		msg = append(msg, payload...)
+		if len(msg) > maxWebSocketMessage {
+			return nil, fmt.Errorf("WebSocket message larger than %d bytes", maxWebSocketMessage)
+		}
+		if fin {
+			return msg, nil
+		}
+	}
+}
+
+// readFrame reads a frame, which clients must mask, and unmasks its
+// payload.
+func (c *wsConn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var h [2]byte
+	if _, err := io.ReadFull(c.rw, h[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin, op = h[0]&0x80 != 0, h[0]&0x0f
+	n := uint64(h[1] & 0x7f)
+	switch n {
+	case 126:
+		var b [2]byte
+		if _, err := io.ReadFull(c.rw, b[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err := io.ReadFull(c.rw, b[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(b[:])
+	}
+	if h[1]&0x80 == 0 {
+		return false, 0, nil, fmt.Errorf("unmasked WebSocket frame")
+	}
+	if n > maxWebSocketMessage {
+		return false, 0, nil, fmt.Errorf("WebSocket frame larger than %d bytes", maxWebSocketMessage)
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(c.rw, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(c.rw, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, op, payload, nil
+}
+
+// writeFrame writes a single unmasked frame, as servers send them.
+func (c *wsConn) writeFrame(op byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.writeFrameLocked(op, payload)
+}
+
+func (c *wsConn) writeFrameLocked(op byte, payload []byte) error {
+	h := []byte{0x80 | op}
+	switch n := len(payload); {
+	case n < 126:
+		h = append(h, byte(n))
+	case n <= 0xffff:
+		h = binary.BigEndian.AppendUint16(append(h, 126), uint16(n))
+	default:
+		h = binary.BigEndian.AppendUint64(append(h, 127), uint64(n))
+	}
+	c.rw.Write(h)
+	c.rw.Write(payload)
+	return c.rw.Flush()
+}
+
+// A streamResult is a message answering a query sent over /symbols/stream:
+// a batch of its symbols, from the best match down.
+type streamResult struct {
+	Seq     int      `json:"seq"` // of the query among the messages of the client, from 1
+	Query   string   `json:"query"`
+	Offset  int      `json:"offset"` // of the first symbol among all results
+	Symbols []symbol `json:"symbols"`
+	Total   int      `json:"total"`
+	Done    bool     `json:"done"` // set on the last batch
+}
+
+// handleSymbolStream answers the queries a client sends over a WebSocket,
+// each message being the text typed so far. Each query supersedes the
+// previous one, whose remaining results are not sent. The kind and limit
+// parameters apply to all queries; limit defaults to 100.
+func handleSymbolStream(s *symbolServer, w http.ResponseWriter, r *http.Request) {
+	kind := r.URL.Query().Get("kind")
+	limit := 100
+	if l := r.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 0 {
+			writeHTTPError(w, fmt.Errorf("invalid limit %q", l))
+			return
+		}
+		limit = n
+	}
+	c, err := upgradeWebSocket(w, r)
+	if err != nil {
+		return
+	}
+	defer c.conn.Close()
+
+	base, stop := context.WithCancel(context.Background())
+	defer stop()
+	var superseded context.CancelFunc
+	for seq := 1; ; seq++ {
+		msg, err := c.readMessage()
+		if err != nil {
+			if err != io.EOF {
+				logger.Debug("reading from WebSocket", "err", err)
+			}
+			return
+		}
+		if superseded != nil {
+			superseded()
+		}
+		ctx, cancel := context.WithCancel(base)
+		superseded = cancel
+		go s.streamQuery(ctx, c, seq, string(msg), kind, limit)
+	}
+}
+
+// streamQuery sends the symbols matching query to c in batches, ranked by
+// how well their names match, until ctx is canceled by the next query.
+// Once it is, nothing more is sent, so that all results of a query precede
+// those of the next.
+func (s *symbolServer) streamQuery(ctx context.Context, c *wsConn, seq int, query, kind string, limit int) {
+	lower := strings.ToLower(query)
+	syms := make([]symbol, 0)
+	s.source(ctx, s.dir, lower, func(found []symbol) {
+		for _, sym := range found {
+			if kind == "" || sym.Kind == kind {
+				syms = append(syms, sym)
+			}
+		}
+	})
+	if ctx.Err() != nil {
+		return
+	}
+	rankSymbols(syms, lower)
+	if limit > 0 && len(syms) > limit {
+		syms = syms[:limit]
+	}
+	rewritePaths(syms)
+	for off := 0; ; off += streamBatch {
+		end := off + streamBatch
+		if end > len(syms) {
+			end = len(syms)
+		}
+		b, err := json.Marshal(streamResult{seq, query, off, syms[off:end], len(syms), end == len(syms)})
+		if err != nil {
+			return
+		}
+		c.mu.Lock()
+		if ctx.Err() == nil {
+			err = c.writeFrameLocked(wsText, b)
+		}
+		c.mu.Unlock()
+		if err != nil || ctx.Err() != nil || end == len(syms) {
+			return
+		}
+	}
+}
+
+// rankSymbols sorts syms matching query, which is lower case, from the best
+// match down: names equal to it, then starting with it, then containing it
+// at the start of a word, then elsewhere; shorter names first, and then by
+// position.
+func rankSymbols(syms []symbol, query string) {
+	ranks := make(map[string]int)
+	rank := func(name string) int {
+		r, ok := ranks[name]
+		if !ok {
+			r = matchRank(name, query)
+			ranks[name] = r
+		}
+		return r
+	}
+	less := symbolOrders["path"]
+	sort.SliceStable(syms, func(i, j int) bool {
+		a, b := syms[i], syms[j]
+		if ra, rb := rank(a.Name), rank(b.Name); ra != rb {
+			return ra < rb
+		}
+		if len(a.Name) != len(b.Name) {
+			return len(a.Name) < len(b.Name)
+		}
+		return less(a, b)
+	})
+}
+
+// matchRank returns how well name matches query, which is lower case: 0
+// if it is equal, 1 if name starts with it, 2 if it starts a word of name
+// such as the Reader of NewReader or the reader of new_reader, and 3
+// otherwise.
+func matchRank(name, query string) int {
+	lower := strings.ToLower(name)
+	switch {
+	case lower == query:
+		return 0
+	case strings.HasPrefix(lower, query):
+		return 1
+	}
+	if len(lower) != len(name) {
+		// Lowering case changed the length of name, so offsets in lower
+		// do not fall on the same characters of name.
+		return 3
+	}
+	for i := 0; query != ""; {
+		j := strings.Index(lower[i:], query)
+		if j < 0 {
+			break
+		}
+		i += j
+		if name[i-1] == '_' || isUpper(name[i]) && !isUpper(name[i-1]) {
+			return 2
+		}
+		i++
+	}
+	return 3
+}
+
+func isUpper(c byte) bool { return 'A' <= c && c <= 'Z' }
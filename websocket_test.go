@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMatchRank(t *testing.T) {
+	tests := []struct {
+		name, query string
+		want        int
+	}{
+		{"Reader", "reader", 0},
+		{"ReaderAt", "reader", 1},
+		{"NewReader", "reader", 2},
+		{"new_reader", "reader", 2},
+		{"Threader", "reader", 3},
+		{"ÜberReader", "reader", 2},
+		{"ȺȺȺFoo", "foo", 3}, // Ⱥ lowers to a longer ⱥ
+		{"ȺȺȺFoo", "ⱥⱥⱥfoo", 0},
+		{"ȺȺȺFoo", "ⱥⱥ", 1},
+	}
+	for _, tt := range tests {
+		if got := matchRank(tt.name, tt.query); got != tt.want {
+			t.Errorf("matchRank(%q, %q) = %d, want %d", tt.name, tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestRankSymbolsNonASCII(t *testing.T) {
+	syms := []symbol{{Name: "ȺȺȺFoo"}, {Name: "NewFoo"}, {Name: "Foo"}}
+	rankSymbols(syms, "foo")
+	var got []string
+	for _, s := range syms {
+		got = append(got, s.Name)
+	}
+	want := []string{"Foo", "NewFoo", "ȺȺȺFoo"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("rankSymbols = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestOriginAllowed(t *testing.T) {
+	defer func(o listFlag) { allowedOrigins = o }(allowedOrigins)
+	tests := []struct {
+		origin string
+		allow  listFlag
+		want   bool
+	}{
+		{"", nil, true},
+		{"http://localhost:7171", nil, true},
+		{"https://evil.example", nil, false},
+		{"http://localhost:8080", nil, false},
+		{"https://tools.example", listFlag{"https://tools.example/"}, true},
+		{"https://evil.example", listFlag{"https://tools.example"}, false},
+		{"https://evil.example", listFlag{"*"}, true},
+	}
+	for _, tt := range tests {
+		allowedOrigins = tt.allow
+		r := httptest.NewRequest(http.MethodGet, "http://localhost:7171/symbols/stream", nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if got := originAllowed(r); got != tt.want {
+			t.Errorf("originAllowed(%q) with -allow-origin %q = %v, want %v", tt.origin, tt.allow, got, tt.want)
+		}
+	}
+}
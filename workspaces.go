@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/newhook/go-symbols/symbols"
+)
+
+// A workspaceSet holds the workspaces registered with the serve command,
+// each with its own symbols and watcher, so that one process serves all
+// of a developer's open projects.
+type workspaceSet struct {
+	mu      sync.RWMutex
+	servers map[string]*symbolServer // by canonical directory
+
+	ready     chan struct{} // closed once the initial workspace is loaded
+	readyOnce sync.Once
+}
+
+func newWorkspaceSet() *workspaceSet {
+	return &workspaceSet{servers: make(map[string]*symbolServer), ready: make(chan struct{})}
+}
+
+// setReady records that the workspace given on the command line, if any,
+// is loaded, so that the set is ready to answer queries.
+func (ws *workspaceSet) setReady() {
+	ws.readyOnce.Do(func() { close(ws.ready) })
+}
+
+// isReady reports whether setReady was called.
+func (ws *workspaceSet) isReady() bool {
+	select {
+	case <-ws.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// workspaceKey returns the key of the workspace of dir, which may be a list
+// of directories.
+func workspaceKey(dir string) string {
+	list := filepath.SplitList(absDirs(dir))
+	for i, d := range list {
+		list[i] = symbols.CanonicalDir(d)
+	}
+	return strings.Join(list, string(filepath.ListSeparator))
+}
+
+// register scans dir and starts watching it, unless it is registered
+// already, and returns its server.
+func (ws *workspaceSet) register(dir string) *symbolServer {
+	key := workspaceKey(dir)
+	ws.mu.RLock()
+	srv := ws.servers[key]
+	ws.mu.RUnlock()
+	if srv != nil {
+		return srv
+	}
+
+	srv = newSymbolServer(dir)
+	srv.restoreSnapshot()
+	srv.loadAndWatch(context.Background())
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if prev := ws.servers[key]; prev != nil {
+		srv.close() // registered concurrently
+		return prev
+	}
+	ws.servers[key] = srv
+	logger.Info("registered workspace", "dir", srv.dir)
+	return srv
+}
+
+// unregister stops watching the workspace of dir and drops its symbols.
+func (ws *workspaceSet) unregister(dir string) error {
+	key := workspaceKey(dir)
+	ws.mu.Lock()
+	srv := ws.servers[key]
+	delete(ws.servers, key)
+	ws.mu.Unlock()
+	if srv == nil {
+		return fmt.Errorf("workspace %s is not registered", dir)
+	}
+	srv.close()
+	logger.Info("unregistered workspace", "dir", srv.dir)
+	return nil
+}
+
+// lookup returns the server of the workspace of dir, which may be empty if
+// only one is registered.
+func (ws *workspaceSet) lookup(dir string) (*symbolServer, error) {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+	if dir == "" {
+		switch len(ws.servers) {
+		case 0:
+			return nil, fmt.Errorf("no workspace is registered")
+		case 1:
+		default:
+			return nil, fmt.Errorf("%d workspaces are registered; name one as the root", len(ws.servers))
+		}
+		for _, srv := range ws.servers {
+			return srv, nil
+		}
+	}
+	srv := ws.servers[workspaceKey(dir)]
+	if srv == nil {
+		return nil, fmt.Errorf("workspace %s is not registered", dir)
+	}
+	return srv, nil
+}
+
+// list returns the status of each registered workspace, by directory.
+func (ws *workspaceSet) list() []serverStatus {
+	ws.mu.RLock()
+	list := make([]serverStatus, 0, len(ws.servers))
+	for _, srv := range ws.servers {
+		list = append(list, srv.status())
+	}
+	ws.mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Dir < list[j].Dir })
+	return list
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"sort"
+)
+
+// A zoektDocument holds the symbol fields of a Zoekt index.Document, with
+// Zoekt's field names, for merging into the document of the same name
+// when building shards.
+type zoektDocument struct {
+	Name            string
+	Symbols         []zoektSection
+	SymbolsMetaData []zoektSymbol
+}
+
+// A zoektSection is the byte range of a symbol name in its file.
+type zoektSection struct {
+	Start, End uint32
+}
+
+// A zoektSymbol describes the symbol of the section at the same index. Its
+// kinds are those of universal-ctags, which Zoekt ranks by.
+type zoektSymbol struct {
+	Sym        string
+	Kind       string
+	Parent     string
+	ParentKind string
+}
+
+// writeZoekt writes a zoektDocument per file, one per line, sorted by
+// path. Symbols are sorted by offset, as Zoekt requires; those whose name
+// is not found at their position, as in a file changed since it was
+// scanned, are left out.
+func writeZoekt(w io.Writer, syms []symbol) error {
+	byPath := make(map[string][]symbol)
+	var paths []string
+	for _, s := range syms {
+		if _, ok := byPath[s.Path]; !ok {
+			paths = append(paths, s.Path)
+		}
+		byPath[s.Path] = append(byPath[s.Path], s)
+	}
+	sort.Strings(paths)
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	enc.SetEscapeHTML(false)
+	for _, path := range paths {
+		src, err := ioutil.ReadFile(sourcePath(path))
+		if err != nil {
+			continue
+		}
+		starts := lineStarts(src)
+		doc := zoektDocument{Name: path}
+		var secs []zoektSection
+		var metas []zoektSymbol
+		for _, s := range byPath[path] {
+			start, ok := nameOffset(src, starts, s)
+			if !ok {
+				continue
+			}
+			end := start + len(s.Name)
+			kind, parent, parentKind := universalCtagsKind(s)
+			secs = append(secs, zoektSection{uint32(start), uint32(end)})
+			metas = append(metas, zoektSymbol{s.Name, kind, parent, parentKind})
+		}
+		if len(secs) == 0 {
+			continue
+		}
+		order := make([]int, len(secs))
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(i, j int) bool { return secs[order[i]].Start < secs[order[j]].Start })
+		for i, k := range order {
+			if i > 0 && secs[k].Start == secs[order[i-1]].Start {
+				continue // the same name reported twice
+			}
+			doc.Symbols = append(doc.Symbols, secs[k])
+			doc.SymbolsMetaData = append(doc.SymbolsMetaData, metas[k])
+		}
+		if err := enc.Encode(doc); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}